@@ -2,6 +2,7 @@ package redis
 
 import (
 	"fmt"
+	"io"
 	"reflect"
 	"strconv"
 	"sync"
@@ -28,18 +29,62 @@ type Args interface {
 
 	// Next reads the next value from the argument list into dst, which must be
 	// a pointer.
+	//
+	// If dst is an io.Writer the value is copied to it, implementations reading
+	// from network connections stream bulk strings without loading them in
+	// memory.
 	Next(dst interface{}) bool
 }
 
 // List creates an argument list from a sequence of values.
+//
+// Maps with string keys and structs are expanded into sequences of field/value
+// pairs, which makes it possible to pass them as arguments of commands like
+// HSET or HMSET. The field names of structs are taken from the `redis` struct
+// tag, or the field name if the tag is missing, fields tagged with `redis:"-"`
+// are ignored and the `omitempty` option skips fields with zero values. Map keys
+// are sorted so the order of the arguments is deterministic.
+//
+// Time values found in maps or structs are encoded in the RFC3339 format with
+// nanosecond precision, values implementing encoding.BinaryMarshaler are encoded
+// to the bytes they marshal to.
+//
+// Values of type *BulkReader are streamed when the list is written to a
+// connection.
 func List(args ...interface{}) Args {
-	list := make([]interface{}, len(args))
-	copy(list, args)
-	return &argsList{
-		dec: objconv.StreamDecoder{
-			Parser: objconv.NewValueParser(list),
-		},
+	list, err := appendFields(make([]interface{}, 0, len(args)), args)
+	if err != nil {
+		return newArgsError(err)
+	}
+
+	var (
+		parts []Args
+		start int
+	)
+
+	for i, arg := range list {
+		r, ok := arg.(*BulkReader)
+		if !ok {
+			continue
+		}
+
+		if i != start {
+			parts = append(parts, newArgsList(list[start:i]))
+		}
+
+		parts = append(parts, &readerArgs{r: r})
+		start = i + 1
+	}
+
+	if parts == nil {
+		return newArgsList(list)
+	}
+
+	if start != len(list) {
+		parts = append(parts, newArgsList(list[start:]))
 	}
+
+	return MultiArgs(parts...)
 }
 
 // Int parses an integer value from the list of arguments and closes it,
@@ -63,13 +108,58 @@ func String(args Args) (s string, err error) {
 	return
 }
 
+// Lookup parses a single nullable value from the list of arguments into dst and
+// closes it. The returned boolean is false if the value was a nil reply, like
+// the one of a GET command on a missing key, in which case dst is left
+// unchanged.
+//
+// Lookup makes it possible to distinguish nil replies from empty values, both
+// decode into empty strings when read into a string.
+func Lookup(args Args, dst interface{}) (found bool, err error) {
+	v := reflect.ValueOf(dst)
+
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		args.Close()
+		return false, fmt.Errorf("redis: Lookup expects a non-nil pointer but got %T", dst)
+	}
+
+	p := reflect.New(v.Type())
+
+	if err = ParseArgs(args, p.Interface()); err != nil || p.Elem().IsNil() {
+		return
+	}
+
+	v.Elem().Set(p.Elem().Elem())
+	return true, nil
+}
+
+// LookupString parses a nullable string value from the list of arguments and
+// closes it, the returned boolean is false if the value was a nil reply.
+func LookupString(args Args) (s string, found bool, err error) {
+	found, err = Lookup(args, &s)
+	return
+}
+
 // ParseArgs reads a list of arguments into a sequence of destination pointers
 // and closes it, returning any error that occurred while parsing the values.
+//
+// When a destination is a pointer to a map with string keys or to a struct, all
+// the remaining values are consumed as a sequence of field/value pairs, like the
+// reply of HGETALL. Struct fields are matched using the same naming rules than
+// List, fields missing from the struct are ignored.
 func ParseArgs(args Args, dsts ...interface{}) error {
 	if args == nil && len(dsts) != 0 {
 		return ErrNilArgs
 	}
 	for _, dst := range dsts {
+		if isFieldsDst(dst) {
+			if err := parseFields(args, reflect.ValueOf(dst).Elem()); err != nil {
+				args.Close()
+				return err
+			}
+			continue
+		}
+
 		if !args.Next(dst) {
 			break
 		}
@@ -236,6 +326,14 @@ type argsList struct {
 	done chan<- error
 }
 
+func newArgsList(list []interface{}) *argsList {
+	return &argsList{
+		dec: objconv.StreamDecoder{
+			Parser: objconv.NewValueParser(list),
+		},
+	}
+}
+
 func newArgsReader(p *resp.Parser, done chan<- error) *argsList {
 	return &argsList{
 		dec:  objconv.StreamDecoder{Parser: p},
@@ -293,9 +391,56 @@ func (args *argsList) Next(val interface{}) bool {
 		}
 	}
 
+	if w, ok := val.(io.Writer); ok {
+		var b []byte
+
+		if args.dec.Decode(&b) != nil {
+			return false
+		}
+
+		_, args.err = w.Write(b)
+		return args.err == nil
+	}
+
+	if v := reflect.ValueOf(val); v.Kind() == reflect.Ptr && !v.IsNil() && isUnmarshalerType(v.Type().Elem()) {
+		return args.nextUnmarshaler(v.Elem())
+	}
+
 	return args.dec.Decode(val) == nil
 }
 
+// nextUnmarshaler decodes the next value into v, which is a time.Time or a type
+// implementing encoding.TextUnmarshaler or encoding.BinaryUnmarshaler, the same
+// way than values read from byte arguments.
+func (args *argsList) nextUnmarshaler(v reflect.Value) bool {
+	var val interface{}
+
+	if args.dec.Decode(&val) != nil {
+		return false
+	}
+
+	var a []byte
+
+	switch x := val.(type) {
+	case nil:
+		parseNil(v)
+		return true
+	case []byte:
+		a = x
+	case string:
+		a = []byte(x)
+	default:
+		a = []byte(fmt.Sprint(x))
+	}
+
+	if _, err := parseUnmarshaler(v, a); err != nil {
+		args.err = err
+		return false
+	}
+
+	return true
+}
+
 type byteArgs struct {
 	args [][]byte
 	err  error
@@ -316,15 +461,32 @@ func (args *byteArgs) Next(dst interface{}) (ok bool) {
 	}
 	a := args.args[0]
 	args.args = args.args[1:]
-	args.err = args.next(reflect.ValueOf(dst), a)
+
+	if w, ok := dst.(io.Writer); ok {
+		_, args.err = w.Write(a)
+	} else {
+		args.err = args.next(reflect.ValueOf(dst), a)
+	}
+
 	return args.err == nil
 }
 
 func (args *byteArgs) next(v reflect.Value, a []byte) error {
+	if a == nil {
+		return parseNil(v)
+	}
+
 	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
 		v = v.Elem()
 	}
 
+	if ok, err := parseUnmarshaler(v, a); ok {
+		return err
+	}
+
 	switch v.Kind() {
 	case reflect.Bool:
 		return args.parseBool(v, a)
@@ -353,6 +515,21 @@ func (args *byteArgs) next(v reflect.Value, a []byte) error {
 	return fmt.Errorf("unsupported output type for value in argument of a redis command: %s", v.Type())
 }
 
+// parseNil sets the value pointed by v to its zero value, which is how nil replies
+// are decoded. Decoding into pointers yields nil pointers, which makes it possible
+// to distinguish them from empty values.
+func parseNil(v reflect.Value) error {
+	for v.Kind() == reflect.Ptr && !v.CanSet() {
+		v = v.Elem()
+	}
+
+	if v.CanSet() {
+		v.Set(reflect.Zero(v.Type()))
+	}
+
+	return nil
+}
+
 func (args *byteArgs) parseBool(v reflect.Value, a []byte) error {
 	i, err := objutil.ParseInt(a)
 	if err != nil {
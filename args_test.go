@@ -1,10 +1,16 @@
 package redis_test
 
 import (
+	"context"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/golib/assert"
 
 	redis "github.com/dolab/redis-go"
+	"github.com/dolab/redis-go/redistest"
 )
 
 func TestList(t *testing.T) {
@@ -84,3 +90,205 @@ func testArgsReadValues(t *testing.T, makeArgs makeArgsFunc) {
 		t.Logf("found:    %#v", values)
 	}
 }
+
+type testUser struct {
+	Name    string    `redis:"name"`
+	Age     int       `redis:"age"`
+	Email   *string   `redis:"email,omitempty"`
+	Created time.Time `redis:"created"`
+	Ignored string    `redis:"-"`
+	Nick    string
+}
+
+func TestParseArgs_Fields(t *testing.T) {
+	it := assert.New(t)
+
+	email := "bob@example.com"
+	created := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	user := testUser{
+		Name:    "bob",
+		Age:     42,
+		Email:   &email,
+		Created: created,
+		Ignored: "ignored",
+		Nick:    "bobby",
+	}
+
+	var (
+		mutex sync.Mutex
+		hash  map[string]string
+	)
+
+	srv, addr := redistest.FakeServer(redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		cmd := r.Cmds[0]
+
+		switch cmd.Cmd {
+		case "HSET":
+			var key string
+
+			hash = nil
+			if err := cmd.ParseArgs(&key, &hash); err != nil {
+				w.Write(err)
+				return
+			}
+			w.Write(len(hash))
+
+		case "HGETALL":
+			cmd.Args.Close()
+
+			list := make([]string, 0, 2*len(hash))
+			for field, value := range hash {
+				list = append(list, field, value)
+			}
+			w.Write(list)
+		}
+	}))
+	defer srv.Close()
+
+	tr := &redis.Transport{}
+	defer tr.CloseIdleConnections()
+
+	client := &redis.Client{Addr: addr, Transport: tr, Timeout: time.Second}
+
+	n, err := redis.Int(client.Query(context.Background(), "HSET", "user", &user))
+	if it.Nil(err) {
+		it.Equal(5, n)
+		it.Equal(map[string]string{
+			"name":    "bob",
+			"age":     "42",
+			"email":   "bob@example.com",
+			"created": "2020-01-02T03:04:05.000000006Z",
+			"Nick":    "bobby",
+		}, hash)
+	}
+
+	var found testUser
+
+	if it.Nil(redis.ParseArgs(client.Query(context.Background(), "HGETALL", "user"), &found)) {
+		it.Equal("bob", found.Name)
+		it.Equal(42, found.Age)
+		it.Equal(&email, found.Email)
+		it.True(created.Equal(found.Created))
+		it.Empty(found.Ignored)
+		it.Equal("bobby", found.Nick)
+	}
+
+	var fields map[string]string
+
+	if it.Nil(redis.ParseArgs(client.Query(context.Background(), "HGETALL", "user"), &fields)) {
+		it.Equal(hash, fields)
+	}
+}
+
+func TestList_Fields(t *testing.T) {
+	it := assert.New(t)
+
+	args := redis.List("key", map[string]interface{}{"b": 2, "a": "1"}, testUser{Name: "bob"})
+
+	var values []string
+	for {
+		var value string
+		if !args.Next(&value) {
+			break
+		}
+		values = append(values, value)
+	}
+
+	if it.Nil(args.Close()) {
+		it.Equal([]string{
+			"key",
+			"a", "1", "b", "2",
+			"name", "bob", "age", "0", "created", "0001-01-01T00:00:00Z", "Nick", "",
+		}, values)
+	}
+}
+
+func TestParseArgs_Unmarshaler(t *testing.T) {
+	it := assert.New(t)
+
+	var (
+		tm   time.Time
+		unix time.Time
+		ip   testIP
+	)
+
+	if it.Nil(redis.ParseArgs(redis.List("2020-01-02T03:04:05Z", 1577934245, "127.0.0.1"), &tm, &unix, &ip)) {
+		it.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), tm.UTC())
+		it.Equal(tm.Unix(), unix.Unix())
+		it.Equal(testIP("127.0.0.1"), ip)
+	}
+}
+
+type testIP string
+
+func (ip *testIP) UnmarshalText(b []byte) error {
+	*ip = testIP(b)
+	return nil
+}
+
+func TestLookup(t *testing.T) {
+	it := assert.New(t)
+
+	values := map[string]string{"key": "value", "empty": ""}
+
+	srv, addr := redistest.FakeServer(redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+		var key string
+
+		r.Cmds[0].ParseArgs(&key)
+
+		if value, ok := values[key]; ok {
+			w.Write(value)
+		} else {
+			w.Write(nil)
+		}
+	}))
+	defer srv.Close()
+
+	tr := &redis.Transport{}
+	defer tr.CloseIdleConnections()
+
+	client := &redis.Client{Addr: addr, Transport: tr, Timeout: time.Second}
+
+	tests := []struct {
+		key   string
+		value string
+		found bool
+	}{
+		{key: "key", value: "value", found: true},
+		{key: "empty", value: "", found: true},
+		{key: "missing", value: "", found: false},
+	}
+
+	for _, test := range tests {
+		value, found, err := redis.LookupString(client.Query(context.Background(), "GET", test.key))
+		if it.Nil(err) {
+			it.Equal(test.found, found, test.key)
+			it.Equal(test.value, value, test.key)
+		}
+
+		var ptr *string
+		if it.Nil(redis.ParseArgs(client.Query(context.Background(), "GET", test.key), &ptr)) {
+			it.Equal(test.found, ptr != nil, test.key)
+		}
+	}
+}
+
+func TestLookup_NullFields(t *testing.T) {
+	it := assert.New(t)
+
+	var (
+		value []byte
+		ptr   = new(int)
+	)
+
+	args := redis.List([]byte{}, nil)
+
+	if it.True(args.Next(&value)) && it.True(args.Next(&ptr)) && it.Nil(args.Close()) {
+		it.NotNil(value)
+		it.Nil(ptr)
+	}
+}
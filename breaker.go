@@ -0,0 +1,342 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dolab/objconv/resp"
+)
+
+var (
+	// ErrCircuitOpen is returned by CircuitBreaker when requests to a server
+	// fail fast because its circuit is open.
+	ErrCircuitOpen = errors.New("redis: circuit breaker is open")
+)
+
+// A CircuitState is the state of the circuit of a server in a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed is the state of circuits of healthy servers, requests are
+	// sent to the server.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen is the state of circuits of failing servers, requests fail
+	// with ErrCircuitOpen without being sent.
+	CircuitOpen
+
+	// CircuitHalfOpen is the state of circuits which were open for long enough
+	// that a few requests are sent to the server to probe whether it recovered.
+	CircuitHalfOpen
+)
+
+// String returns a human-readable representation of the circuit state.
+func (state CircuitState) String() string {
+	switch state {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(state))
+}
+
+// A CircuitBreaker is a RoundTripper which stops sending requests to servers
+// that fail or are too slow, so callers fail fast instead of waiting for their
+// timeouts. Each server address has its own circuit:
+//
+// - closed circuits send requests and open when the rate of failed or slow
+// requests exceeds a threshold over a window of time,
+//
+// - open circuits fail requests with ErrCircuitOpen and become half-open after
+// OpenTimeout,
+//
+// - half-open circuits send HalfOpenRequests requests, they close if all of
+// them succeed and open again otherwise.
+//
+// Requests fail when the transport returns an error or when reading the
+// response fails, errors replied by the servers are not failures. Requests are
+// slow when reading their response takes longer than SlowDuration.
+type CircuitBreaker struct {
+	// Transport is the RoundTripper sending the requests, if nil
+	// DefaultTransport is used.
+	Transport RoundTripper
+
+	// FailureRate is the ratio of failed requests above which a circuit opens,
+	// if zero 0.5 is used.
+	FailureRate float64
+
+	// SlowDuration is the duration above which requests are counted as slow,
+	// zero means requests are never slow.
+	SlowDuration time.Duration
+
+	// SlowRate is the ratio of slow requests above which a circuit opens, if
+	// zero 0.5 is used.
+	SlowRate float64
+
+	// MinRequests is the number of requests that must have completed during
+	// the window before a circuit opens, if zero 10 is used.
+	MinRequests int
+
+	// Window is the duration over which the rates of failed and slow requests
+	// are computed, if zero 10 seconds is used.
+	Window time.Duration
+
+	// OpenTimeout is the duration after which an open circuit becomes
+	// half-open, if zero 5 seconds is used.
+	OpenTimeout time.Duration
+
+	// HalfOpenRequests is the number of requests sent by half-open circuits,
+	// if zero 1 is used.
+	HalfOpenRequests int
+
+	mutex    sync.Mutex
+	circuits map[string]*circuit
+}
+
+// circuit holds the state of the circuit of a server.
+type circuit struct {
+	state CircuitState
+	gen   uint64 // incremented on state changes to ignore stale outcomes
+	since time.Time
+
+	requests int // completed requests in the window or half-open state
+	failures int
+	slow     int
+	inflight int // requests sent by the half-open circuit
+}
+
+// RoundTrip implements the RoundTripper interface.
+func (b *CircuitBreaker) RoundTrip(req *Request) (*Response, error) {
+	addr := req.Addr
+
+	gen, err := b.acquire(addr)
+	if err != nil {
+		req.Close()
+		return nil, err
+	}
+
+	var (
+		once     sync.Once
+		issuedAt = time.Now()
+	)
+
+	done := func(err error) {
+		once.Do(func() { b.release(addr, gen, err, time.Since(issuedAt)) })
+	}
+
+	res, err := b.transport().RoundTrip(req)
+	if err != nil {
+		done(err)
+		return nil, err
+	}
+
+	if res.Args != nil {
+		res.Args = &circuitArgs{Args: res.Args, done: done}
+	} else {
+		res.TxArgs = &circuitTxArgs{TxArgs: res.TxArgs, done: done}
+	}
+
+	return res, nil
+}
+
+// State returns the state of the circuit of the server at addr.
+func (b *CircuitBreaker) State(addr string) CircuitState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	c := b.circuits[addr]
+	if c == nil {
+		return CircuitClosed
+	}
+
+	if c.state == CircuitOpen && time.Since(c.since) >= b.openTimeout() {
+		return CircuitHalfOpen
+	}
+
+	return c.state
+}
+
+// acquire returns the generation of the circuit of addr if a request can be
+// sent to the server, or ErrCircuitOpen.
+func (b *CircuitBreaker) acquire(addr string) (uint64, error) {
+	now := time.Now()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.circuits == nil {
+		b.circuits = make(map[string]*circuit)
+	}
+
+	c := b.circuits[addr]
+	if c == nil {
+		c = &circuit{since: now}
+		b.circuits[addr] = c
+	}
+
+	switch c.state {
+	case CircuitOpen:
+		if now.Sub(c.since) < b.openTimeout() {
+			return 0, ErrCircuitOpen
+		}
+
+		b.transition(addr, c, CircuitHalfOpen, now)
+		fallthrough
+
+	case CircuitHalfOpen:
+		if c.inflight+c.requests >= b.halfOpenRequests() {
+			return 0, ErrCircuitOpen
+		}
+
+		c.inflight++
+
+	default:
+		if now.Sub(c.since) >= b.window() {
+			c.since, c.requests, c.failures, c.slow = now, 0, 0, 0
+		}
+	}
+
+	return c.gen, nil
+}
+
+// release records the outcome of a request sent by the circuit of addr at
+// generation gen.
+func (b *CircuitBreaker) release(addr string, gen uint64, err error, elapsed time.Duration) {
+	failed := err != nil
+	if _, ok := err.(*resp.Error); ok {
+		failed = false
+	}
+
+	slow := b.SlowDuration > 0 && elapsed > b.SlowDuration
+	now := time.Now()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	c := b.circuits[addr]
+	if c == nil || c.gen != gen {
+		return
+	}
+
+	switch c.state {
+	case CircuitHalfOpen:
+		c.inflight--
+
+		if failed || slow {
+			b.transition(addr, c, CircuitOpen, now)
+			return
+		}
+
+		if c.requests++; c.requests >= b.halfOpenRequests() {
+			b.transition(addr, c, CircuitClosed, now)
+		}
+
+	case CircuitClosed:
+		c.requests++
+
+		if failed {
+			c.failures++
+		}
+
+		if slow {
+			c.slow++
+		}
+
+		if c.requests < b.minRequests() {
+			return
+		}
+
+		if float64(c.failures) >= b.failureRate()*float64(c.requests) ||
+			(b.SlowDuration > 0 && float64(c.slow) >= b.slowRate()*float64(c.requests)) {
+			b.transition(addr, c, CircuitOpen, now)
+		}
+	}
+}
+
+func (b *CircuitBreaker) transition(addr string, c *circuit, state CircuitState, now time.Time) {
+	*c = circuit{
+		state: state,
+		gen:   c.gen + 1,
+		since: now,
+	}
+
+	gometrics.SetCircuitState(addr, int(state))
+}
+
+func (b *CircuitBreaker) transport() RoundTripper {
+	if b.Transport != nil {
+		return b.Transport
+	}
+	return DefaultTransport
+}
+
+func (b *CircuitBreaker) failureRate() float64 {
+	if b.FailureRate > 0 {
+		return b.FailureRate
+	}
+	return 0.5
+}
+
+func (b *CircuitBreaker) slowRate() float64 {
+	if b.SlowRate > 0 {
+		return b.SlowRate
+	}
+	return 0.5
+}
+
+func (b *CircuitBreaker) minRequests() int {
+	if b.MinRequests > 0 {
+		return b.MinRequests
+	}
+	return 10
+}
+
+func (b *CircuitBreaker) window() time.Duration {
+	if b.Window > 0 {
+		return b.Window
+	}
+	return 10 * time.Second
+}
+
+func (b *CircuitBreaker) openTimeout() time.Duration {
+	if b.OpenTimeout > 0 {
+		return b.OpenTimeout
+	}
+	return 5 * time.Second
+}
+
+func (b *CircuitBreaker) halfOpenRequests() int {
+	if b.HalfOpenRequests > 0 {
+		return b.HalfOpenRequests
+	}
+	return 1
+}
+
+// circuitArgs reports the outcome of a request to its circuit once the
+// arguments of the response are closed.
+type circuitArgs struct {
+	Args
+	done func(error)
+}
+
+func (a *circuitArgs) Close() error {
+	err := a.Args.Close()
+	a.done(err)
+	return err
+}
+
+type circuitTxArgs struct {
+	TxArgs
+	done func(error)
+}
+
+func (a *circuitTxArgs) Close() error {
+	err := a.TxArgs.Close()
+	a.done(err)
+	return err
+}
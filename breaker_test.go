@@ -0,0 +1,154 @@
+package redis_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+
+	"github.com/dolab/objconv/resp"
+
+	"github.com/dolab/redis-go"
+)
+
+type roundTripperFunc func(*redis.Request) (*redis.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(req *redis.Request) (*redis.Response, error) {
+	return fn(req)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	const addr = "127.0.0.1:6379"
+
+	errUpstream := errors.New("connection refused")
+
+	// the fake transport fails while failing is true, and replies after delay
+	newBreaker := func(failing *bool, delay *time.Duration, calls *int) *redis.CircuitBreaker {
+		return &redis.CircuitBreaker{
+			Transport: roundTripperFunc(func(req *redis.Request) (*redis.Response, error) {
+				req.Close()
+				*calls++
+
+				if *failing {
+					return nil, errUpstream
+				}
+
+				time.Sleep(*delay)
+				return &redis.Response{Args: redis.List("OK")}, nil
+			}),
+			MinRequests:  4,
+			FailureRate:  0.5,
+			SlowDuration: 20 * time.Millisecond,
+			OpenTimeout:  50 * time.Millisecond,
+		}
+	}
+
+	roundTrip := func(b *redis.CircuitBreaker) error {
+		res, err := b.RoundTrip(redis.NewRequest(addr, "GET", redis.List("key")))
+		if err != nil {
+			return err
+		}
+		return res.Args.Close()
+	}
+
+	t.Run("opens on failures and fails fast", func(t *testing.T) {
+		it := assert.New(t)
+
+		var (
+			failing = false
+			delay   time.Duration
+			calls   int
+		)
+
+		b := newBreaker(&failing, &delay, &calls)
+
+		it.Nil(roundTrip(b))
+		it.Nil(roundTrip(b))
+
+		failing = true
+
+		it.Equal(errUpstream, roundTrip(b))
+		it.Equal(redis.CircuitClosed, b.State(addr))
+		it.Equal(errUpstream, roundTrip(b))
+		it.Equal(redis.CircuitOpen, b.State(addr))
+
+		it.Equal(redis.ErrCircuitOpen, roundTrip(b))
+		it.Equal(4, calls)
+
+		// other servers have their own circuit
+		it.Equal(redis.CircuitClosed, b.State("127.0.0.1:6380"))
+	})
+
+	t.Run("closes after successful half-open requests", func(t *testing.T) {
+		it := assert.New(t)
+
+		var (
+			failing = true
+			delay   time.Duration
+			calls   int
+		)
+
+		b := newBreaker(&failing, &delay, &calls)
+
+		for i := 0; i != 4; i++ {
+			roundTrip(b)
+		}
+		it.Equal(redis.CircuitOpen, b.State(addr))
+
+		time.Sleep(60 * time.Millisecond)
+		it.Equal(redis.CircuitHalfOpen, b.State(addr))
+
+		// the half-open probe fails, the circuit opens again
+		it.Equal(errUpstream, roundTrip(b))
+		it.Equal(redis.CircuitOpen, b.State(addr))
+		it.Equal(redis.ErrCircuitOpen, roundTrip(b))
+
+		time.Sleep(60 * time.Millisecond)
+		failing = false
+
+		it.Nil(roundTrip(b))
+		it.Equal(redis.CircuitClosed, b.State(addr))
+		it.Nil(roundTrip(b))
+	})
+
+	t.Run("opens on slow requests", func(t *testing.T) {
+		it := assert.New(t)
+
+		var (
+			failing = false
+			delay   = 30 * time.Millisecond
+			calls   int
+		)
+
+		b := newBreaker(&failing, &delay, &calls)
+
+		for i := 0; i != 4; i++ {
+			it.Nil(roundTrip(b))
+		}
+
+		it.Equal(redis.CircuitOpen, b.State(addr))
+		it.Equal(redis.ErrCircuitOpen, roundTrip(b))
+	})
+
+	t.Run("ignores errors replied by servers", func(t *testing.T) {
+		it := assert.New(t)
+
+		b := &redis.CircuitBreaker{
+			Transport: roundTripperFunc(func(req *redis.Request) (*redis.Response, error) {
+				req.Close()
+				return &redis.Response{Args: redis.List(resp.NewError("ERR wrong type"))}, nil
+			}),
+			MinRequests: 1,
+		}
+
+		for i := 0; i != 4; i++ {
+			res, err := b.RoundTrip(redis.NewRequest(addr, "GET", redis.List("key")))
+			if it.Nil(err) {
+				res.Args.Close()
+			}
+		}
+
+		it.Equal(redis.CircuitClosed, b.State(addr))
+	})
+}
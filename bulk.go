@@ -0,0 +1,238 @@
+package redis
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+
+	"github.com/dolab/objconv"
+	"github.com/dolab/objconv/objutil"
+	"github.com/dolab/objconv/resp"
+)
+
+// A BulkReader is a value which is written as a bulk string of N bytes read
+// from R, it makes it possible to send large values without loading them in
+// memory.
+//
+// BulkReader values can be passed to List to create arguments of client
+// requests, or to the Write method of a ResponseWriter.
+//
+// Reading less than N bytes from R is an error which leaves the connection in
+// an unusable state, it gets closed.
+type BulkReader struct {
+	R io.Reader
+	N int64
+}
+
+// NewBulkReader returns a BulkReader which streams n bytes read from r.
+func NewBulkReader(r io.Reader, n int64) *BulkReader {
+	return &BulkReader{R: r, N: n}
+}
+
+func (r *BulkReader) readAll() ([]byte, error) {
+	if r.N < 0 {
+		return nil, errNegativeBulkSize(r.N)
+	}
+
+	b := make([]byte, r.N)
+
+	if _, err := io.ReadFull(r.R, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (r *BulkReader) writeTo(w io.Writer) error {
+	if r.N < 0 {
+		return errNegativeBulkSize(r.N)
+	}
+
+	if _, err := fmt.Fprintf(w, "$%d\r\n", r.N); err != nil {
+		return err
+	}
+
+	if _, err := io.CopyN(w, r.R, r.N); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	_, err := w.Write(crlf)
+	return err
+}
+
+func errNegativeBulkSize(n int64) error {
+	return fmt.Errorf("invalid negative size of bulk reader: %d", n)
+}
+
+// bulkValue returns a value which writes v straight to w when it is encoded if v
+// is a *BulkReader, otherwise v is returned.
+//
+// The returned value must only be encoded by encoders writing directly to w,
+// that is not within arrays of unknown length.
+func bulkValue(v interface{}, w io.Writer) interface{} {
+	switch r := v.(type) {
+	case *BulkReader:
+		return objconv.ValueEncoderFunc(func(objconv.Encoder) error {
+			return r.writeTo(w)
+		})
+
+	case BulkReader:
+		return bulkValue(&r, w)
+	}
+
+	return v
+}
+
+// readerArgs is an Args implementation which produces a single value read from
+// a BulkReader.
+type readerArgs struct {
+	r    *BulkReader
+	done bool
+	err  error
+}
+
+func (args *readerArgs) Close() error {
+	args.done = true
+	return args.err
+}
+
+func (args *readerArgs) Len() int {
+	if args.done {
+		return 0
+	}
+	return 1
+}
+
+func (args *readerArgs) Next(dst interface{}) bool {
+	if args.done {
+		return false
+	}
+	args.done = true
+
+	switch d := dst.(type) {
+	case *interface{}:
+		// the reader is passed through so it can be streamed by encoders
+		*d = args.r
+
+	case io.Writer:
+		_, args.err = io.CopyN(d, args.r.R, args.r.N)
+
+	default:
+		var b []byte
+
+		if b, args.err = args.r.readAll(); args.err == nil {
+			args.err = (&byteArgs{}).next(reflect.ValueOf(dst), b)
+		}
+	}
+
+	return args.err == nil
+}
+
+// unreadReader is the reader that connection parsers load bytes from, it yields
+// the bytes that were given back after streaming a bulk string before reading
+// from the connection's buffer.
+type unreadReader struct {
+	b []byte
+	r io.Reader
+}
+
+func (u *unreadReader) Read(b []byte) (int, error) {
+	if len(u.b) != 0 {
+		n := copy(b, u.b)
+		u.b = u.b[n:]
+		return n, nil
+	}
+
+	return u.r.Read(b)
+}
+
+// readBulk returns a value which copies the next bulk string read from c to w
+// when it's decoded, without loading it in memory. Simple strings and integers
+// are written to w as well, nothing is written for nil values.
+func (c *Conn) readBulk(w io.Writer) objconv.ValueDecoder {
+	return objconv.ValueDecoderFunc(func(d objconv.Decoder) error {
+		t, err := d.Parser.ParseType()
+		if err != nil {
+			return err
+		}
+
+		switch t {
+		case objconv.Nil:
+			return d.Parser.ParseNil()
+
+		case objconv.String:
+			b, err := d.Parser.ParseString()
+			if err == nil {
+				_, err = w.Write(b)
+			}
+			return err
+
+		case objconv.Int:
+			i, err := d.Parser.ParseInt()
+			if err == nil {
+				_, err = io.WriteString(w, strconv.FormatInt(i, 10))
+			}
+			return err
+
+		case objconv.Bytes:
+			return c.copyBulk(w)
+		}
+
+		return fmt.Errorf("cannot decode a value of type %s into an io.Writer", t)
+	})
+}
+
+// copyBulk copies the bulk string which the connection's parser is positioned
+// at to w. The parser has only loaded the first bytes of the value in memory,
+// the rest is read straight from the connection's buffer, then the parser is
+// reset to resume reading after the value.
+func (c *Conn) copyBulk(w io.Writer) error {
+	head, err := ioutil.ReadAll(c.parser.Buffered())
+	if err != nil {
+		return err
+	}
+
+	i := bytes.Index(head, crlf)
+	if i < 0 {
+		return errors.New("missing CRLF after the size of a bulk string")
+	}
+
+	size, err := objutil.ParseInt(head[1:i])
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid size of bulk string: %q", head[1:i])
+	}
+
+	rest := bytes.NewReader(head[i+2:])
+	r := io.MultiReader(rest, &c.reader)
+
+	if _, err = io.CopyN(w, r, size); err == nil {
+		var end [2]byte
+
+		if _, err = io.ReadFull(r, end[:]); err == nil && !bytes.Equal(end[:], crlf) {
+			err = fmt.Errorf("expected a CRLF sequence at the end of a bulk string but found %q", end[:])
+		}
+	}
+
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	// the bytes loaded by the parser past the end of the value must be read
+	// before the ones that weren't loaded yet
+	c.reader.b = append(head[len(head)-rest.Len():], c.reader.b...)
+	c.parser = resp.Parser{}
+	c.parser.Reset(&c.reader)
+	return err
+}
+
+var crlf = []byte("\r\n")
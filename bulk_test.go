@@ -0,0 +1,130 @@
+package redis_test
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dolab/objconv/resp"
+	"github.com/golib/assert"
+
+	"github.com/dolab/redis-go"
+	"github.com/dolab/redis-go/redistest"
+)
+
+func TestBulkReader(t *testing.T) {
+	tests := []struct {
+		scenario string
+		size     int
+	}{
+		{
+			scenario: "small values fitting in the connection buffers",
+			size:     10,
+		},
+		{
+			scenario: "large values streamed through the connections",
+			size:     1 << 20,
+		},
+	}
+
+	for _, test := range tests {
+		size := test.size
+
+		t.Run(test.scenario, func(t *testing.T) {
+			testBulkReader(t, size)
+		})
+	}
+}
+
+func testBulkReader(t *testing.T, size int) {
+	it := assert.New(t)
+
+	var (
+		mutex  sync.Mutex
+		stored bytes.Buffer
+		ttl    int
+	)
+
+	srv, addr := redistest.FakeServer(redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		cmd := r.Cmds[0]
+
+		var (
+			key string
+			opt string
+		)
+
+		switch cmd.Cmd {
+		case "SET":
+			stored.Reset()
+
+			if err := cmd.ParseArgs(&key, &stored, &opt, &ttl); err != nil {
+				w.Write(err)
+				return
+			}
+			w.Write("OK")
+
+		case "GETEX":
+			cmd.ParseArgs(&key)
+
+			w.WriteStream(3)
+			w.Write(key)
+			w.Write(redis.NewBulkReader(bytes.NewReader(stored.Bytes()), int64(stored.Len())))
+			w.Write(ttl)
+
+		default:
+			w.Write(resp.NewError("ERR unknown command"))
+		}
+	}))
+	defer srv.Close()
+
+	tr := &redis.Transport{}
+	defer tr.CloseIdleConnections()
+
+	client := &redis.Client{Addr: addr, Transport: tr, Timeout: 10 * time.Second}
+
+	value := make([]byte, size)
+	rand.Read(value)
+
+	err := client.Exec(context.Background(), "SET", "key", redis.NewBulkReader(bytes.NewReader(value), int64(size)), "EX", 60)
+	if it.Nil(err) {
+		mutex.Lock()
+		it.Equal(value, stored.Bytes())
+		it.Equal(60, ttl)
+		mutex.Unlock()
+	}
+
+	var (
+		key   string
+		found bytes.Buffer
+		n     int
+	)
+
+	if it.Nil(redis.ParseArgs(client.Query(context.Background(), "GETEX", "key"), &key, &found, &n)) {
+		it.Equal("key", key)
+		it.Equal(value, found.Bytes())
+		it.Equal(60, n)
+	}
+}
+
+func TestList_BulkReader(t *testing.T) {
+	it := assert.New(t)
+
+	args := redis.List("a", redis.NewBulkReader(bytes.NewReader([]byte("hello")), 5), "b")
+
+	var (
+		a, b  string
+		value bytes.Buffer
+	)
+
+	if it.Equal(3, args.Len()) && it.Nil(redis.ParseArgs(args, &a, &value, &b)) {
+		it.Equal("a", a)
+		it.Equal("hello", value.String())
+		it.Equal("b", b)
+	}
+}
@@ -28,6 +28,20 @@ type Client struct {
 	//
 	// A Timeout of zero means no timeout.
 	Timeout time.Duration
+
+	// Replicas are the addresses of the replicas of the server at Addr, which
+	// readonly commands are sent to depending on ReadMode.
+	Replicas []string
+
+	// Cluster is true if the server at Addr is a node of a redis cluster, the
+	// connections to its replicas are then put in READONLY mode.
+	Cluster bool
+
+	// ReadMode configures how readonly commands are routed to Replicas, by
+	// default all commands are sent to the server at Addr.
+	ReadMode ReadMode
+
+	replicas replicaRouter
 }
 
 // Do sends an Redis request and returns an Redis response.
@@ -44,6 +58,9 @@ type Client struct {
 // The request Args, if non-nil, will be closed by the underlying Transport, even
 // on errors.
 //
+// Requests sent to the client's Addr are routed to its Replicas according to
+// ReadMode, in which case req.Addr is set to the address of the replica.
+//
 // Generally Exec or Query will be used instead of Do.
 func (c *Client) Do(req *Request) (*Response, error) {
 	transport := c.Transport
@@ -64,6 +81,16 @@ func (c *Client) Do(req *Request) (*Response, error) {
 		defer cancel()
 	}
 
+	if req.Addr == c.addr() && len(c.Replicas) != 0 {
+		endpoint := ServerEndpoint{
+			Addr:     req.Addr,
+			Replicas: c.Replicas,
+			Cluster:  c.Cluster,
+		}
+
+		return c.replicas.roundTrip(transport, req, endpoint, c.ReadMode)
+	}
+
 	return transport.RoundTrip(req)
 }
 
@@ -99,13 +126,8 @@ func (c *Client) MultiExec(ctx context.Context, cmds ...Command) error {
 // The context passed as first argument allows the operation to be canceled
 // asynchronously.
 func (c *Client) Query(ctx context.Context, cmd string, args ...interface{}) Args {
-	addr := c.Addr
-	if len(addr) == 0 {
-		addr = "localhost:6379"
-	}
-
 	r, err := c.Do(&Request{
-		Addr:    addr,
+		Addr:    c.addr(),
 		Cmds:    []Command{{Cmd: cmd, Args: List(args...)}},
 		Context: ctx,
 	})
@@ -129,11 +151,6 @@ func (c *Client) Query(ctx context.Context, cmd string, args ...interface{}) Arg
 // The context passed as first argument allows the operation to be canceled
 // asynchronously.
 func (c *Client) MultiQuery(ctx context.Context, cmds ...Command) TxArgs {
-	addr := c.Addr
-	if len(addr) == 0 {
-		addr = "localhost:6379"
-	}
-
 	for _, cmd := range cmds {
 		switch cmd.Cmd {
 		case "MULTI", "EXEC", "DISCARD":
@@ -147,7 +164,7 @@ func (c *Client) MultiQuery(ctx context.Context, cmds ...Command) TxArgs {
 	txCmds = append(txCmds, Command{Cmd: "EXEC"})
 
 	r, err := c.Do(&Request{
-		Addr:    addr,
+		Addr:    c.addr(),
 		Cmds:    txCmds,
 		Context: ctx,
 	})
@@ -158,6 +175,13 @@ func (c *Client) MultiQuery(ctx context.Context, cmds ...Command) TxArgs {
 	return r.TxArgs
 }
 
+func (c *Client) addr() string {
+	if len(c.Addr) == 0 {
+		return "localhost:6379"
+	}
+	return c.Addr
+}
+
 // DefaultClient is the default client and is used by Exec and Query.
 var DefaultClient = &Client{}
 
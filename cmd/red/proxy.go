@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"strings"
@@ -13,8 +14,8 @@ import (
 	"time"
 
 	redis "github.com/dolab/redis-go"
+	"github.com/dolab/redis-go/consul"
 	"github.com/segmentio/conf"
-	consul "github.com/segmentio/consul-go"
 	"github.com/segmentio/events"
 	eventslog "github.com/segmentio/events/log"
 	"github.com/segmentio/stats"
@@ -23,10 +24,17 @@ import (
 )
 
 type proxyConfig struct {
-	Bind      string `conf:"bind"      help:"Address on which the proxy is listening for incoming connections, in ip:port format." validate:"nonzero"`
-	Upstream  string `conf:"upstream"  help:"URL or comma-separated list of upstream servers."                                     validate:"nonzero"`
-	Dogstatsd string `conf:"dogstatsd" help:"Address of the dogstatsd agent to send metrics to, in ip:port format."                validate:"nonzero"`
-	Debug     bool   `conf:"debug"     help:"Enable debug mode."`
+	Bind                  string        `conf:"bind"                    help:"Address on which the proxy is listening for incoming connections, in ip:port format." validate:"nonzero"`
+	Upstream              string        `conf:"upstream"                help:"URL (consul://, sentinel://, dns://, dns+srv:// or file://) or comma-separated list of upstream servers." validate:"nonzero"`
+	Dogstatsd             string        `conf:"dogstatsd"               help:"Address of the dogstatsd agent to send metrics to, in ip:port format."                validate:"nonzero"`
+	ProxyProtocol         bool          `conf:"proxy-protocol"          help:"Read PROXY protocol headers sent by load balancers on incoming connections."`
+	UpstreamProxyProtocol int           `conf:"upstream-proxy-protocol" help:"Version of the PROXY protocol headers sent to upstream servers (1 or 2), 0 to disable."`
+	CircuitBreaker        bool          `conf:"circuit-breaker"         help:"Fail fast requests to upstream servers that are failing or too slow."`
+	UpstreamRetries       int           `conf:"upstream-retries"        help:"Number of times failed requests to upstream servers are retried, 0 to disable."`
+	SlowLogThreshold      time.Duration `conf:"slowlog-threshold"       help:"Duration above which requests are recorded in the slow log, 0 to disable."`
+	SlowLogMaxLen         int           `conf:"slowlog-max-len"         help:"Maximum number of entries retained in the slow log."`
+	HTTPAddr              string        `conf:"http-addr"               help:"Address on which the slow log is exported over HTTP at /debug/slowlog, in ip:port format."`
+	Debug                 bool          `conf:"debug"                   help:"Enable debug mode."`
 }
 
 func proxy(args []string) (err error) {
@@ -58,19 +66,61 @@ func proxy(args []string) (err error) {
 	defer stats.Flush()
 
 	lstn := makeListener(config.Bind)
+	listeners := []net.Listener{lstn}
 	server := makeProxyServer(stats.DefaultEngine, config)
 
 	if config.Dogstatsd != "" {
 		server.Handler = redisstats.NewHandler(server.Handler)
 	}
 
-	sigchan, sigstop := signals(syscall.SIGINT, syscall.SIGTERM)
+	server.RegisterOnShutdown(func() {
+		events.Log("draining connections on '%{address}s'", lstn.Addr())
+	})
+
+	if len(config.HTTPAddr) != 0 {
+		httpLstn := makeListener(config.HTTPAddr)
+		httpServer := makeHTTPServer(server)
+
+		// the HTTP listener is handed to new processes on restarts as well
+		listeners = append(listeners, httpLstn)
+
+		server.RegisterOnShutdown(func() {
+			httpServer.Close()
+		})
+
+		events.Log("exporting the slow log on 'http://%{address}s/debug/slowlog'", httpLstn.Addr())
+
+		go httpServer.Serve(httpLstn)
+	}
+
+	sigchan, sigstop := signals(syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR2)
 	defer sigstop()
 
 	go func() {
-		<-sigchan
-		sigstop()
-		server.Shutdown(context.Background())
+		for sig := range sigchan {
+			// SIGHUP and SIGUSR2 restart the proxy, the new process inherits
+			// the listeners while this one drains its connections.
+			if sig == syscall.SIGHUP || sig == syscall.SIGUSR2 {
+				p, err := restart(listeners...)
+				if err != nil {
+					events.Log("restarting the proxy failed: %{error}s", err)
+					continue
+				}
+
+				events.Log("started new proxy process %{pid}d", p.Pid)
+
+				// connections keep being served by this process if the new
+				// one fails to start, like when its configuration is invalid.
+				if err := waitStarted(p, restartTimeout); err != nil {
+					events.Log("new proxy process %{pid}d failed to start: %{error}s", p.Pid, err)
+					continue
+				}
+			}
+
+			sigstop()
+			server.Shutdown(context.Background())
+			return
+		}
 	}()
 
 	events.Log("listening on '%{address}s' for incoming connections", lstn.Addr())
@@ -83,13 +133,49 @@ func proxy(args []string) (err error) {
 }
 
 func makeListener(addr string) net.Listener {
-	l, err := net.Listen("tcp", addr)
+	l, err := redis.Listen("tcp", addr)
 	if err != nil {
 		panic(err)
 	}
 	return l
 }
 
+// restart starts a new proxy process with the arguments of the current one,
+// which inherits the listeners.
+func restart(listeners ...net.Listener) (*os.Process, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	return redis.StartProcess(path, os.Args[1:], listeners...)
+}
+
+// restartTimeout is the time that a new proxy process must have been running
+// before the one which started it drains its connections.
+const restartTimeout = 5 * time.Second
+
+// waitStarted waits for the process p to run for timeout, it returns an error if
+// the process exited before.
+func waitStarted(p *os.Process, timeout time.Duration) error {
+	exit := make(chan error, 1)
+
+	go func() {
+		state, err := p.Wait()
+		if err == nil {
+			err = fmt.Errorf("the process exited (%s)", state)
+		}
+		exit <- err
+	}()
+
+	select {
+	case err := <-exit:
+		return err
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
 func makeProxyServer(eng *stats.Engine, config proxyConfig) *redis.Server {
 	logger := eventslog.NewLogger("", 0, events.DefaultHandler)
 	up := eng.WithTags(stats.Tag{"side", "upstream"})
@@ -100,6 +186,23 @@ func makeProxyServer(eng *stats.Engine, config proxyConfig) *redis.Server {
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  90 * time.Second,
 		ErrorLog:     logger,
+
+		ProxyProtocol: config.ProxyProtocol,
+		ShutdownError: redis.ErrShuttingDown,
+
+		SlowLogThreshold: config.SlowLogThreshold,
+		SlowLogMaxLen:    config.SlowLogMaxLen,
+	}
+}
+
+func makeHTTPServer(server *redis.Server) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/slowlog", server.ServeSlowLog)
+
+	return &http.Server{
+		Handler:      mux,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
 	}
 }
 
@@ -112,10 +215,28 @@ func makeReverseProxy(eng *stats.Engine, logger *log.Logger, config proxyConfig)
 }
 
 func makeTransport(eng *stats.Engine, config proxyConfig) redis.RoundTripper {
-	return redisstats.NewTransportWith(eng, &redis.Transport{
+	var transport redis.RoundTripper = &redis.Transport{
 		PingTimeout:  10 * time.Second,
 		PingInterval: 15 * time.Second,
-	})
+
+		ProxyProtocol: config.UpstreamProxyProtocol,
+	}
+
+	if config.CircuitBreaker {
+		transport = &redis.CircuitBreaker{
+			Transport:    transport,
+			SlowDuration: 10 * time.Second,
+		}
+	}
+
+	if config.UpstreamRetries > 0 {
+		transport = &redis.RetryTransport{
+			Transport:   transport,
+			MaxAttempts: config.UpstreamRetries + 1,
+		}
+	}
+
+	return redisstats.NewTransportWith(eng, transport)
 }
 
 func makeRegistry(upstream string) (registry redis.ServerRegistry) {
@@ -131,6 +252,15 @@ func makeRegistry(upstream string) (registry redis.ServerRegistry) {
 		case "consul":
 			registry = makeConsulRegistry(u)
 
+		case "sentinel":
+			registry = makeSentinelRegistry(u)
+
+		case "dns", "dns+srv":
+			registry = makeDNSRegistry(u)
+
+		case "file":
+			registry = makeFileRegistry(u)
+
 		default:
 			panic("unsupported registry: " + u.Scheme)
 		}
@@ -162,76 +292,83 @@ func makeStaticRegistry(upstream string) redis.ServerList {
 	return servers
 }
 
-func makeConsulRegistry(u *url.URL) *consulRegistry {
-	v := u.Query()
-
-	r := &consulRegistry{
-		service: strings.TrimPrefix(u.Path, "/"),
-		cluster: v.Get("cluster"),
-		client: &consul.Client{
-			Address:    u.Host,
-			UserAgent:  fmt.Sprintf("RED (github.com/dolab/redis-go, version %s)", version),
-			Datacenter: v.Get("dc"),
-		},
-		resolver: &consul.Resolver{
-			OnlyPassing: true,
-			Cache: &consul.ResolverCache{
-				CacheTimeout: 10 * time.Second,
-			},
-			Blacklist: &consul.ResolverBlacklist{},
-		},
+// makeSentinelRegistry returns a registry of the masters listed in the path of
+// u, discovered from the comma-separated list of sentinels in its host, like
+// sentinel://10.0.0.1:26379,10.0.0.2:26379/master1,master2?replicas=true
+func makeSentinelRegistry(u *url.URL) *redis.SentinelRegistry {
+	r := &redis.SentinelRegistry{
+		Sentinels: strings.Split(u.Host, ","),
+		Masters:   strings.Split(strings.Trim(u.Path, "/"), ","),
+		Replicas:  u.Query().Get("replicas") == "true",
+		ErrorLog:  eventslog.NewLogger("", 0, events.DefaultHandler),
 	}
 
-	if len(r.cluster) != 0 {
-		r.resolver.ServiceTags = []string{"redis-cluster:" + r.cluster}
-	}
-
-	r.resolver.Client = r.client
-
-	events.Log("using '%{redis_service_name}s' services of the '%{redis_cluster_name}s' from the consul registry at '%{consul_addr}s'",
-		r.cluster,
-		r.service,
-		r.client.Address,
+	events.Log("using masters '%{redis_masters}s' from the sentinels at '%{sentinel_addrs}s'",
+		strings.Join(r.Masters, ","),
+		u.Host,
 	)
 
-	var _ redis.ServerBlacklist = r
 	return r
 }
 
-type consulRegistry struct {
-	service  string
-	cluster  string
-	client   *consul.Client
-	resolver *consul.Resolver
+// makeDNSRegistry returns a registry of the servers resolved from the host of
+// u, either the SRV records of dns+srv://_redis._tcp.example.com or the IP
+// addresses of dns://redis.example.com:6379
+func makeDNSRegistry(u *url.URL) *redis.DNSRegistry {
+	r := &redis.DNSRegistry{
+		Name:     u.Hostname(),
+		SRV:      u.Scheme == "dns+srv",
+		Port:     u.Port(),
+		ErrorLog: eventslog.NewLogger("", 0, events.DefaultHandler),
+	}
+
+	events.Log("using servers resolved from '%{dns_name}s'", r.Name)
+
+	return r
 }
 
-func (r *consulRegistry) LookupServers(ctx context.Context) ([]redis.ServerEndpoint, error) {
-	endpoints, err := r.resolver.LookupService(ctx, r.service)
-	if err != nil {
-		return nil, err
+// makeFileRegistry returns a registry of the servers read from the YAML or JSON
+// file at the path of u, like file:///etc/red/servers.yml
+func makeFileRegistry(u *url.URL) *redis.FileRegistry {
+	r := &redis.FileRegistry{
+		Path:     u.Path,
+		ErrorLog: eventslog.NewLogger("", 0, events.DefaultHandler),
 	}
 
-	servers := make([]redis.ServerEndpoint, len(endpoints))
+	events.Log("using servers read from '%{servers_file}s'", r.Path)
 
-	for i, e := range endpoints {
-		servers[i] = redis.ServerEndpoint{
-			Name: e.ID,
-			Addr: e.Addr.String(),
-		}
+	return r
+}
+
+// makeConsulRegistry returns a registry of the instances of the service in the
+// path of u, discovered from the consul agent in its host, like
+// consul://localhost:8500/redis?cluster=cache&dc=us-east-1
+func makeConsulRegistry(u *url.URL) *consul.Registry {
+	v := u.Query()
+
+	r := &consul.Registry{
+		Address:    u.Host,
+		Service:    strings.TrimPrefix(u.Path, "/"),
+		Tags:       v["tag"],
+		Datacenter: v.Get("dc"),
+		ErrorLog:   eventslog.NewLogger("", 0, events.DefaultHandler),
 	}
 
-	return servers, nil
-}
+	cluster := v.Get("cluster")
 
-func (r *consulRegistry) BlacklistServer(server redis.ServerEndpoint) {
-	stats.Incr("blacklist_server.count")
-	r.resolver.Blacklist.Blacklist(stringAddr(server.Addr), time.Now().Add(10*time.Second))
-}
+	if len(cluster) != 0 {
+		r.Tags = append(r.Tags, "redis-cluster:"+cluster)
+	}
 
-type stringAddr string
+	events.Log("using '%{redis_service_name}s' services of the '%{redis_cluster_name}s' from the consul registry at '%{consul_addr}s'",
+		r.Service,
+		cluster,
+		r.Address,
+	)
 
-func (a stringAddr) Network() string { return "" }
-func (a stringAddr) String() string  { return string(a) }
+	var _ redis.ServerBlacklist = r
+	return r
+}
 
 func convertPanicToError(v interface{}) error {
 	switch x := v.(type) {
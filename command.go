@@ -2,8 +2,10 @@ package redis
 
 import (
 	"fmt"
+	"io"
 	"reflect"
 	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/dolab/objconv"
@@ -25,7 +27,6 @@ type Command struct {
 
 	// for retry
 	args [][]byte
-	argn int
 }
 
 // ParseArgs parses the list of arguments from the command into the destination
@@ -44,71 +45,63 @@ func (cmd *Command) newCommand() Command {
 	}
 }
 
-// pipeCommand returns a new Command for for pipeline, or an error of ErrNotPipeline indicates no pipeline.
-func (cmd *Command) pipeCommand() (pipe Command, err error) {
-	if cmd.Args == nil {
-		err = ErrNotPipeline
-		return
+func (cmd *Command) getKeys(keys []string) []string {
+	switch strings.ToUpper(cmd.Cmd) {
+	case "EVAL", "EVALSHA":
+		return cmd.getScriptKeys(keys)
 	}
 
-	// shortcut for ping cmd
-	if cmd.Cmd == "PING" {
-		err = ErrNotPipeline
-		return
+	lastIndex := len(keys)
+	keys = append(keys, "")
+
+	if cmd.Args != nil {
+		// TODO: for now we assume commands have only one key
+		if cmd.Args.Next(&keys[lastIndex]) {
+			cmd.Args = MultiArgs(List(keys[lastIndex]), cmd.Args)
+		} else {
+			keys = keys[:lastIndex]
+		}
 	}
 
-	err = cmd.Args.Close()
-	if err != nil {
-		return
+	return keys
+}
+
+// getScriptKeys reads the keys of EVAL and EVALSHA commands, which are passed
+// after the script and the number of keys.
+func (cmd *Command) getScriptKeys(keys []string) []string {
+	if cmd.Args == nil {
+		return keys
 	}
 
-	switch cmd.Args.(type) {
-	case *cmdArgsReader:
-		cmdArgs := cmd.Args.(*cmdArgsReader)
-		if !cmdArgs.parseCommand(&pipe) {
-			err = ErrNotPipeline
-		}
+	var (
+		script  []byte
+		numkeys int
+	)
 
-	case *multiArgs:
-		// TODO: How to handler multi args correct?
-		listArgs := cmd.Args.(*multiArgs)
-
-		var (
-			cmdArgs *cmdArgsReader
-			ok      bool
-		)
-		for _, args := range listArgs.args {
-			cmdArgs, ok = args.(*cmdArgsReader)
-			if ok {
-				break
-			}
-		}
+	if !cmd.Args.Next(&script) {
+		return keys
+	}
 
-		if cmdArgs == nil {
-			err = ErrNotPipeline
-		} else {
-			if !cmdArgs.parseCommand(&pipe) {
-				err = ErrNotPipeline
-			}
-		}
+	if !cmd.Args.Next(&numkeys) {
+		cmd.Args = MultiArgs(List(script), cmd.Args)
+		return keys
 	}
 
-	return
-}
+	values := make([]interface{}, 0, 2+numkeys)
+	values = append(values, script, numkeys)
 
-func (cmd *Command) getKeys(keys []string) []string {
-	lastIndex := len(keys)
-	keys = append(keys, "")
+	for i := 0; i < numkeys; i++ {
+		var key string
 
-	if cmd.Args != nil {
-		// TODO: for now we assume commands have only one key
-		if cmd.Args.Next(&keys[lastIndex]) {
-			cmd.Args = MultiArgs(List(keys[lastIndex]), cmd.Args)
-		} else {
-			keys = keys[:lastIndex]
+		if !cmd.Args.Next(&key) {
+			break
 		}
+
+		keys = append(keys, key)
+		values = append(values, key)
 	}
 
+	cmd.Args = MultiArgs(List(values...), cmd.Args)
 	return keys
 }
 
@@ -117,6 +110,10 @@ func (cmd *Command) loadByteArgs() {
 		return
 	}
 
+	if _, ok := cmd.Args.(*byteArgs); ok {
+		return
+	}
+
 	var (
 		list [][]byte
 		arg  []byte
@@ -135,14 +132,8 @@ func (cmd *Command) loadByteArgs() {
 }
 
 func (cmd *Command) appendArg(arg []byte) {
-	if len(cmd.args) >= cmd.argn {
-		cmd.args = append(cmd.args, arg)
-	} else {
-		cmd.args[cmd.argn] = make([]byte, len(arg))
-		copy(cmd.args[cmd.argn], arg)
-	}
-
-	cmd.argn++
+	// arg may be a buffer reused for reading the next values, it must be copied
+	cmd.args = append(cmd.args, append(make([]byte, 0, len(arg)), arg...))
 }
 
 // CommandReader is a type produced by the Conn.ReadCommands method to read a
@@ -212,10 +203,6 @@ func (r *CommandReader) Read(cmd *Command) bool {
 	return true
 }
 
-func (r *CommandReader) resetReader() {
-	r.done = false
-}
-
 func (r *CommandReader) resetDecoder() {
 	r.dec = objconv.StreamDecoder{Parser: r.dec.Parser}
 }
@@ -284,7 +271,19 @@ func (args *cmdArgsReader) Next(val interface{}) bool {
 		}
 	}
 
-	args.b = args.b[:0]
+	if w, ok := val.(io.Writer); ok && args.r.conn != nil {
+		// values streamed to w are not retained, the command cannot be
+		// retried.
+		if err := args.r.dec.Decode(args.r.conn.readBulk(w)); err != nil {
+			args.err = args.r.dec.Err()
+			return false
+		}
+		return true
+	}
+
+	if args.b = args.b[:0]; args.b == nil {
+		args.b = args.a[:0]
+	}
 
 	if err := args.r.dec.Decode(&args.b); err != nil {
 		args.err = args.r.dec.Err()
@@ -305,13 +304,22 @@ func (args *cmdArgsReader) Next(val interface{}) bool {
 	return true
 }
 
-func (args *cmdArgsReader) parseCommand(cmd *Command) bool {
-	args.r.resetReader()
+func (args *cmdArgsReader) parse(v reflect.Value) error {
+	if args.b == nil {
+		return parseNil(v)
+	}
 
-	return args.r.Read(cmd)
-}
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	if ok, err := parseUnmarshaler(v, args.b); ok {
+		return err
+	}
 
-func (args *cmdArgsReader) parse(v reflect.Value) error {
 	switch v.Kind() {
 	case reflect.Bool:
 		return args.parseBool(v)
@@ -386,6 +394,11 @@ func (args *cmdArgsReader) parseString(v reflect.Value) error {
 }
 
 func (args *cmdArgsReader) parseBytes(v reflect.Value) error {
+	if v.IsNil() {
+		// empty values must not be confused with nil ones
+		v.SetBytes(make([]byte, 0, len(args.b)))
+	}
+
 	v.SetBytes(append(v.Bytes()[:0], args.b...))
 	return nil
 }
@@ -0,0 +1,213 @@
+package redis
+
+import (
+	"sort"
+	"strings"
+)
+
+// commandFlags are properties of the commands of redis which change how they
+// are sent to servers.
+type commandFlags uint8
+
+const (
+	// cmdReadonly is set on commands which don't modify the dataset, they can
+	// be served by replicas.
+	cmdReadonly commandFlags = 1 << iota
+
+	// cmdIdempotent is set on writes which have the same effect and reply when
+	// they are executed more than once, they may be retried after they were
+	// sent. Readonly commands are idempotent as well. Writes replying counts of
+	// changed elements, like DEL or SADD, are not.
+	cmdIdempotent
+)
+
+// A commandSpec describes a command of redis.
+type commandSpec struct {
+	flags commandFlags
+
+	// conditions are the options which make idempotent writes conditional,
+	// like the NX option of SET, they are then not idempotent.
+	conditions []string
+
+	// builtin is set on the commands served by the server itself, it is
+	// reported to clients by COMMAND commands.
+	builtin *CommandInfo
+}
+
+// commandTable holds the properties of redis commands, and the description of
+// the commands built into the server. Commands missing from the table, like
+// module commands, are writes which are never retried once they were sent.
+var commandTable = map[string]commandSpec{
+	"BITCOUNT":             {flags: cmdReadonly},
+	"BITFIELD_RO":          {flags: cmdReadonly},
+	"BITPOS":               {flags: cmdReadonly},
+	"CLIENT":               {builtin: &CommandInfo{Name: "client", Arity: -2, Flags: []string{"admin", "noscript", "loading", "stale"}, Summary: "A container for client connection commands", Since: "2.4.0", Group: "connection"}},
+	"COMMAND":              {builtin: &CommandInfo{Name: "command", Arity: -1, Flags: []string{"loading", "stale"}, Summary: "Returns detailed information about all commands", Since: "2.8.13", Group: "server"}},
+	"DBSIZE":               {flags: cmdReadonly},
+	"DUMP":                 {flags: cmdReadonly},
+	"EVALSHA_RO":           {flags: cmdReadonly},
+	"EVAL_RO":              {flags: cmdReadonly},
+	"EXISTS":               {flags: cmdReadonly},
+	"EXPIRE":               {flags: cmdIdempotent, conditions: []string{"NX", "XX", "GT", "LT"}},
+	"EXPIREAT":             {flags: cmdIdempotent, conditions: []string{"NX", "XX", "GT", "LT"}},
+	"EXPIRETIME":           {flags: cmdReadonly},
+	"FLUSHALL":             {flags: cmdIdempotent},
+	"FLUSHDB":              {flags: cmdIdempotent},
+	"GEODIST":              {flags: cmdReadonly},
+	"GEOHASH":              {flags: cmdReadonly},
+	"GEOPOS":               {flags: cmdReadonly},
+	"GEORADIUSBYMEMBER_RO": {flags: cmdReadonly},
+	"GEORADIUS_RO":         {flags: cmdReadonly},
+	"GEOSEARCH":            {flags: cmdReadonly},
+	"GET":                  {flags: cmdReadonly},
+	"GETBIT":               {flags: cmdReadonly},
+	"GETRANGE":             {flags: cmdReadonly},
+	"HEXISTS":              {flags: cmdReadonly},
+	"HGET":                 {flags: cmdReadonly},
+	"HGETALL":              {flags: cmdReadonly},
+	"HKEYS":                {flags: cmdReadonly},
+	"HLEN":                 {flags: cmdReadonly},
+	"HMGET":                {flags: cmdReadonly},
+	"HMSET":                {flags: cmdIdempotent},
+	"HRANDFIELD":           {flags: cmdReadonly},
+	"HSCAN":                {flags: cmdReadonly},
+	"HSTRLEN":              {flags: cmdReadonly},
+	"HVALS":                {flags: cmdReadonly},
+	"INFO":                 {builtin: &CommandInfo{Name: "info", Arity: -1, Flags: []string{"loading", "stale"}, Summary: "Returns information and statistics about the server", Since: "1.0.0", Group: "server"}},
+	"KEYS":                 {flags: cmdReadonly},
+	"LINDEX":               {flags: cmdReadonly},
+	"LLEN":                 {flags: cmdReadonly},
+	"LPOS":                 {flags: cmdReadonly},
+	"LRANGE":               {flags: cmdReadonly},
+	"LSET":                 {flags: cmdIdempotent},
+	"MGET":                 {flags: cmdReadonly},
+	"MONITOR":              {builtin: &CommandInfo{Name: "monitor", Arity: 1, Flags: []string{"admin", "noscript", "loading", "stale"}, Summary: "Listens for all requests received by the server in real-time", Since: "1.0.0", Group: "server"}},
+	"MSET":                 {flags: cmdIdempotent},
+	"PEXPIRE":              {flags: cmdIdempotent, conditions: []string{"NX", "XX", "GT", "LT"}},
+	"PEXPIREAT":            {flags: cmdIdempotent, conditions: []string{"NX", "XX", "GT", "LT"}},
+	"PEXPIRETIME":          {flags: cmdReadonly},
+	"PFCOUNT":              {flags: cmdReadonly},
+	"PING":                 {builtin: &CommandInfo{Name: "ping", Arity: -1, Flags: []string{"fast", "stale"}, Summary: "Returns the server's liveliness response", Since: "1.0.0", Group: "connection"}},
+	"PSETEX":               {flags: cmdIdempotent},
+	"PTTL":                 {flags: cmdReadonly},
+	"RANDOMKEY":            {flags: cmdReadonly},
+	"SCAN":                 {flags: cmdReadonly},
+	"SCARD":                {flags: cmdReadonly},
+	"SDIFF":                {flags: cmdReadonly},
+	"SELECT":               {builtin: &CommandInfo{Name: "select", Arity: 2, Flags: []string{"loading", "stale", "fast"}, Summary: "Changes the selected database", Since: "1.0.0", Group: "connection"}},
+	"SET":                  {flags: cmdIdempotent, conditions: []string{"NX", "XX", "GET"}},
+	"SETEX":                {flags: cmdIdempotent},
+	"SETRANGE":             {flags: cmdIdempotent},
+	"SINTER":               {flags: cmdReadonly},
+	"SINTERCARD":           {flags: cmdReadonly},
+	"SISMEMBER":            {flags: cmdReadonly},
+	"SLOWLOG":              {builtin: &CommandInfo{Name: "slowlog", Arity: -2, Flags: []string{"admin", "random", "loading", "stale"}, Summary: "A container for slow log commands", Since: "2.2.12", Group: "server"}},
+	"SMEMBERS":             {flags: cmdReadonly},
+	"SMISMEMBER":           {flags: cmdReadonly},
+	"SRANDMEMBER":          {flags: cmdReadonly},
+	"SSCAN":                {flags: cmdReadonly},
+	"STRLEN":               {flags: cmdReadonly},
+	"SUBSTR":               {flags: cmdReadonly},
+	"SUNION":               {flags: cmdReadonly},
+	"SWAPDB":               {builtin: &CommandInfo{Name: "swapdb", Arity: 3, Flags: []string{"write", "fast"}, Summary: "Swaps two Redis databases", Since: "4.0.0", Group: "server"}},
+	"TTL":                  {flags: cmdReadonly},
+	"TYPE":                 {flags: cmdReadonly},
+	"XLEN":                 {flags: cmdReadonly},
+	"XPENDING":             {flags: cmdReadonly},
+	"XRANGE":               {flags: cmdReadonly},
+	"XREAD":                {flags: cmdReadonly},
+	"XREVRANGE":            {flags: cmdReadonly},
+	"ZCARD":                {flags: cmdReadonly},
+	"ZCOUNT":               {flags: cmdReadonly},
+	"ZDIFF":                {flags: cmdReadonly},
+	"ZINTER":               {flags: cmdReadonly},
+	"ZLEXCOUNT":            {flags: cmdReadonly},
+	"ZMSCORE":              {flags: cmdReadonly},
+	"ZRANDMEMBER":          {flags: cmdReadonly},
+	"ZRANGE":               {flags: cmdReadonly},
+	"ZRANGEBYLEX":          {flags: cmdReadonly},
+	"ZRANGEBYSCORE":        {flags: cmdReadonly},
+	"ZRANK":                {flags: cmdReadonly},
+	"ZREVRANGE":            {flags: cmdReadonly},
+	"ZREVRANGEBYLEX":       {flags: cmdReadonly},
+	"ZREVRANGEBYSCORE":     {flags: cmdReadonly},
+	"ZREVRANK":             {flags: cmdReadonly},
+	"ZSCAN":                {flags: cmdReadonly},
+	"ZSCORE":               {flags: cmdReadonly},
+	"ZUNION":               {flags: cmdReadonly},
+}
+
+// isReadonly reports whether all commands of req are readonly, transactions
+// are never readonly.
+func isReadonly(req *Request) bool {
+	if len(req.Cmds) == 0 {
+		return false
+	}
+
+	for _, cmd := range req.Cmds {
+		if commandTable[strings.ToUpper(cmd.Cmd)].flags&cmdReadonly == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isIdempotent reports whether all commands of cmds, which have the arguments
+// in args, are known to have the same effect and reply when they are executed
+// more than once.
+func isIdempotent(cmds []Command, args [][]interface{}) bool {
+	for i, cmd := range cmds {
+		spec := commandTable[strings.ToUpper(cmd.Cmd)]
+
+		if spec.flags&(cmdReadonly|cmdIdempotent) == 0 {
+			return false
+		}
+
+		// the options of the commands follow their key and value
+		for j := 2; j < len(args[i]); j++ {
+			if spec.conditional(args[i][j]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// conditional reports whether arg is one of the conditions of the command.
+func (spec commandSpec) conditional(arg interface{}) bool {
+	var s string
+
+	switch v := arg.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return false
+	}
+
+	for _, cond := range spec.conditions {
+		if strings.EqualFold(s, cond) {
+			return true
+		}
+	}
+	return false
+}
+
+// builtinCommands are the commands served by the server itself, sorted by name.
+var builtinCommands = func() []CommandInfo {
+	var list []CommandInfo
+
+	for _, spec := range commandTable {
+		if spec.builtin != nil {
+			list = append(list, *spec.builtin)
+		}
+	}
+
+	sort.Slice(list, func(i int, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}()
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"io"
 	"net"
 	"sync"
 	"time"
@@ -24,6 +25,7 @@ type Conn struct {
 
 	rmutex  sync.Mutex
 	rbuffer bufio.Reader
+	reader  unreadReader
 	decoder objconv.StreamDecoder
 	parser  resp.Parser
 
@@ -59,7 +61,8 @@ func NewClientConn(conn net.Conn) *Conn {
 		rbuffer: *bufio.NewReader(conn),
 		wbuffer: *bufio.NewWriter(conn),
 	}
-	c.parser.Reset(&c.rbuffer)
+	c.reader.r = &c.rbuffer
+	c.parser.Reset(&c.reader)
 	c.emitter.Reset(&c.wbuffer)
 	c.decoder = objconv.StreamDecoder{Parser: &c.parser}
 	c.encoder = objconv.StreamEncoder{Emitter: &c.emitter}
@@ -87,7 +90,8 @@ func NewServerConn(conn net.Conn) *Conn {
 		rbuffer: *bufio.NewReader(conn),
 		wbuffer: *bufio.NewWriter(conn),
 	}
-	c.parser.Reset(&c.rbuffer)
+	c.reader.r = &c.rbuffer
+	c.parser.Reset(&c.reader)
 	c.emitter.Reset(&c.wbuffer)
 	c.decoder = objconv.StreamDecoder{Parser: &c.parser}
 	c.encoder = objconv.StreamEncoder{Emitter: &c.emitter.Emitter}
@@ -492,7 +496,7 @@ func (c *Conn) encodeArgs(args Args) (err error) {
 	var val interface{}
 
 	for args.Next(&val) {
-		if err = c.encoder.Encode(val); err != nil {
+		if err = c.encoder.Encode(bulkValue(val, &c.wbuffer)); err != nil {
 			return
 		}
 		val = nil
@@ -512,7 +516,7 @@ func (c *Conn) resetDecoder() {
 
 func (c *Conn) waitReadyRead(timeout time.Duration) (err error) {
 	c.rmutex.Lock()
-	if c.rbuffer.Buffered() == 0 {
+	if c.buffered() == 0 {
 		c.setReadTimeout(timeout)
 		_, err = c.rbuffer.Peek(1)
 		c.setReadTimeout(0)
@@ -521,6 +525,18 @@ func (c *Conn) waitReadyRead(timeout time.Duration) (err error) {
 	return
 }
 
+// buffered returns the number of bytes received on c which haven't been read
+// yet, including those loaded by the parser.
+func (c *Conn) buffered() int {
+	n := c.rbuffer.Buffered() + len(c.reader.b)
+
+	if r, ok := c.parser.Buffered().(interface{ Len() int }); ok {
+		n += r.Len()
+	}
+
+	return n
+}
+
 func (c *Conn) setTimeout(timeout time.Duration) {
 	if timeout == 0 {
 		c.conn.SetDeadline(time.Time{})
@@ -626,6 +642,9 @@ func (args *connArgs) next(dst interface{}) (err error) {
 
 	if typ, err = args.dec.Parser.ParseType(); err == nil {
 		if typ != objconv.Error {
+			if w, ok := dst.(io.Writer); ok {
+				dst = args.conn.readBulk(w)
+			}
 			err = args.dec.Decode(dst)
 		} else {
 			args.dec.Decode(&args.respErr)
@@ -0,0 +1,432 @@
+// Package consul implements a redis.ServerRegistry discovering redis servers
+// from the health of services registered in Consul.
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dolab/redis-go"
+)
+
+const (
+	// DefaultAddress is the address of the Consul agent used when neither
+	// Registry.Address nor the CONSUL_HTTP_ADDR environment variable are set.
+	DefaultAddress = "localhost:8500"
+
+	// DefaultWeightMeta is the node metadata key that the weight of servers is
+	// read from when Registry.WeightMeta is empty.
+	DefaultWeightMeta = "redis-weight"
+)
+
+// A Registry is a redis.ServerRegistry which discovers the instances of a
+// service registered in Consul.
+//
+// The registry keeps a cached list of servers, which is updated by blocking
+// queries as soon as instances of the service are registered, deregistered or
+// change health. When Consul can't be reached, the servers last discovered are
+// used.
+type Registry struct {
+	// Address is the address of the Consul agent, if empty the CONSUL_HTTP_ADDR
+	// environment variable or DefaultAddress is used.
+	Address string
+
+	// Service is the name of the service that servers are instances of.
+	Service string
+
+	// Tags filters the instances of the service to those having all the tags.
+	Tags []string
+
+	// Datacenter is the datacenter of the service, if empty the datacenter of
+	// the agent is used.
+	Datacenter string
+
+	// Token is the ACL token sent to Consul.
+	Token string
+
+	// AllowWarning configures whether instances with checks in the warning
+	// state are used, instances with critical checks never are.
+	AllowWarning bool
+
+	// WeightMeta is the key of the node metadata which sets the weight of the
+	// servers running on the node, if empty DefaultWeightMeta is used.
+	WeightMeta string
+
+	// WaitTime is the maximum duration of blocking queries, if zero 1 minute
+	// is used.
+	WaitTime time.Duration
+
+	// RetryInterval is the time waited before querying Consul again after an
+	// error, if zero 1 second is used.
+	RetryInterval time.Duration
+
+	// BlacklistDuration is the time that servers are removed from the ring
+	// by BlacklistServer, if zero 10 seconds is used.
+	BlacklistDuration time.Duration
+
+	// Client is the HTTP client used to query Consul, if nil
+	// http.DefaultClient is used.
+	Client *http.Client
+
+	// ErrorLog specifies an optional logger for errors querying Consul. If nil,
+	// logging goes to os.Stderr via the log package's standard logger.
+	ErrorLog redis.Logger
+
+	cache     redis.RegistryCache
+	mutex     sync.RWMutex
+	endpoints []redis.ServerEndpoint
+	blacklist map[string]time.Time
+	expires   time.Time
+}
+
+// healthService is an instance of a service returned by the health endpoint of
+// the Consul API.
+type healthService struct {
+	Node struct {
+		Node    string
+		Address string
+		Meta    map[string]string
+	}
+
+	Service struct {
+		ID      string
+		Service string
+		Tags    []string
+		Address string
+		Port    int
+	}
+
+	Checks []struct {
+		CheckID string
+		Status  string
+	}
+}
+
+// LookupServers satisfies the redis.ServerRegistry interface.
+func (r *Registry) LookupServers(ctx context.Context) (redis.ServerRing, error) {
+	ring, err := r.cache.Lookup(ctx, r.watch)
+
+	r.mutex.RLock()
+	expires := r.expires
+	r.mutex.RUnlock()
+
+	if now := time.Now(); !expires.IsZero() && now.After(expires) {
+		r.mutex.Lock()
+		ring, err = r.rebuild(now), nil
+		r.mutex.Unlock()
+	}
+
+	if ring == nil && err == nil {
+		err = fmt.Errorf("consul: no instances of service %s were found", r.Service)
+	}
+
+	return ring, err
+}
+
+// BlacklistServer satisfies the redis.ServerBlacklist interface, the server is
+// removed from the ring for BlacklistDuration. When all servers are
+// blacklisted, the ring falls back to distributing keys to all of them.
+func (r *Registry) BlacklistServer(endpoint redis.ServerEndpoint) {
+	now := time.Now()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.blacklist == nil {
+		r.blacklist = make(map[string]time.Time)
+	}
+
+	r.blacklist[endpoint.Addr] = now.Add(r.blacklistDuration())
+	r.rebuild(now)
+}
+
+// Close stops watching the service.
+func (r *Registry) Close() error {
+	r.cache.Close()
+	return nil
+}
+
+// watch queries the instances of the service until ctx is canceled.
+func (r *Registry) watch(ctx context.Context) {
+	index, err := r.update(ctx, 0)
+
+	for ctx.Err() == nil {
+		if err != nil {
+			select {
+			case <-time.After(r.retryInterval()):
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		index, err = r.update(ctx, index)
+	}
+}
+
+// update queries the instances of the service once their index is greater
+// than index, and rebuilds the ring. It returns the index that the next query
+// should wait for, the previous ring is kept if the query fails.
+func (r *Registry) update(ctx context.Context, index uint64) (uint64, error) {
+	endpoints, next, err := r.fetch(ctx, index)
+	if err != nil {
+		if ctx.Err() != nil {
+			return index, err
+		}
+
+		err = fmt.Errorf("consul: looking up instances of service %s: %s", r.Service, err)
+		r.log(err)
+
+		r.cache.Fail(err)
+		return index, err
+	}
+
+	// the index may go backwards when the state of Consul is restored, the
+	// query must then start over
+	if next < index {
+		return 0, nil
+	}
+
+	if next == index && r.endpoints != nil {
+		return index, nil
+	}
+
+	sort.Slice(endpoints, func(i int, j int) bool {
+		return endpoints[i].Addr < endpoints[j].Addr
+	})
+
+	r.mutex.Lock()
+	r.endpoints = endpoints
+	r.rebuild(time.Now())
+	r.mutex.Unlock()
+
+	return next, nil
+}
+
+// rebuild builds the ring of the servers which aren't blacklisted at now and
+// returns it, the registry's mutex must be locked.
+func (r *Registry) rebuild(now time.Time) redis.ServerRing {
+	r.expires = time.Time{}
+
+	// the servers haven't been discovered yet
+	if r.endpoints == nil {
+		return nil
+	}
+
+	endpoints := make([]redis.ServerEndpoint, 0, len(r.endpoints))
+
+	for addr, expires := range r.blacklist {
+		if !now.Before(expires) {
+			delete(r.blacklist, addr)
+		}
+	}
+
+	for _, endpoint := range r.endpoints {
+		expires, blacklisted := r.blacklist[endpoint.Addr]
+		if !blacklisted {
+			endpoints = append(endpoints, endpoint)
+			continue
+		}
+
+		if r.expires.IsZero() || expires.Before(r.expires) {
+			r.expires = expires
+		}
+	}
+
+	// fail open, some of the servers may still be able to serve requests
+	if len(endpoints) == 0 {
+		endpoints = r.endpoints
+	}
+
+	ring := redis.NewHashRing(endpoints...)
+	r.cache.Update(ring)
+	return ring
+}
+
+// fetch sends a blocking query of the instances of the service to Consul.
+func (r *Registry) fetch(ctx context.Context, index uint64) ([]redis.ServerEndpoint, uint64, error) {
+	query := url.Values{}
+
+	if len(r.Datacenter) != 0 {
+		query.Set("dc", r.Datacenter)
+	}
+
+	for _, tag := range r.Tags {
+		query.Add("tag", tag)
+	}
+
+	timeout := 10 * time.Second
+
+	if index != 0 {
+		wait := r.waitTime()
+
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", strconv.Itoa(int(wait/time.Millisecond))+"ms")
+
+		// Consul adds up to wait/16 of jitter to blocking queries
+		timeout += wait + wait/16
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", r.address()+"/v1/health/service/"+url.PathEscape(r.Service)+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(r.Token) != 0 {
+		req.Header.Set("X-Consul-Token", r.Token)
+	}
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(res.Body)
+		return nil, 0, fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(b)))
+	}
+
+	next, err := strconv.ParseUint(res.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid X-Consul-Index header: %s", err)
+	}
+
+	var services []healthService
+
+	if err := json.NewDecoder(res.Body).Decode(&services); err != nil {
+		return nil, 0, err
+	}
+
+	endpoints := make([]redis.ServerEndpoint, 0, len(services))
+
+	for _, service := range services {
+		if !r.healthy(service) || !hasTags(service.Service.Tags, r.Tags) {
+			continue
+		}
+
+		addr := service.Service.Address
+		if len(addr) == 0 {
+			addr = service.Node.Address
+		}
+
+		weight, _ := strconv.Atoi(service.Node.Meta[r.weightMeta()])
+
+		endpoints = append(endpoints, redis.ServerEndpoint{
+			Name:   service.Service.ID,
+			Addr:   net.JoinHostPort(addr, strconv.Itoa(service.Service.Port)),
+			Weight: weight,
+		})
+	}
+
+	return endpoints, next, nil
+}
+
+// healthy reports whether the checks of service allow it to be used, checks of
+// nodes or services in maintenance are critical.
+func (r *Registry) healthy(service healthService) bool {
+	for _, check := range service.Checks {
+		switch check.Status {
+		case "passing":
+		case "warning":
+			if !r.AllowWarning {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Registry) address() string {
+	addr := r.Address
+
+	if len(addr) == 0 {
+		addr = os.Getenv("CONSUL_HTTP_ADDR")
+	}
+
+	if len(addr) == 0 {
+		addr = DefaultAddress
+	}
+
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+
+	return strings.TrimSuffix(addr, "/")
+}
+
+func (r *Registry) weightMeta() string {
+	if len(r.WeightMeta) != 0 {
+		return r.WeightMeta
+	}
+	return DefaultWeightMeta
+}
+
+func (r *Registry) waitTime() time.Duration {
+	if r.WaitTime > 0 {
+		return r.WaitTime
+	}
+	return 1 * time.Minute
+}
+
+func (r *Registry) retryInterval() time.Duration {
+	if r.RetryInterval > 0 {
+		return r.RetryInterval
+	}
+	return 1 * time.Second
+}
+
+func (r *Registry) blacklistDuration() time.Duration {
+	if r.BlacklistDuration > 0 {
+		return r.BlacklistDuration
+	}
+	return 10 * time.Second
+}
+
+func (r *Registry) log(err error) {
+	if r.ErrorLog != nil {
+		r.ErrorLog.Print(err)
+	} else {
+		log.Print(err)
+	}
+}
+
+// hasTags reports whether tags contains all of wanted.
+func hasTags(tags []string, wanted []string) bool {
+	for _, w := range wanted {
+		found := false
+
+		for _, tag := range tags {
+			if tag == w {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,289 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+
+	"github.com/dolab/redis-go"
+)
+
+// fakeConsul is a Consul agent answering blocking queries of the health of
+// services.
+type fakeConsul struct {
+	mutex    sync.Mutex
+	index    uint64
+	services map[string][]interface{}
+	changed  chan struct{}
+	blocking int
+	fail     bool
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{
+		index:    1,
+		services: make(map[string][]interface{}),
+		changed:  make(chan struct{}),
+	}
+}
+
+// set replaces the instances of the service called name and wakes up the
+// pending blocking queries.
+func (c *fakeConsul) set(name string, instances ...interface{}) {
+	c.mutex.Lock()
+	c.services[name] = instances
+	c.index++
+	close(c.changed)
+	c.changed = make(chan struct{})
+	c.mutex.Unlock()
+}
+
+func (c *fakeConsul) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	const prefix = "/v1/health/service/"
+
+	if len(req.URL.Path) <= len(prefix) || req.URL.Path[:len(prefix)] != prefix {
+		http.NotFound(w, req)
+		return
+	}
+
+	name := req.URL.Path[len(prefix):]
+	query := req.URL.Query()
+
+	c.mutex.Lock()
+
+	if index, _ := strconv.ParseUint(query.Get("index"), 10, 64); index != 0 && index >= c.index {
+		wait, _ := time.ParseDuration(query.Get("wait"))
+		changed := c.changed
+		c.blocking++
+		c.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-time.After(wait):
+		case <-req.Context().Done():
+		}
+
+		c.mutex.Lock()
+	}
+
+	index, instances, fail := c.index, c.services[name], c.fail
+	c.mutex.Unlock()
+
+	if fail {
+		http.Error(w, "No cluster leader", http.StatusInternalServerError)
+		return
+	}
+
+	if instances == nil {
+		instances = []interface{}{}
+	}
+
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+	json.NewEncoder(w).Encode(instances)
+}
+
+func (c *fakeConsul) blockingQueries() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.blocking
+}
+
+// instance returns an instance of a service as returned by the Consul API.
+func instance(id string, addr string, port int, tags []string, meta map[string]string, statuses ...string) interface{} {
+	checks := []map[string]string{{"CheckID": "serfHealth", "Status": "passing"}}
+
+	for i, status := range statuses {
+		checks = append(checks, map[string]string{"CheckID": "service:" + id + ":" + strconv.Itoa(i), "Status": status})
+	}
+
+	return map[string]interface{}{
+		"Node":    map[string]interface{}{"Node": "node-" + id, "Address": addr, "Meta": meta},
+		"Service": map[string]interface{}{"ID": id, "Service": "redis", "Tags": tags, "Port": port},
+		"Checks":  checks,
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	consul := newFakeConsul()
+
+	server := httptest.NewServer(consul)
+	defer server.Close()
+
+	consul.set("redis",
+		instance("redis-0", "10.0.0.1", 6379, []string{"cache"}, map[string]string{"redis-weight": "2"}, "passing"),
+		instance("redis-1", "10.0.0.2", 6379, []string{"cache"}, nil, "warning"),
+		instance("redis-2", "10.0.0.3", 6379, []string{"cache"}, nil, "critical"),
+		instance("redis-3", "10.0.0.4", 6379, []string{"sessions"}, nil, "passing"),
+	)
+
+	listServers := func(it *assert.Assertions, r *Registry) []redis.ServerEndpoint {
+		ring, err := r.LookupServers(context.Background())
+		if !it.Nil(err) {
+			return nil
+		}
+		return ring.(redis.ServerLister).ListServers()
+	}
+
+	waitServers := func(it *assert.Assertions, r *Registry, n int) {
+		deadline := time.Now().Add(2 * time.Second)
+
+		for time.Now().Before(deadline) && len(listServers(it, r)) != n {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	t.Run("discovers healthy instances with tags", func(t *testing.T) {
+		it := assert.New(t)
+
+		r := &Registry{
+			Address:  server.URL,
+			Service:  "redis",
+			Tags:     []string{"cache"},
+			ErrorLog: log.New(ioutil.Discard, "", 0),
+		}
+		defer r.Close()
+
+		it.Equal([]redis.ServerEndpoint{
+			{Name: "redis-0", Addr: "10.0.0.1:6379", Weight: 2},
+		}, listServers(it, r))
+
+		r2 := &Registry{
+			Address:      server.URL,
+			Service:      "redis",
+			Tags:         []string{"cache"},
+			AllowWarning: true,
+			ErrorLog:     log.New(ioutil.Discard, "", 0),
+		}
+		defer r2.Close()
+
+		it.Equal([]redis.ServerEndpoint{
+			{Name: "redis-0", Addr: "10.0.0.1:6379", Weight: 2},
+			{Name: "redis-1", Addr: "10.0.0.2:6379"},
+		}, listServers(it, r2))
+	})
+
+	t.Run("updates servers with blocking queries", func(t *testing.T) {
+		it := assert.New(t)
+
+		consul.set("sessions", instance("redis-0", "10.0.1.1", 6379, nil, nil))
+
+		r := &Registry{
+			Address:       server.URL,
+			Service:       "sessions",
+			WaitTime:      10 * time.Second,
+			RetryInterval: 10 * time.Millisecond,
+			ErrorLog:      log.New(ioutil.Discard, "", 0),
+		}
+		defer r.Close()
+
+		it.Len(listServers(it, r), 1)
+
+		deadline := time.Now().Add(2 * time.Second)
+
+		for time.Now().Before(deadline) && consul.blockingQueries() == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		consul.set("sessions",
+			instance("redis-0", "10.0.1.1", 6379, nil, nil),
+			instance("redis-1", "10.0.1.2", 6379, nil, nil),
+		)
+
+		// the change is seen long before the blocking query times out
+		waitServers(it, r, 2)
+		it.Len(listServers(it, r), 2)
+
+		// the servers last discovered are used while consul fails
+		consul.mutex.Lock()
+		consul.fail = true
+		consul.mutex.Unlock()
+
+		consul.set("sessions")
+		time.Sleep(50 * time.Millisecond)
+
+		it.Len(listServers(it, r), 2)
+
+		consul.mutex.Lock()
+		consul.fail = false
+		consul.mutex.Unlock()
+
+		// the service has no instances left once consul is back
+		_, err := r.LookupServers(context.Background())
+
+		for deadline := time.Now().Add(2 * time.Second); err == nil && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+			_, err = r.LookupServers(context.Background())
+		}
+
+		it.NotNil(err)
+	})
+
+	t.Run("blacklists servers until they expire", func(t *testing.T) {
+		it := assert.New(t)
+
+		consul.set("queues",
+			instance("redis-0", "10.0.2.1", 6379, nil, nil),
+			instance("redis-1", "10.0.2.2", 6379, nil, nil),
+		)
+
+		r := &Registry{
+			Address:           server.URL,
+			Service:           "queues",
+			BlacklistDuration: 100 * time.Millisecond,
+			ErrorLog:          log.New(ioutil.Discard, "", 0),
+		}
+		defer r.Close()
+
+		it.Len(listServers(it, r), 2)
+
+		r.BlacklistServer(redis.ServerEndpoint{Addr: "10.0.2.1:6379"})
+
+		it.Equal([]redis.ServerEndpoint{
+			{Name: "redis-1", Addr: "10.0.2.2:6379"},
+		}, listServers(it, r))
+
+		// all servers are used when all are blacklisted
+		r.BlacklistServer(redis.ServerEndpoint{Addr: "10.0.2.2:6379"})
+		it.Len(listServers(it, r), 2)
+
+		time.Sleep(150 * time.Millisecond)
+		it.Len(listServers(it, r), 2)
+
+		r.mutex.RLock()
+		it.Empty(r.blacklist)
+		r.mutex.RUnlock()
+	})
+
+	t.Run("fails when consul can't be reached", func(t *testing.T) {
+		it := assert.New(t)
+
+		consul.mutex.Lock()
+		consul.fail = true
+		consul.mutex.Unlock()
+
+		defer func() {
+			consul.mutex.Lock()
+			consul.fail = false
+			consul.mutex.Unlock()
+		}()
+
+		r := &Registry{
+			Address:  server.URL,
+			Service:  "redis",
+			ErrorLog: log.New(ioutil.Discard, "", 0),
+		}
+		defer r.Close()
+
+		_, err := r.LookupServers(context.Background())
+		it.NotNil(err)
+	})
+}
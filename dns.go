@@ -0,0 +1,158 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A DNSRegistry is a ServerRegistry which discovers servers by resolving a DNS
+// name on an interval, like the SRV records of a service or the A records of a
+// headless service in Kubernetes.
+//
+// The endpoints of the ring returned by LookupServers are sorted by address, so
+// it's the same for a given set of servers regardless of the order of records.
+// When resolving the name fails, the servers of the last successful resolution
+// are used.
+type DNSRegistry struct {
+	// Name is the DNS name that servers are discovered from.
+	Name string
+
+	// SRV configures whether the SRV records of Name are resolved, servers are
+	// then the targets of the records with the lowest priority. Otherwise the
+	// IP addresses of Name are resolved, and servers listen on Port.
+	SRV bool
+
+	// Port is the port that servers listen on when SRV is false, if empty
+	// 6379 is used.
+	Port string
+
+	// Resolver is used to resolve Name, if nil net.DefaultResolver is used.
+	Resolver *net.Resolver
+
+	// Timeout is the time limit of resolutions, if zero 1 second is used.
+	Timeout time.Duration
+
+	// RefreshInterval is the interval at which Name is resolved, if zero 10
+	// seconds is used.
+	RefreshInterval time.Duration
+
+	// ErrorLog specifies an optional logger for errors resolving Name. If nil,
+	// logging goes to os.Stderr via the log package's standard logger.
+	ErrorLog Logger
+
+	cache RegistryCache
+}
+
+// LookupServers satisfies the ServerRegistry interface.
+func (r *DNSRegistry) LookupServers(ctx context.Context) (ServerRing, error) {
+	ring, err := r.cache.Lookup(ctx, func(ctx context.Context) {
+		r.refresh(ctx)
+		refreshEvery(ctx, r.refreshInterval(), r.refresh)
+	})
+
+	if ring == nil && err == nil {
+		err = fmt.Errorf("redis: no servers were found at %s", r.Name)
+	}
+
+	return ring, err
+}
+
+// Close stops resolving the name of servers.
+func (r *DNSRegistry) Close() error {
+	r.cache.Close()
+	return nil
+}
+
+// refresh resolves the name of servers and rebuilds the ring, the previous ring
+// is kept if it fails.
+func (r *DNSRegistry) refresh(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout())
+	defer cancel()
+
+	endpoints, err := r.resolve(ctx)
+	if err != nil {
+		err = fmt.Errorf("redis: resolving servers at %s: %s", r.Name, err)
+		logError(r.ErrorLog, err)
+
+		r.cache.Fail(err)
+		return
+	}
+
+	sort.Slice(endpoints, func(i int, j int) bool {
+		return endpoints[i].Addr < endpoints[j].Addr
+	})
+
+	r.cache.Update(NewHashRing(endpoints...))
+}
+
+func (r *DNSRegistry) resolve(ctx context.Context) ([]ServerEndpoint, error) {
+	resolver := r.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	if r.SRV {
+		_, records, err := resolver.LookupSRV(ctx, "", "", r.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		endpoints := make([]ServerEndpoint, 0, len(records))
+
+		// records are sorted by priority, the lowest value is preferred
+		for _, srv := range records {
+			if srv.Priority != records[0].Priority {
+				break
+			}
+
+			target := strings.TrimSuffix(srv.Target, ".")
+
+			endpoints = append(endpoints, ServerEndpoint{
+				Name: target,
+				Addr: net.JoinHostPort(target, strconv.Itoa(int(srv.Port))),
+			})
+		}
+
+		return endpoints, nil
+	}
+
+	addrs, err := resolver.LookupIPAddr(ctx, r.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	port := r.Port
+	if len(port) == 0 {
+		port = "6379"
+	}
+
+	endpoints := make([]ServerEndpoint, 0, len(addrs))
+
+	for _, addr := range addrs {
+		endpoints = append(endpoints, ServerEndpoint{
+			Name: addr.IP.String(),
+			Addr: net.JoinHostPort(addr.IP.String(), port),
+		})
+	}
+
+	return endpoints, nil
+}
+
+func (r *DNSRegistry) timeout() time.Duration {
+	if r.Timeout > 0 {
+		return r.Timeout
+	}
+	return 1 * time.Second
+}
+
+func (r *DNSRegistry) refreshInterval() time.Duration {
+	if r.RefreshInterval > 0 {
+		return r.RefreshInterval
+	}
+	return 10 * time.Second
+}
@@ -0,0 +1,251 @@
+package redis_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+
+	"github.com/dolab/redis-go"
+)
+
+const (
+	dnsTypeA   = 1
+	dnsTypeSRV = 33
+)
+
+// fakeDNS is a DNS server answering A and SRV queries of the records it holds,
+// other queries are answered with no records.
+type fakeDNS struct {
+	conn  net.PacketConn
+	mutex sync.Mutex
+	a     map[string][]net.IP
+	srv   map[string][]net.SRV
+}
+
+func newFakeDNS(t *testing.T) *fakeDNS {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dns := &fakeDNS{
+		conn: conn,
+		a:    make(map[string][]net.IP),
+		srv:  make(map[string][]net.SRV),
+	}
+
+	go dns.serve()
+	return dns
+}
+
+// resolver returns a resolver which sends its queries to the server.
+func (dns *fakeDNS) resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "udp", dns.conn.LocalAddr().String())
+		},
+	}
+}
+
+func (dns *fakeDNS) set(name string, a []net.IP, srv []net.SRV) {
+	dns.mutex.Lock()
+	dns.a[name] = a
+	dns.srv[name] = srv
+	dns.mutex.Unlock()
+}
+
+func (dns *fakeDNS) serve() {
+	b := make([]byte, 512)
+
+	for {
+		n, addr, err := dns.conn.ReadFrom(b)
+		if err != nil {
+			return
+		}
+
+		if res := dns.answer(b[:n]); res != nil {
+			dns.conn.WriteTo(res, addr)
+		}
+	}
+}
+
+func (dns *fakeDNS) answer(req []byte) []byte {
+	if len(req) < 12 {
+		return nil
+	}
+
+	// the question is a sequence of labels followed by its type and class
+	i := 12
+	labels := []string{}
+
+	for i < len(req) && req[i] != 0 {
+		n := int(req[i])
+		if i+1+n > len(req) {
+			return nil
+		}
+
+		labels = append(labels, string(req[i+1:i+1+n]))
+		i += 1 + n
+	}
+
+	if i+5 > len(req) {
+		return nil
+	}
+
+	question := req[12 : i+5]
+	qtype := binary.BigEndian.Uint16(req[i+1:])
+	name := strings.ToLower(strings.Join(labels, "."))
+
+	dns.mutex.Lock()
+	a, knownA := dns.a[name]
+	srv, knownSRV := dns.srv[name]
+	dns.mutex.Unlock()
+
+	var answers [][]byte
+
+	switch qtype {
+	case dnsTypeA:
+		for _, ip := range a {
+			answers = append(answers, dnsRecord(dnsTypeA, ip.To4()))
+		}
+
+	case dnsTypeSRV:
+		for _, s := range srv {
+			rdata := make([]byte, 6)
+			binary.BigEndian.PutUint16(rdata[0:], s.Priority)
+			binary.BigEndian.PutUint16(rdata[2:], s.Weight)
+			binary.BigEndian.PutUint16(rdata[4:], s.Port)
+			answers = append(answers, dnsRecord(dnsTypeSRV, append(rdata, dnsName(s.Target)...)))
+		}
+	}
+
+	res := make([]byte, 12, 512)
+	copy(res, req[:2])
+	binary.BigEndian.PutUint16(res[2:], 0x8180) // response, recursion desired and available
+	binary.BigEndian.PutUint16(res[4:], 1)
+	binary.BigEndian.PutUint16(res[6:], uint16(len(answers)))
+
+	if !knownA && !knownSRV {
+		binary.BigEndian.PutUint16(res[2:], 0x8183) // NXDOMAIN
+	}
+
+	res = append(res, question...)
+
+	for _, answer := range answers {
+		res = append(res, answer...)
+	}
+
+	return res
+}
+
+// dnsRecord returns a resource record of the name of the question.
+func dnsRecord(typ uint16, rdata []byte) []byte {
+	b := make([]byte, 12, 12+len(rdata))
+	binary.BigEndian.PutUint16(b[0:], 0xC00C) // pointer to the question name
+	binary.BigEndian.PutUint16(b[2:], typ)
+	binary.BigEndian.PutUint16(b[4:], 1) // IN
+	binary.BigEndian.PutUint32(b[6:], 1) // TTL
+	binary.BigEndian.PutUint16(b[10:], uint16(len(rdata)))
+	return append(b, rdata...)
+}
+
+func dnsName(name string) []byte {
+	var b []byte
+
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+
+	return append(b, 0)
+}
+
+func TestDNSRegistry(t *testing.T) {
+	dns := newFakeDNS(t)
+	defer dns.conn.Close()
+
+	dns.set("redis.example.com", []net.IP{net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.1")}, nil)
+	dns.set("_redis._tcp.example.com", nil, []net.SRV{
+		{Target: "redis-1.example.com.", Port: 6380, Priority: 10},
+		{Target: "redis-0.example.com.", Port: 6379, Priority: 10},
+		{Target: "backup.example.com.", Port: 6379, Priority: 20},
+	})
+
+	listServers := func(it *assert.Assertions, r *redis.DNSRegistry) []redis.ServerEndpoint {
+		ring, err := r.LookupServers(context.Background())
+		if !it.Nil(err) {
+			return nil
+		}
+		return ring.(redis.ServerLister).ListServers()
+	}
+
+	t.Run("resolves IP addresses", func(t *testing.T) {
+		it := assert.New(t)
+
+		r := &redis.DNSRegistry{
+			Name:            "redis.example.com",
+			Port:            "6380",
+			Resolver:        dns.resolver(),
+			RefreshInterval: 10 * time.Millisecond,
+			ErrorLog:        log.New(ioutil.Discard, "", 0),
+		}
+		defer r.Close()
+
+		it.Equal([]redis.ServerEndpoint{
+			{Name: "10.0.0.1", Addr: "10.0.0.1:6380"},
+			{Name: "10.0.0.2", Addr: "10.0.0.2:6380"},
+		}, listServers(it, r))
+
+		dns.set("redis.example.com", []net.IP{net.ParseIP("10.0.0.3")}, nil)
+
+		deadline := time.Now().Add(2 * time.Second)
+
+		for time.Now().Before(deadline) && len(listServers(it, r)) != 1 {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		it.Equal([]redis.ServerEndpoint{
+			{Name: "10.0.0.3", Addr: "10.0.0.3:6380"},
+		}, listServers(it, r))
+	})
+
+	t.Run("resolves SRV records", func(t *testing.T) {
+		it := assert.New(t)
+
+		r := &redis.DNSRegistry{
+			Name:     "_redis._tcp.example.com",
+			SRV:      true,
+			Resolver: dns.resolver(),
+			ErrorLog: log.New(ioutil.Discard, "", 0),
+		}
+		defer r.Close()
+
+		it.Equal([]redis.ServerEndpoint{
+			{Name: "redis-0.example.com", Addr: "redis-0.example.com:6379"},
+			{Name: "redis-1.example.com", Addr: "redis-1.example.com:6380"},
+		}, listServers(it, r))
+	})
+
+	t.Run("fails on unknown names", func(t *testing.T) {
+		it := assert.New(t)
+
+		r := &redis.DNSRegistry{
+			Name:     "unknown.example.com",
+			Resolver: dns.resolver(),
+			ErrorLog: log.New(ioutil.Discard, "", 0),
+		}
+		defer r.Close()
+
+		_, err := r.LookupServers(context.Background())
+		it.NotNil(err)
+	})
+}
@@ -15,5 +15,9 @@ var (
 	ErrHijacked                      = errors.New("invalid use of a hijacked redis.ResponseWriter")
 	ErrNotHijackable                 = errors.New("the response writer is not hijackable")
 	ErrNotRetryable                  = errors.New("the request cannot retry")
-	ErrNotPipeline                   = errors.New("redis: not pipeline")
+
+	// ErrNotPipeline is not returned anymore.
+	//
+	// Deprecated: pipelined commands are always served by the server.
+	ErrNotPipeline = errors.New("redis: not pipeline")
 )
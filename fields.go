@@ -0,0 +1,318 @@
+package redis
+
+import (
+	"encoding"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	timeType              = reflect.TypeOf(time.Time{})
+	textMarshalerType     = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+
+	structFieldsCache sync.Map // map[reflect.Type]structFields
+)
+
+// structField represents a field of a struct type which is encoded to, or
+// decoded from, a field/value pair of a redis hash.
+type structField struct {
+	name      string
+	index     []int
+	omitempty bool
+}
+
+type structFields struct {
+	list   []structField
+	byName map[string]*structField
+}
+
+// fieldsOf returns the fields of the struct type t, using the `redis` struct tag
+// to name them, `redis:"-"` excludes a field.
+func fieldsOf(t reflect.Type) structFields {
+	if cached, ok := structFieldsCache.Load(t); ok {
+		return cached.(structFields)
+	}
+
+	fields := structFields{byName: make(map[string]*structField)}
+
+	for i, n := 0, t.NumField(); i != n; i++ {
+		f := t.Field(i)
+
+		if len(f.PkgPath) != 0 { // unexported
+			continue
+		}
+
+		tag := f.Tag.Get("redis")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts := tag, ""
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+
+		if len(name) == 0 {
+			name = f.Name
+		}
+
+		fields.list = append(fields.list, structField{
+			name:      name,
+			index:     f.Index,
+			omitempty: opts == "omitempty",
+		})
+	}
+
+	for i := range fields.list {
+		fields.byName[fields.list[i].name] = &fields.list[i]
+	}
+
+	structFieldsCache.Store(t, fields)
+	return fields
+}
+
+// isFieldsValue returns true if v is a map with string keys or a struct which
+// is not decoded from a single value.
+func isFieldsValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Map:
+		return v.Type().Key().Kind() == reflect.String
+
+	case reflect.Struct:
+		return !isUnmarshalerType(v.Type()) && !isMarshalerType(v.Type())
+	}
+
+	return false
+}
+
+// isFieldsDst returns true if dst is a pointer to a value which is decoded from
+// a sequence of field/value pairs. Values implementing io.Writer are excluded,
+// bulk strings are copied to them instead.
+func isFieldsDst(dst interface{}) bool {
+	if _, ok := dst.(io.Writer); ok {
+		return false
+	}
+
+	v := reflect.ValueOf(dst)
+	return v.Kind() == reflect.Ptr && !v.IsNil() && isFieldsValue(v.Elem())
+}
+
+func isUnmarshalerType(t reflect.Type) bool {
+	p := reflect.PtrTo(t)
+	return t == timeType || p.Implements(textUnmarshalerType) || p.Implements(binaryUnmarshalerType)
+}
+
+func isMarshalerType(t reflect.Type) bool {
+	return t == timeType || t.Implements(textMarshalerType) || t.Implements(binaryMarshalerType)
+}
+
+// parseFields reads the remaining values of args as a sequence of field/value
+// pairs into the map or struct pointed by dst.
+func parseFields(args Args, dst reflect.Value) error {
+	var (
+		field string
+		value []byte
+	)
+
+	if dst.Kind() == reflect.Map && dst.IsNil() {
+		dst.Set(reflect.MakeMap(dst.Type()))
+	}
+
+	for args.Next(&field) {
+		value = nil
+
+		if !args.Next(&value) {
+			break
+		}
+
+		switch dst.Kind() {
+		case reflect.Map:
+			elem := reflect.New(dst.Type().Elem()).Elem()
+
+			if err := (&byteArgs{}).next(elem, value); err != nil {
+				return fmt.Errorf("decoding field %q: %s", field, err)
+			}
+
+			dst.SetMapIndex(reflect.ValueOf(field).Convert(dst.Type().Key()), elem)
+
+		case reflect.Struct:
+			f, ok := fieldsOf(dst.Type()).byName[field]
+			if !ok {
+				continue
+			}
+
+			if err := (&byteArgs{}).next(dst.FieldByIndex(f.index), value); err != nil {
+				return fmt.Errorf("decoding field %q: %s", field, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseUnmarshaler decodes a into v if v is a time.Time or implements one of
+// the encoding.TextUnmarshaler or encoding.BinaryUnmarshaler interfaces, the
+// method returns false if v is none of those.
+func parseUnmarshaler(v reflect.Value, a []byte) (bool, error) {
+	if !v.CanAddr() {
+		return false, nil
+	}
+
+	switch p := v.Addr().Interface().(type) {
+	case *time.Time:
+		t, err := parseTime(a)
+		if err == nil {
+			*p = t
+		}
+		return true, err
+
+	case encoding.TextUnmarshaler:
+		return true, p.UnmarshalText(a)
+
+	case encoding.BinaryUnmarshaler:
+		return true, p.UnmarshalBinary(append([]byte(nil), a...))
+	}
+
+	return false, nil
+}
+
+// parseTime parses times encoded as RFC3339 strings, or as unix timestamps in
+// seconds (which is how client requests encode time values).
+func parseTime(a []byte) (time.Time, error) {
+	if sec, err := strconv.ParseInt(string(a), 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+
+	return time.Parse(time.RFC3339Nano, string(a))
+}
+
+// appendFields appends the values of args to list, expanding maps and structs
+// into sequences of field/value pairs.
+func appendFields(list []interface{}, args []interface{}) ([]interface{}, error) {
+	for _, arg := range args {
+		switch r := arg.(type) {
+		case *BulkReader:
+			list = append(list, r)
+			continue
+
+		case BulkReader:
+			list = append(list, &r)
+			continue
+		}
+
+		v := reflect.ValueOf(arg)
+
+		for v.Kind() == reflect.Ptr && !v.IsNil() && isFieldsValue(v.Elem()) {
+			v = v.Elem()
+		}
+
+		if !isFieldsValue(v) {
+			value, err := formatValue(arg)
+			if err != nil {
+				return nil, err
+			}
+
+			list = append(list, value)
+			continue
+		}
+
+		var err error
+
+		switch v.Kind() {
+		case reflect.Map:
+			keys := v.MapKeys()
+
+			sort.Slice(keys, func(i int, j int) bool {
+				return keys[i].String() < keys[j].String()
+			})
+
+			for _, key := range keys {
+				if list, err = appendField(list, key.String(), v.MapIndex(key), false); err != nil {
+					return nil, err
+				}
+			}
+
+		case reflect.Struct:
+			for _, f := range fieldsOf(v.Type()).list {
+				if list, err = appendField(list, f.name, v.FieldByIndex(f.index), f.omitempty); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return list, nil
+}
+
+func appendField(list []interface{}, name string, v reflect.Value, omitempty bool) ([]interface{}, error) {
+	if omitempty && isEmptyValue(v) {
+		return list, nil
+	}
+
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return list, nil
+		}
+		v = v.Elem()
+	}
+
+	var value interface{}
+
+	if t, ok := v.Interface().(time.Time); ok {
+		value = t.Format(time.RFC3339Nano)
+	} else {
+		var err error
+
+		if value, err = formatValue(v.Interface()); err != nil {
+			return nil, fmt.Errorf("encoding field %q: %s", name, err)
+		}
+	}
+
+	return append(list, name, value), nil
+}
+
+// formatValue converts values implementing encoding.BinaryMarshaler (but not
+// encoding.TextMarshaler, which is natively supported by the encoder) to byte
+// slices.
+func formatValue(v interface{}) (interface{}, error) {
+	if _, ok := v.(encoding.TextMarshaler); ok {
+		return v, nil
+	}
+
+	if m, ok := v.(encoding.BinaryMarshaler); ok {
+		return m.MarshalBinary()
+	}
+
+	return v, nil
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	case reflect.Struct:
+		if t, ok := v.Interface().(time.Time); ok {
+			return t.IsZero()
+		}
+	}
+	return false
+}
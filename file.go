@@ -0,0 +1,175 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// A FileRegistry is a ServerRegistry which reads servers from a YAML or JSON
+// file, and reloads them when the file changes. The file lists the servers
+// under a "servers" key:
+//
+//	servers:
+//	  - name: shard-0
+//	    addr: 10.0.0.1:6379
+//	    weight: 2
+//	    replicas: [10.0.0.2:6379]
+//	  - name: shard-1
+//	    addr: 10.0.1.1:6379
+//
+// Only addr is required, the other fields are those of ServerEndpoint. When the
+// file can't be read or is invalid, the servers last read from it are used.
+type FileRegistry struct {
+	// Path is the path of the file that servers are read from, files with a
+	// .json extension are decoded as JSON and others as YAML.
+	Path string
+
+	// ReloadInterval is the interval at which the file is checked for changes,
+	// if zero 1 second is used.
+	ReloadInterval time.Duration
+
+	// ErrorLog specifies an optional logger for errors reading the file and
+	// reloads. If nil, logging goes to os.Stderr via the log package's standard
+	// logger.
+	ErrorLog Logger
+
+	cache   RegistryCache
+	modTime time.Time
+	size    int64
+	lastErr string // the error the file was last read with
+}
+
+// fileServers is the content of the files read by FileRegistry.
+type fileServers struct {
+	Servers []fileServer `json:"servers" yaml:"servers"`
+}
+
+type fileServer struct {
+	Name     string   `json:"name"     yaml:"name"`
+	Addr     string   `json:"addr"     yaml:"addr"`
+	Weight   int      `json:"weight"   yaml:"weight"`
+	Replicas []string `json:"replicas" yaml:"replicas"`
+	Cluster  bool     `json:"cluster"  yaml:"cluster"`
+}
+
+// LookupServers satisfies the ServerRegistry interface.
+func (r *FileRegistry) LookupServers(ctx context.Context) (ServerRing, error) {
+	ring, err := r.cache.Lookup(ctx, func(ctx context.Context) {
+		r.reload(ctx)
+		refreshEvery(ctx, r.reloadInterval(), r.reload)
+	})
+
+	if ring == nil && err == nil {
+		err = fmt.Errorf("redis: no servers were found in %s", r.Path)
+	}
+
+	return ring, err
+}
+
+// Close stops watching the file for changes.
+func (r *FileRegistry) Close() error {
+	r.cache.Close()
+	return nil
+}
+
+// reload reads the file and rebuilds the ring if it changed since it was last
+// read, the previous ring is kept if it fails.
+func (r *FileRegistry) reload(ctx context.Context) {
+	info, err := os.Stat(r.Path)
+	if err == nil && info.ModTime().Equal(r.modTime) && info.Size() == r.size {
+		return
+	}
+
+	var endpoints []ServerEndpoint
+
+	if err == nil {
+		endpoints, err = r.read()
+	}
+
+	if err != nil {
+		err = fmt.Errorf("redis: reading servers from %s: %s", r.Path, err)
+
+		// the file is read again on every interval until it's valid, errors
+		// are only reported when they change
+		if err.Error() != r.lastErr {
+			r.lastErr = err.Error()
+			logError(r.ErrorLog, err)
+
+			r.cache.Fail(err)
+		}
+		return
+	}
+
+	// the file is only considered read once it was valid, it may have been
+	// read while it was being written
+	r.modTime, r.size, r.lastErr = info.ModTime(), info.Size(), ""
+
+	if r.cache.current() != nil {
+		logError(r.ErrorLog, fmt.Errorf("redis: reloaded %d servers from %s", len(endpoints), r.Path))
+	}
+
+	r.cache.Update(NewHashRing(endpoints...))
+}
+
+func (r *FileRegistry) read() ([]ServerEndpoint, error) {
+	b, err := ioutil.ReadFile(r.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file fileServers
+
+	if strings.EqualFold(filepath.Ext(r.Path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&file)
+	} else {
+		err = yaml.UnmarshalStrict(b, &file)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make([]ServerEndpoint, 0, len(file.Servers))
+	seen := make(map[string]struct{}, len(file.Servers))
+
+	for i, server := range file.Servers {
+		switch _, dup := seen[server.Addr]; {
+		case len(server.Addr) == 0:
+			return nil, fmt.Errorf("server #%d has no address", i)
+		case dup:
+			return nil, fmt.Errorf("server %s is listed more than once", server.Addr)
+		case server.Weight < 0:
+			return nil, fmt.Errorf("server %s has a negative weight", server.Addr)
+		}
+
+		seen[server.Addr] = struct{}{}
+
+		endpoints = append(endpoints, ServerEndpoint{
+			Name:     server.Name,
+			Addr:     server.Addr,
+			Weight:   server.Weight,
+			Replicas: server.Replicas,
+			Cluster:  server.Cluster,
+		})
+	}
+
+	return endpoints, nil
+}
+
+func (r *FileRegistry) reloadInterval() time.Duration {
+	if r.ReloadInterval > 0 {
+		return r.ReloadInterval
+	}
+	return 1 * time.Second
+}
@@ -0,0 +1,221 @@
+package redis_test
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+
+	"github.com/dolab/redis-go"
+)
+
+func TestFileRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "redis-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// writeFile writes content to path, changing its modification time so the
+	// change is seen regardless of the resolution of the file system.
+	writeFile := func(path string, content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		mtime := time.Now().Add(time.Duration(len(content)) * time.Second)
+		os.Chtimes(path, mtime, mtime)
+	}
+
+	listServers := func(it *assert.Assertions, r *redis.FileRegistry) []redis.ServerEndpoint {
+		ring, err := r.LookupServers(context.Background())
+		if !it.Nil(err) {
+			return nil
+		}
+		return ring.(redis.ServerLister).ListServers()
+	}
+
+	t.Run("reads and reloads YAML files", func(t *testing.T) {
+		it := assert.New(t)
+
+		path := filepath.Join(dir, "servers.yml")
+
+		writeFile(path, `
+servers:
+  - name: shard-1
+    addr: 127.0.0.1:7001
+  - name: shard-0
+    addr: 127.0.0.1:7000
+    weight: 2
+    replicas: [127.0.0.1:7100]
+`)
+
+		r := &redis.FileRegistry{
+			Path:           path,
+			ReloadInterval: 10 * time.Millisecond,
+			ErrorLog:       log.New(ioutil.Discard, "", 0),
+		}
+		defer r.Close()
+
+		it.Equal([]redis.ServerEndpoint{
+			{Name: "shard-0", Addr: "127.0.0.1:7000", Weight: 2, Replicas: []string{"127.0.0.1:7100"}},
+			{Name: "shard-1", Addr: "127.0.0.1:7001"},
+		}, listServers(it, r))
+
+		// draining a shard
+		writeFile(path, `
+servers:
+  - name: shard-0
+    addr: 127.0.0.1:7000
+`)
+
+		deadline := time.Now().Add(2 * time.Second)
+
+		for time.Now().Before(deadline) && len(listServers(it, r)) != 1 {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		it.Equal([]redis.ServerEndpoint{
+			{Name: "shard-0", Addr: "127.0.0.1:7000"},
+		}, listServers(it, r))
+
+		// the servers last read are used while the file is invalid
+		writeFile(path, "servers:\n  - name: shard-0\n    adr: 127.0.0.1:7000\n")
+		time.Sleep(50 * time.Millisecond)
+
+		it.Equal([]redis.ServerEndpoint{
+			{Name: "shard-0", Addr: "127.0.0.1:7000"},
+		}, listServers(it, r))
+	})
+
+	t.Run("reloads files which were invalid when they were read", func(t *testing.T) {
+		it := assert.New(t)
+
+		path := filepath.Join(dir, "partial.yml")
+		valid := "servers:\n  - addr: 127.0.0.1:7000\n"
+
+		writeFile(path, "servers:\n  - addr: 127.0.0.1:6999\n")
+
+		r := &redis.FileRegistry{
+			Path:           path,
+			ReloadInterval: 10 * time.Millisecond,
+			ErrorLog:       log.New(ioutil.Discard, "", 0),
+		}
+		defer r.Close()
+
+		it.Len(listServers(it, r), 1)
+
+		// the file is read while it's being written, then completed without
+		// changing its size or modification time
+		mtime := time.Now().Add(time.Hour)
+
+		if err := ioutil.WriteFile(path, []byte("servers: ["+strings.Repeat(" ", len(valid)-10)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, mtime, mtime)
+		time.Sleep(50 * time.Millisecond)
+
+		if err := ioutil.WriteFile(path, []byte(valid), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, mtime, mtime)
+
+		deadline := time.Now().Add(2 * time.Second)
+
+		for time.Now().Before(deadline) && listServers(it, r)[0].Addr != "127.0.0.1:7000" {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		it.Equal([]redis.ServerEndpoint{
+			{Addr: "127.0.0.1:7000"},
+		}, listServers(it, r))
+	})
+
+	t.Run("reports the errors of files only when they change", func(t *testing.T) {
+		it := assert.New(t)
+
+		path := filepath.Join(dir, "later.yml")
+		logger := &countLogger{}
+
+		r := &redis.FileRegistry{
+			Path:           path,
+			ReloadInterval: 10 * time.Millisecond,
+			ErrorLog:       logger,
+		}
+		defer r.Close()
+
+		_, err := r.LookupServers(context.Background())
+		it.NotNil(err)
+
+		time.Sleep(100 * time.Millisecond)
+		it.Equal(int64(1), logger.count())
+
+		writeFile(path, "servers:\n  - name: shard-0\n")
+		time.Sleep(100 * time.Millisecond)
+		it.Equal(int64(2), logger.count())
+	})
+
+	t.Run("reads JSON files", func(t *testing.T) {
+		it := assert.New(t)
+
+		path := filepath.Join(dir, "servers.json")
+
+		writeFile(path, `{"servers": [{"addr": "127.0.0.1:7000", "cluster": true}]}`)
+
+		r := &redis.FileRegistry{
+			Path:     path,
+			ErrorLog: log.New(ioutil.Discard, "", 0),
+		}
+		defer r.Close()
+
+		it.Equal([]redis.ServerEndpoint{
+			{Addr: "127.0.0.1:7000", Cluster: true},
+		}, listServers(it, r))
+	})
+
+	t.Run("fails on invalid files", func(t *testing.T) {
+		for name, content := range map[string]string{
+			"missing.yml":   "",
+			"noaddr.yml":    "servers:\n  - name: shard-0\n",
+			"duplicate.yml": "servers:\n  - addr: 127.0.0.1:7000\n  - addr: 127.0.0.1:7000\n",
+			"weight.yml":    "servers:\n  - addr: 127.0.0.1:7000\n    weight: -1\n",
+			"empty.json":    "{}",
+		} {
+			path := filepath.Join(dir, name)
+
+			if len(content) != 0 {
+				writeFile(path, content)
+			}
+
+			r := &redis.FileRegistry{
+				Path:     path,
+				ErrorLog: log.New(ioutil.Discard, "", 0),
+			}
+
+			_, err := r.LookupServers(context.Background())
+			assert.New(t).NotNil(err, name)
+
+			r.Close()
+		}
+	})
+}
+
+// countLogger is a Logger counting the lines it's given.
+type countLogger struct {
+	n int64
+}
+
+func (l *countLogger) Print(v ...interface{}) {
+	atomic.AddInt64(&l.n, 1)
+}
+
+func (l *countLogger) count() int64 {
+	return atomic.LoadInt64(&l.n)
+}
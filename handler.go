@@ -19,6 +19,36 @@ type Handler interface {
 	ServeRedis(ResponseWriter, *Request)
 }
 
+// A ConcurrentHandler is a Handler which declares whether it's safe to serve
+// the requests received on a single connection concurrently.
+//
+// When the Handler of a Server implements ConcurrentHandler and its
+// ServeConcurrently method returns true, the commands pipelined by clients are
+// read ahead and loaded in memory, then served by concurrent calls to
+// ServeRedis. The replies are still written in the order of the commands, with
+// a single flush.
+//
+// Handlers relying on the order in which commands are executed, like proxies to
+// redis servers, must not enable concurrency.
+type ConcurrentHandler interface {
+	Handler
+
+	// ServeConcurrently returns true if ServeRedis may be called concurrently
+	// for requests received on the same connection.
+	ServeConcurrently() bool
+}
+
+// An InfoHandler is a Handler which adds its own sections to the replies of
+// INFO commands served by a Server, like the keyspace or replication sections
+// that the server doesn't know about.
+type InfoHandler interface {
+	Handler
+
+	// Info returns the sections added to INFO replies, it is called for every
+	// INFO command so the values reflect the current state of the handler.
+	Info() []InfoSection
+}
+
 // The HandlerFunc type is an adapter to allow the use of ordinary functions as
 // Redis handlers. If f is a function with the appropriate signature.
 type HandlerFunc func(ResponseWriter, *Request)
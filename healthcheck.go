@@ -0,0 +1,422 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHealthCheckInterval is the interval between health checks used
+	// by HealthChecker when none is configured.
+	DefaultHealthCheckInterval = 10 * time.Second
+
+	// DefaultHealthCheckTimeout is the timeout of health checks used by
+	// HealthChecker when none is configured.
+	DefaultHealthCheckTimeout = 1 * time.Second
+)
+
+// A HealthChecker is a ServerRegistry which actively checks the health of the
+// servers exposed by another registry. Every server is sent a PING command on
+// an interval, servers that fail FailureThreshold checks in a row are ejected
+// from the rings returned by LookupServers, and reinstated once they succeed
+// SuccessThreshold checks in a row.
+//
+// Health checks start with the first call to LookupServers and only apply to
+// registries returning rings which implement ServerLister. When all servers are
+// ejected, the ring of the underlying registry is returned unchanged so that
+// requests are still attempted.
+//
+// HealthChecker implements ServerBlacklist, blacklisted servers are counted as
+// failed checks and forwarded to the underlying registry if it implements
+// ServerBlacklist as well.
+type HealthChecker struct {
+	// Registry is the registry exposing the servers to check.
+	Registry ServerRegistry
+
+	// Interval is the duration between two checks of the servers, if zero
+	// DefaultHealthCheckInterval is used.
+	Interval time.Duration
+
+	// Timeout is the maximum duration of a check, if zero
+	// DefaultHealthCheckTimeout is used.
+	Timeout time.Duration
+
+	// FailureThreshold is the number of consecutive failed checks after which
+	// a server is ejected, if zero 3 is used.
+	FailureThreshold int
+
+	// SuccessThreshold is the number of consecutive successful checks after
+	// which an ejected server is reinstated, if zero 2 is used.
+	SuccessThreshold int
+
+	// DialContext specifies the dial function for creating connections to
+	// check servers. If DialContext is nil, then DefaultDialer is used.
+	DialContext func(context.Context, string, string) (net.Conn, error)
+
+	// ErrorLog specifies an optional logger for servers ejected and reinstated.
+	// If nil, logging goes to os.Stderr via the log package's standard logger.
+	ErrorLog Logger
+
+	once    sync.Once
+	mutex   sync.Mutex
+	servers map[string]*ServerHealth
+	version uint64 // incremented when servers are ejected or reinstated
+	cached  healthRing
+	cancel  context.CancelFunc
+	closed  bool
+}
+
+// healthRing is the ring of the healthy servers of a ring of the registry of a
+// HealthChecker, at a version of the health of the servers. Registries may
+// return new rings on every lookup, so rings are told apart by their endpoints.
+type healthRing struct {
+	endpoints []ServerEndpoint
+	version   uint64
+	filtered  ServerRing
+}
+
+// ServerHealth represents the health of a server checked by a HealthChecker.
+type ServerHealth struct {
+	Endpoint ServerEndpoint
+
+	// Healthy is false when the server is ejected.
+	Healthy bool
+
+	// Failures and Successes are the numbers of consecutive failed and
+	// successful checks of the server.
+	Failures  int
+	Successes int
+
+	// LastCheck is the time of the last check of the server, and LastError
+	// the error it failed with, if any.
+	LastCheck time.Time
+	LastError error
+}
+
+// LookupServers satisfies the ServerRegistry interface.
+func (h *HealthChecker) LookupServers(ctx context.Context) (ServerRing, error) {
+	h.once.Do(h.start)
+
+	ring, err := h.Registry.LookupServers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lister, ok := ring.(ServerLister)
+	if !ok {
+		return ring, nil
+	}
+
+	endpoints := lister.ListServers()
+
+	h.mutex.Lock()
+	cached, version := h.cached, h.version
+	h.mutex.Unlock()
+
+	if cached.version == version && cached.filtered != nil && reflect.DeepEqual(cached.endpoints, endpoints) {
+		return cached.filtered, nil
+	}
+
+	healthy, version := h.track(endpoints)
+	filtered := ring
+
+	if len(healthy) != 0 && len(healthy) != len(endpoints) {
+		filtered = filterRing(ring, healthy)
+	}
+
+	// rings are rebuilt only once the health of servers or the servers of the
+	// registry change
+	h.mutex.Lock()
+	h.cached = healthRing{endpoints: endpoints, version: version, filtered: filtered}
+	h.mutex.Unlock()
+
+	return filtered, nil
+}
+
+// filterRing returns the ring of the healthy endpoints of ring, keeping the
+// distribution of keys to the healthy endpoints when ring is a hashRing.
+func filterRing(ring ServerRing, healthy []ServerEndpoint) ServerRing {
+	if r, ok := ring.(hashRing); ok {
+		return r.filter(healthy)
+	}
+	return NewHashRing(healthy...)
+}
+
+// BlacklistServer satisfies the ServerBlacklist interface.
+func (h *HealthChecker) BlacklistServer(endpoint ServerEndpoint) {
+	h.update(endpoint.Addr, fmt.Errorf("redis: server %s was blacklisted", endpoint.Addr))
+
+	if b, ok := h.Registry.(ServerBlacklist); ok {
+		b.BlacklistServer(endpoint)
+	}
+}
+
+// Health returns the health of the servers known to h, sorted by address.
+func (h *HealthChecker) Health() []ServerHealth {
+	h.mutex.Lock()
+
+	list := make([]ServerHealth, 0, len(h.servers))
+	for _, s := range h.servers {
+		list = append(list, *s)
+	}
+
+	h.mutex.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Endpoint.Addr < list[j].Endpoint.Addr
+	})
+
+	return list
+}
+
+// Close stops the health checks.
+func (h *HealthChecker) Close() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.closed = true
+
+	if h.cancel != nil {
+		h.cancel()
+	}
+
+	return nil
+}
+
+func (h *HealthChecker) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	h.mutex.Lock()
+
+	if h.closed {
+		h.mutex.Unlock()
+		cancel()
+		return
+	}
+
+	h.cancel = cancel
+	h.mutex.Unlock()
+
+	go h.run(ctx)
+}
+
+func (h *HealthChecker) run(ctx context.Context) {
+	ticker := time.NewTicker(h.interval())
+	defer ticker.Stop()
+
+	for {
+		h.check(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// check refreshes the list of servers from the registry and checks all of them
+// concurrently.
+func (h *HealthChecker) check(ctx context.Context) {
+	lookupCtx, cancel := context.WithTimeout(ctx, h.timeout())
+	ring, err := h.Registry.LookupServers(lookupCtx)
+	cancel()
+
+	if err == nil {
+		if lister, ok := ring.(ServerLister); ok {
+			h.track(lister.ListServers())
+		}
+	}
+
+	h.mutex.Lock()
+
+	endpoints := make([]ServerEndpoint, 0, len(h.servers))
+	for _, s := range h.servers {
+		endpoints = append(endpoints, s.Endpoint)
+	}
+
+	h.mutex.Unlock()
+
+	wg := sync.WaitGroup{}
+
+	for _, endpoint := range endpoints {
+		wg.Add(1)
+
+		go func(endpoint ServerEndpoint) {
+			defer wg.Done()
+
+			err := h.probe(ctx, endpoint.Addr)
+
+			if ctx.Err() == nil {
+				h.update(endpoint.Addr, err)
+			}
+		}(endpoint)
+	}
+
+	wg.Wait()
+}
+
+// probe sends a PING command to the server at addr.
+func (h *HealthChecker) probe(ctx context.Context, addr string) error {
+	timeout := h.timeout()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dialContext := h.DialContext
+	if dialContext == nil {
+		dialContext = DefaultDialer.DialContext
+	}
+
+	c, err := dialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	conn := NewClientConn(c)
+	defer conn.Close()
+
+	return ping(conn, timeout)
+}
+
+// track records the endpoints of the registry, forgetting about the servers
+// which are not part of it anymore. It returns the endpoints that are healthy,
+// and the version of the health of the servers.
+func (h *HealthChecker) track(endpoints []ServerEndpoint) ([]ServerEndpoint, uint64) {
+	healthy := make([]ServerEndpoint, 0, len(endpoints))
+	listed := make(map[string]struct{}, len(endpoints))
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.servers == nil {
+		h.servers = make(map[string]*ServerHealth)
+	}
+
+	for _, endpoint := range endpoints {
+		listed[endpoint.Addr] = struct{}{}
+
+		s := h.servers[endpoint.Addr]
+		if s == nil {
+			s = &ServerHealth{Endpoint: endpoint, Healthy: true}
+			h.servers[endpoint.Addr] = s
+
+			gometrics.SetUpstreamHealth(endpoint.Addr, true)
+		}
+
+		s.Endpoint = endpoint
+
+		if s.Healthy {
+			healthy = append(healthy, endpoint)
+		}
+	}
+
+	for addr := range h.servers {
+		if _, ok := listed[addr]; !ok {
+			delete(h.servers, addr)
+		}
+	}
+
+	return healthy, h.version
+}
+
+// update records the result of a check of the server at addr, ejecting or
+// reinstating it when the thresholds are reached.
+func (h *HealthChecker) update(addr string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+
+	gometrics.IncHealthChecks(addr, result)
+
+	h.mutex.Lock()
+
+	s := h.servers[addr]
+	if s == nil {
+		h.mutex.Unlock()
+		return
+	}
+
+	s.LastCheck = time.Now()
+	s.LastError = err
+
+	var changed bool
+
+	if err != nil {
+		s.Failures++
+		s.Successes = 0
+
+		if s.Healthy && s.Failures >= h.failureThreshold() {
+			s.Healthy, changed = false, true
+		}
+	} else {
+		s.Successes++
+		s.Failures = 0
+
+		if !s.Healthy && s.Successes >= h.successThreshold() {
+			s.Healthy, changed = true, true
+		}
+	}
+
+	if changed {
+		h.version++
+	}
+
+	healthy := s.Healthy
+	h.mutex.Unlock()
+
+	if !changed {
+		return
+	}
+
+	gometrics.SetUpstreamHealth(addr, healthy)
+
+	if healthy {
+		h.log(fmt.Errorf("redis: reinstating server %s after %d successful health checks", addr, h.successThreshold()))
+	} else {
+		h.log(fmt.Errorf("redis: ejecting server %s after %d failed health checks: %s", addr, h.failureThreshold(), err))
+	}
+}
+
+func (h *HealthChecker) interval() time.Duration {
+	if h.Interval > 0 {
+		return h.Interval
+	}
+	return DefaultHealthCheckInterval
+}
+
+func (h *HealthChecker) timeout() time.Duration {
+	if h.Timeout > 0 {
+		return h.Timeout
+	}
+	return DefaultHealthCheckTimeout
+}
+
+func (h *HealthChecker) failureThreshold() int {
+	if h.FailureThreshold > 0 {
+		return h.FailureThreshold
+	}
+	return 3
+}
+
+func (h *HealthChecker) successThreshold() int {
+	if h.SuccessThreshold > 0 {
+		return h.SuccessThreshold
+	}
+	return 2
+}
+
+func (h *HealthChecker) log(err error) {
+	if h.ErrorLog != nil {
+		h.ErrorLog.Print(err)
+	} else {
+		log.Print(err)
+	}
+}
@@ -0,0 +1,178 @@
+package redis_test
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+
+	"github.com/dolab/redis-go"
+	"github.com/dolab/redis-go/redistest"
+)
+
+func TestHealthChecker(t *testing.T) {
+	srv, upAddr := redistest.FakeServer(redistest.TestServerHandler())
+	defer srv.Close()
+
+	// reserve an address nothing listens on until the server is brought up
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	downAddr := l.Addr().String()
+	l.Close()
+
+	servers := redis.ServerList{
+		{Name: "up", Addr: upAddr},
+		{Name: "down", Addr: downAddr},
+	}
+
+	waitHealth := func(h *redis.HealthChecker, addr string, healthy bool) bool {
+		deadline := time.Now().Add(2 * time.Second)
+
+		for time.Now().Before(deadline) {
+			for _, s := range h.Health() {
+				if s.Endpoint.Addr == addr && s.Healthy == healthy {
+					return true
+				}
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		return false
+	}
+
+	listServers := func(h *redis.HealthChecker) []redis.ServerEndpoint {
+		ring, err := h.LookupServers(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ring.(redis.ServerLister).ListServers()
+	}
+
+	t.Run("ejects and reinstates servers", func(t *testing.T) {
+		it := assert.New(t)
+
+		h := &redis.HealthChecker{
+			Registry:         servers,
+			Interval:         10 * time.Millisecond,
+			Timeout:          100 * time.Millisecond,
+			FailureThreshold: 2,
+			SuccessThreshold: 2,
+			ErrorLog:         log.New(ioutil.Discard, "", 0),
+		}
+		defer h.Close()
+
+		it.Equal(redis.NewHashRing(servers...).(redis.ServerLister).ListServers(), listServers(h))
+
+		it.True(waitHealth(h, downAddr, false))
+		it.Equal([]redis.ServerEndpoint{servers[0]}, listServers(h))
+
+		for _, s := range h.Health() {
+			if s.Endpoint.Addr == upAddr {
+				it.True(s.Healthy)
+				it.Nil(s.LastError)
+			} else {
+				it.NotNil(s.LastError)
+				it.True(s.Failures >= 2)
+			}
+		}
+
+		l, err := net.Listen("tcp", downAddr)
+		if err != nil {
+			t.Skip(err)
+		}
+
+		down := &redis.Server{
+			Handler:     redistest.TestServerHandler(),
+			ReadTimeout: time.Second,
+		}
+		go down.Serve(l)
+		defer down.Close()
+
+		it.True(waitHealth(h, downAddr, true))
+		it.Equal(redis.NewHashRing(servers...).(redis.ServerLister).ListServers(), listServers(h))
+	})
+
+	t.Run("reuses the ring of healthy servers until their health changes", func(t *testing.T) {
+		it := assert.New(t)
+
+		// the list returns a new ring on every lookup
+		list := redis.ServerList{servers[0], {Name: "zero", Addr: "localhost:0"}}
+
+		h := &redis.HealthChecker{
+			Registry:         list,
+			Interval:         10 * time.Millisecond,
+			Timeout:          100 * time.Millisecond,
+			FailureThreshold: 1,
+			ErrorLog:         log.New(ioutil.Discard, "", 0),
+		}
+		defer h.Close()
+
+		it.Len(listServers(h), 2)
+		it.True(waitHealth(h, "localhost:0", false))
+
+		ring1, err := h.LookupServers(context.Background())
+		it.Nil(err)
+
+		ring2, err := h.LookupServers(context.Background())
+		it.Nil(err)
+
+		it.Len(ring1.(redis.ServerLister).ListServers(), 1)
+		it.Equal(reflect.ValueOf(ring1).Pointer(), reflect.ValueOf(ring2).Pointer())
+	})
+
+	t.Run("fails open when all servers are ejected", func(t *testing.T) {
+		it := assert.New(t)
+
+		broken := redis.ServerList{{Name: "down", Addr: downAddr}, {Name: "zero", Addr: "localhost:0"}}
+
+		h := &redis.HealthChecker{
+			Registry:         broken,
+			Interval:         10 * time.Millisecond,
+			Timeout:          100 * time.Millisecond,
+			FailureThreshold: 1,
+			ErrorLog:         log.New(ioutil.Discard, "", 0),
+		}
+		defer h.Close()
+
+		it.Len(listServers(h), 2)
+
+		// the down address may have been brought up by the previous scenario
+		it.True(waitHealth(h, "localhost:0", false))
+		it.Len(listServers(h), 2)
+	})
+
+	t.Run("counts blacklisted servers as failed checks", func(t *testing.T) {
+		it := assert.New(t)
+
+		h := &redis.HealthChecker{
+			Registry:         servers[:1],
+			Interval:         time.Hour,
+			FailureThreshold: 2,
+			SuccessThreshold: 1,
+			ErrorLog:         log.New(ioutil.Discard, "", 0),
+		}
+		defer h.Close()
+
+		listServers(h)
+
+		// wait for the first check so it doesn't race with the blacklisting
+		for i := 0; i < 200 && h.Health()[0].LastCheck.IsZero(); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		h.BlacklistServer(servers[0])
+
+		health := h.Health()
+		it.Len(health, 1)
+		it.Equal(1, health[0].Failures)
+		it.True(health[0].Healthy)
+	})
+}
@@ -0,0 +1,391 @@
+package redis
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CommandInfo describes a command served by a Server, as reported to clients by
+// the COMMAND command.
+type CommandInfo struct {
+	// Name of the command, in lower case.
+	Name string
+
+	// Arity is the number of arguments of the command, including its name. A
+	// negative value -N means that the command takes at least N arguments.
+	Arity int
+
+	// Flags of the command, like "write", "readonly" or "fast".
+	Flags []string
+
+	// Positions of the first and last keys in the arguments of the command, and
+	// the step between keys. A negative LastKey counts from the end of the
+	// argument list, all three are zero for commands which take no keys.
+	FirstKey int
+	LastKey  int
+	Step     int
+
+	// Summary, Since and Group are the documentation of the command returned by
+	// COMMAND DOCS.
+	Summary string
+	Since   string
+	Group   string
+}
+
+// An InfoSection is a section of the replies to INFO commands.
+type InfoSection struct {
+	// Name of the section, matched case-insensitively by INFO commands.
+	Name string
+
+	// Fields are the lines of the section, in order.
+	Fields []InfoField
+}
+
+// An InfoField is a line of an InfoSection, values are formatted with the %v
+// verb of the fmt package.
+type InfoField struct {
+	Name  string
+	Value interface{}
+}
+
+// commands returns the table of commands served by s, sorted by name.
+func (s *Server) commands() []CommandInfo {
+	table := make(map[string]CommandInfo, len(builtinCommands)+len(s.Commands))
+
+	for _, cmd := range builtinCommands {
+		table[cmd.Name] = cmd
+	}
+
+	for _, cmd := range s.Commands {
+		cmd.Name = strings.ToLower(cmd.Name)
+		table[cmd.Name] = cmd
+	}
+
+	list := make([]CommandInfo, 0, len(table))
+	for _, cmd := range table {
+		list = append(list, cmd)
+	}
+
+	sort.Slice(list, func(i int, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+// serveCommand serves COMMAND requests and their COUNT, INFO, DOCS and LIST
+// subcommands.
+func (s *Server) serveCommand(args Args) interface{} {
+	var (
+		sub   string
+		names []string
+	)
+
+	if args.Next(&sub) {
+		for name := ""; args.Next(&name); name = "" {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+
+	if err := args.Close(); err != nil {
+		return errorf("ERR %s", err)
+	}
+
+	table := s.commands()
+
+	lookup := func(name string) *CommandInfo {
+		i := sort.Search(len(table), func(i int) bool { return table[i].Name >= name })
+		if i < len(table) && table[i].Name == name {
+			return &table[i]
+		}
+		return nil
+	}
+
+	switch strings.ToUpper(sub) {
+	case "":
+		reply := make([]interface{}, len(table))
+		for i := range table {
+			reply[i] = commandReply(&table[i])
+		}
+		return reply
+
+	case "COUNT":
+		return len(table)
+
+	case "LIST":
+		reply := make([]string, len(table))
+		for i := range table {
+			reply[i] = table[i].Name
+		}
+		return reply
+
+	case "INFO":
+		reply := make([]interface{}, len(names))
+		for i, name := range names {
+			if cmd := lookup(name); cmd != nil {
+				reply[i] = commandReply(cmd)
+			}
+		}
+		return reply
+
+	case "DOCS":
+		reply := []interface{}{}
+
+		if len(names) == 0 {
+			for i := range table {
+				names = append(names, table[i].Name)
+			}
+		}
+
+		for _, name := range names {
+			if cmd := lookup(name); cmd != nil {
+				reply = append(reply, cmd.Name, commandDocs(cmd))
+			}
+		}
+		return reply
+	}
+
+	return errorf("ERR unknown subcommand '%s'. Try COMMAND HELP.", sub)
+}
+
+func commandReply(cmd *CommandInfo) []interface{} {
+	flags := cmd.Flags
+	if flags == nil {
+		flags = []string{}
+	}
+
+	return []interface{}{cmd.Name, cmd.Arity, flags, cmd.FirstKey, cmd.LastKey, cmd.Step}
+}
+
+func commandDocs(cmd *CommandInfo) []interface{} {
+	docs := []interface{}{}
+
+	for _, field := range [...]struct{ name, value string }{
+		{"summary", cmd.Summary},
+		{"since", cmd.Since},
+		{"group", cmd.Group},
+	} {
+		if len(field.value) != 0 {
+			docs = append(docs, field.name, []byte(field.value))
+		}
+	}
+
+	return docs
+}
+
+// serveInfo serves INFO requests, the server, clients and stats sections are
+// generated by the server, the sections returned by the handler are appended
+// to them.
+func (s *Server) serveInfo(args Args) interface{} {
+	var (
+		sections = map[string]bool{}
+		section  string
+	)
+
+	for args.Next(&section) {
+		sections[strings.ToLower(section)] = true
+		section = ""
+	}
+
+	if err := args.Close(); err != nil {
+		return errorf("ERR %s", err)
+	}
+
+	all := len(sections) == 0 || sections["all"] || sections["everything"] || sections["default"]
+
+	var b bytes.Buffer
+
+	for _, sec := range s.info() {
+		if !all && !sections[strings.ToLower(sec.Name)] {
+			continue
+		}
+
+		if b.Len() != 0 {
+			b.WriteString("\r\n")
+		}
+
+		fmt.Fprintf(&b, "# %s\r\n", sec.Name)
+
+		for _, f := range sec.Fields {
+			fmt.Fprintf(&b, "%s:%v\r\n", f.Name, f.Value)
+		}
+	}
+
+	return b.Bytes()
+}
+
+func (s *Server) info() []InfoSection {
+	stats := s.serverStats()
+	now := time.Now()
+	uptime := int64(now.Sub(stats.startedAt) / time.Second)
+
+	var (
+		sessions = s.sessions()
+		blocked  = 0
+	)
+
+	for _, sess := range sessions {
+		if strings.Contains(sess.Flags(), "b") {
+			blocked++
+		}
+	}
+
+	port := 0
+
+	s.mutex.Lock()
+	for l := range s.listeners {
+		if addr, ok := l.Addr().(*net.TCPAddr); ok {
+			port = addr.Port
+			break
+		}
+	}
+	s.mutex.Unlock()
+
+	sections := []InfoSection{
+		{Name: "Server", Fields: []InfoField{
+			{"redis_mode", "standalone"},
+			{"go_version", runtime.Version()},
+			{"arch_bits", 32 << (^uint(0) >> 63)},
+			{"process_id", os.Getpid()},
+			{"tcp_port", port},
+			{"uptime_in_seconds", uptime},
+			{"uptime_in_days", uptime / 86400},
+		}},
+		{Name: "Clients", Fields: []InfoField{
+			{"connected_clients", len(sessions)},
+			{"blocked_clients", blocked},
+		}},
+		{Name: "Stats", Fields: []InfoField{
+			{"total_connections_received", atomic.LoadInt64(&stats.connections)},
+			{"total_commands_processed", atomic.LoadInt64(&stats.commands)},
+			{"instantaneous_ops_per_sec", stats.ops.rate(now)},
+			{"total_net_input_bytes", atomic.LoadInt64(&stats.netInput)},
+			{"total_net_output_bytes", atomic.LoadInt64(&stats.netOutput)},
+			{"rejected_connections", atomic.LoadInt64(&stats.rejectedConns)},
+			{"rejected_commands", atomic.LoadInt64(&stats.rejectedCmds)},
+			{"client_output_buffer_limit_disconnections", atomic.LoadInt64(&stats.outputLimited)},
+		}},
+	}
+
+	if h, ok := s.Handler.(InfoHandler); ok {
+		sections = append(sections, h.Info()...)
+	}
+
+	return sections
+}
+
+func (s *Server) serverStats() *serverStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.stats == nil {
+		s.stats = newServerStats()
+	}
+
+	return s.stats
+}
+
+// serverStats holds the counters reported by INFO commands.
+type serverStats struct {
+	connections   int64
+	commands      int64
+	netInput      int64
+	netOutput     int64
+	rejectedConns int64
+	rejectedCmds  int64
+	outputLimited int64
+	startedAt     time.Time
+	ops           opsCounter
+}
+
+func newServerStats() *serverStats {
+	return &serverStats{startedAt: time.Now()}
+}
+
+func (stats *serverStats) addCommands(n int) {
+	atomic.AddInt64(&stats.commands, int64(n))
+	stats.ops.add(time.Now(), int64(n))
+}
+
+func (stats *serverStats) addRejectedConnections(n int) {
+	atomic.AddInt64(&stats.rejectedConns, int64(n))
+}
+
+func (stats *serverStats) addRejectedCommands(n int) {
+	atomic.AddInt64(&stats.rejectedCmds, int64(n))
+}
+
+func (stats *serverStats) addOutputBufferDisconnections(n int) {
+	atomic.AddInt64(&stats.outputLimited, int64(n))
+}
+
+// opsCounter counts operations over the current and previous seconds, the rate
+// of operations is the count of the last complete second.
+type opsCounter struct {
+	mutex sync.Mutex
+	sec   int64
+	curr  int64
+	prev  int64
+}
+
+func (c *opsCounter) add(now time.Time, n int64) {
+	sec := now.Unix()
+
+	c.mutex.Lock()
+
+	if sec != c.sec {
+		if sec == c.sec+1 {
+			c.prev = c.curr
+		} else {
+			c.prev = 0
+		}
+		c.sec, c.curr = sec, 0
+	}
+
+	c.curr += n
+
+	c.mutex.Unlock()
+}
+
+func (c *opsCounter) rate(now time.Time) int64 {
+	sec := now.Unix()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	switch sec {
+	case c.sec:
+		return c.prev
+	case c.sec + 1:
+		return c.curr
+	}
+
+	return 0
+}
+
+// statsConn is a net.Conn wrapper which counts the bytes exchanged with the
+// clients of a server.
+type statsConn struct {
+	net.Conn
+	stats *serverStats
+}
+
+func (c *statsConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.stats.netInput, int64(n))
+	return n, err
+}
+
+func (c *statsConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.stats.netOutput, int64(n))
+	return n, err
+}
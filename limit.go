@@ -0,0 +1,329 @@
+package redis
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dolab/objconv/resp"
+
+	"github.com/dolab/redis-go/metrics"
+)
+
+var (
+	// ErrMaxClientsPerIP is replied to connections rejected because the client
+	// IP address already has MaxConnsPerIP connections opened to the server.
+	ErrMaxClientsPerIP = resp.NewError("ERR max number of clients per IP reached")
+
+	// ErrRateLimited is replied to commands rejected because they exceed one
+	// of the rate limits of the server.
+	ErrRateLimited = resp.NewError("ERR max number of commands per second reached")
+
+	// ErrMaxInflight is replied to commands rejected because the server is
+	// already serving MaxInflight requests.
+	ErrMaxInflight = resp.NewError("ERR max number of in-flight requests reached")
+)
+
+// A RateLimit configures a token bucket limiting the rate at which commands are
+// served.
+type RateLimit struct {
+	// Rate is the number of commands served per second, zero means no limit.
+	Rate float64
+
+	// Burst is the number of commands that can be served at once after the
+	// limit was not reached for a while. If zero, the integer part of Rate is
+	// used, but at least one command.
+	Burst int
+}
+
+func (r RateLimit) enabled() bool {
+	return r.Rate > 0
+}
+
+func (r RateLimit) burst() float64 {
+	switch {
+	case r.Burst > 0:
+		return float64(r.Burst)
+	case r.Rate >= 1:
+		return float64(int64(r.Rate))
+	}
+	return 1
+}
+
+// tokenBucket holds the state of a RateLimit, the zero value is a full bucket.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// advance refills the bucket with the tokens produced since it was last
+// advanced.
+func (b *tokenBucket) advance(now time.Time, r RateLimit) {
+	burst := r.burst()
+
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * r.Rate
+	}
+
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+
+	b.last = now
+}
+
+// delay returns how long to wait for n tokens to be available in the bucket.
+// Requests of more tokens than the burst only wait for a full bucket, the
+// tokens that they overdraw delay the next ones.
+func (b *tokenBucket) delay(n float64, r RateLimit) time.Duration {
+	if burst := r.burst(); n > burst {
+		n = burst
+	}
+
+	if b.tokens >= n {
+		return 0
+	}
+
+	return time.Duration((n - b.tokens) / r.Rate * float64(time.Second))
+}
+
+// full reports whether the bucket was not used for long enough to be refilled.
+func (b *tokenBucket) full(now time.Time, r RateLimit) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*r.Rate >= r.burst()
+}
+
+// maxIdleBuckets is the number of buckets of client addresses or users above
+// which the ones that are full get discarded.
+const maxIdleBuckets = 1024
+
+// limiter holds the state of the admission control of a server.
+type limiter struct {
+	mutex    sync.Mutex
+	conns    map[string]int
+	ips      map[string]*tokenBucket
+	users    map[string]*tokenBucket
+	inflight chan struct{}
+}
+
+// acquireConn counts a connection from ip, it returns false if there are
+// already max connections from this address.
+func (l *limiter) acquireConn(ip string, max int) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.conns[ip] >= max {
+		return false
+	}
+
+	if l.conns == nil {
+		l.conns = make(map[string]int)
+	}
+
+	l.conns[ip]++
+	return true
+}
+
+func (l *limiter) releaseConn(ip string) {
+	l.mutex.Lock()
+
+	if l.conns[ip]--; l.conns[ip] <= 0 {
+		delete(l.conns, ip)
+	}
+
+	l.mutex.Unlock()
+}
+
+// reserve takes n tokens from the buckets of the connection, client address and
+// user of sess. It returns how long to wait before serving the commands, or
+// false if it is longer than maxDelay, in which case no tokens are taken.
+func (l *limiter) reserve(sess *Session, n int, maxDelay time.Duration, config serverConfig) (time.Duration, bool) {
+	var (
+		now     = time.Now()
+		user    = sess.User()
+		buckets [3]*tokenBucket
+		rates   [3]RateLimit
+		delay   time.Duration
+	)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if config.connRateLimit.enabled() {
+		buckets[0], rates[0] = &sess.rate, config.connRateLimit
+	}
+
+	if config.ipRateLimit.enabled() {
+		buckets[1], rates[1] = bucketOf(&l.ips, clientIP(sess.addr), now, config.ipRateLimit), config.ipRateLimit
+	}
+
+	if config.userRateLimit.enabled() && len(user) != 0 {
+		buckets[2], rates[2] = bucketOf(&l.users, user, now, config.userRateLimit), config.userRateLimit
+	}
+
+	for i, b := range buckets {
+		if b == nil {
+			continue
+		}
+
+		b.advance(now, rates[i])
+
+		if d := b.delay(float64(n), rates[i]); d > delay {
+			delay = d
+		}
+	}
+
+	if delay > maxDelay {
+		return delay, false
+	}
+
+	for _, b := range buckets {
+		if b != nil {
+			b.tokens -= float64(n)
+		}
+	}
+
+	return delay, true
+}
+
+// bucketOf returns the bucket of key in m, creating it if needed. Full buckets
+// are discarded when there are too many of them, they are equivalent to new
+// ones.
+func bucketOf(m *map[string]*tokenBucket, key string, now time.Time, r RateLimit) *tokenBucket {
+	if b := (*m)[key]; b != nil {
+		return b
+	}
+
+	if *m == nil {
+		*m = make(map[string]*tokenBucket)
+	}
+
+	if len(*m) >= maxIdleBuckets {
+		for k, b := range *m {
+			if b.full(now, r) {
+				delete(*m, k)
+			}
+		}
+	}
+
+	b := &tokenBucket{}
+	(*m)[key] = b
+	return b
+}
+
+// semaphore returns the channel counting the in-flight requests of the server,
+// of capacity max.
+func (l *limiter) semaphore(max int) chan struct{} {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.inflight == nil {
+		l.inflight = make(chan struct{}, max)
+	}
+
+	return l.inflight
+}
+
+// admit waits until n commands received on sess can be served within the limits
+// of the server. It returns a function which must be called once the commands
+// were served, or the error replied to the client when they are rejected.
+//
+// The read timeout of the connection is reset after waiting, since the
+// arguments of the commands may not have been read yet. A non-RESP error is
+// returned if the session was closed while waiting.
+func (s *Server) admit(sess *Session, n int, config serverConfig) (func(), error) {
+	var (
+		deadline = time.Now().Add(config.limitWait)
+		release  = func() {}
+		waited   = false
+	)
+
+	defer func() {
+		if waited {
+			sess.conn.setTimeout(config.readTimeout)
+		}
+	}()
+
+	if config.connRateLimit.enabled() || config.ipRateLimit.enabled() || config.userRateLimit.enabled() {
+		delay, ok := s.limiter.reserve(sess, n, config.limitWait, config)
+		if !ok {
+			return nil, s.reject(sess, ErrRateLimited, "rate_limit", config)
+		}
+
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+
+			select {
+			case <-timer.C:
+				waited = true
+			case <-sess.context.Done():
+				timer.Stop()
+				return nil, sess.context.Err()
+			}
+		}
+	}
+
+	if config.maxInflight > 0 {
+		sem := s.limiter.semaphore(config.maxInflight)
+
+		select {
+		case sem <- struct{}{}:
+		default:
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return nil, s.reject(sess, ErrMaxInflight, "max_inflight", config)
+			}
+
+			timer := time.NewTimer(wait)
+
+			select {
+			case sem <- struct{}{}:
+				timer.Stop()
+				waited = true
+			case <-timer.C:
+				return nil, s.reject(sess, ErrMaxInflight, "max_inflight", config)
+			case <-sess.context.Done():
+				timer.Stop()
+				return nil, sess.context.Err()
+			}
+		}
+
+		release = func() { <-sem }
+	}
+
+	return release, nil
+}
+
+// reject counts a request rejected for reason and returns err.
+func (s *Server) reject(sess *Session, err error, reason string, config serverConfig) error {
+	config.stats.addRejectedCommands(1)
+
+	gometrics.IncRejections(metrics.TrimPort(sess.addr), metrics.TrimPort(sess.laddr), reason)
+	return err
+}
+
+// rejectConnection replies err to the connection c and closes it.
+func (s *Server) rejectConnection(c net.Conn, err error, reason string, config serverConfig) {
+	defer c.Close()
+
+	config.stats.addRejectedConnections(1)
+
+	gometrics.IncRejections(metrics.TrimPort(c.RemoteAddr().String()), metrics.TrimPort(c.LocalAddr().String()), reason)
+
+	if config.writeTimeout != 0 {
+		c.SetWriteDeadline(time.Now().Add(config.writeTimeout))
+	}
+
+	c.Write([]byte("-" + err.Error() + "\r\n"))
+}
+
+// clientIP returns the IP address of the client at addr, or addr itself if it
+// has no port, as is the case of unix sockets.
+func clientIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,172 @@
+package redis
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// listenFdsStart is the first file descriptor of the listening sockets passed
+// to a process, as defined by the socket activation protocol of systemd.
+const listenFdsStart = 3
+
+var (
+	inheritedOnce      sync.Once
+	inheritedMutex     sync.Mutex
+	inheritedListeners []net.Listener
+)
+
+// Listen announces on the network address like net.Listen, unless the process
+// inherited a socket listening on this address from its parent, in which case
+// the inherited listener is returned.
+//
+// Sockets are inherited following the socket activation protocol of systemd:
+// the LISTEN_FDS environment variable holds the number of sockets passed from
+// file descriptor 3 on, and LISTEN_PID the process they're passed to, if set.
+// Each inherited socket is returned once, StartProcess hands listeners to a
+// new process this way.
+//
+// Server.ListenAndServe uses Listen, so a server restarted by StartProcess
+// keeps serving connections on the same socket.
+func Listen(network string, address string) (net.Listener, error) {
+	inheritedOnce.Do(loadInheritedListeners)
+
+	inheritedMutex.Lock()
+
+	for i, l := range inheritedListeners {
+		if listensOn(l.Addr(), network, address) {
+			inheritedListeners = append(inheritedListeners[:i], inheritedListeners[i+1:]...)
+			inheritedMutex.Unlock()
+			return l, nil
+		}
+	}
+
+	inheritedMutex.Unlock()
+	return net.Listen(network, address)
+}
+
+// StartProcess starts a new process running the program at path with args,
+// which inherits the listeners so it can serve connections on the same sockets
+// with Listen. The listeners remain usable by the calling process, which is
+// expected to stop accepting connections and drain the ones it has with
+// Server.Shutdown once the new process is ready.
+//
+// The listeners must be *net.TCPListener or *net.UnixListener values.
+func StartProcess(path string, args []string, listeners ...net.Listener) (*os.Process, error) {
+	files := make([]*os.File, 0, len(listeners))
+
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for _, l := range listeners {
+		fl, ok := l.(interface {
+			File() (*os.File, error)
+		})
+		if !ok {
+			return nil, fmt.Errorf("redis: cannot hand listeners of type %T to another process", l)
+		}
+
+		f, err := fl.File()
+		if err != nil {
+			return nil, err
+		}
+
+		// the socket file must remain after the calling process closes its
+		// listener
+		if ul, ok := l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+
+		files = append(files, f)
+	}
+
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(removeListenEnv(os.Environ()), "LISTEN_FDS="+strconv.Itoa(len(files)))
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return cmd.Process, nil
+}
+
+// loadInheritedListeners loads the listening sockets passed by the parent
+// process, the environment variables describing them are removed so they don't
+// get passed to child processes.
+func loadInheritedListeners() {
+	defer func() {
+		for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+			os.Unsetenv(name)
+		}
+	}()
+
+	if pid := os.Getenv("LISTEN_PID"); len(pid) != 0 && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return
+	}
+
+	for i := 0; i != n; i++ {
+		f := os.NewFile(uintptr(listenFdsStart+i), "listener")
+
+		l, err := net.FileListener(f)
+		f.Close()
+
+		if err == nil {
+			inheritedListeners = append(inheritedListeners, l)
+		}
+	}
+}
+
+// listensOn reports whether a socket listening on addr accepts connections for
+// the network address passed to Listen.
+func listensOn(addr net.Addr, network string, address string) bool {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		if !strings.HasPrefix(network, "tcp") {
+			return false
+		}
+
+		b, err := net.ResolveTCPAddr(network, address)
+		if err != nil || a.Port != b.Port {
+			return false
+		}
+
+		if len(b.IP) == 0 || b.IP.IsUnspecified() {
+			return len(a.IP) == 0 || a.IP.IsUnspecified()
+		}
+
+		return a.IP.Equal(b.IP)
+
+	case *net.UnixAddr:
+		return strings.HasPrefix(network, "unix") && a.Name == address
+	}
+
+	return false
+}
+
+func removeListenEnv(env []string) []string {
+	list := make([]string, 0, len(env))
+
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, "LISTEN_PID=") && !strings.HasPrefix(kv, "LISTEN_FDS=") && !strings.HasPrefix(kv, "LISTEN_FDNAMES=") {
+			list = append(list, kv)
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,81 @@
+package redis_test
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+
+	"github.com/dolab/redis-go"
+)
+
+func TestStartProcess(t *testing.T) {
+	if addr := os.Getenv("REDIS_TEST_INHERITED_ADDR"); len(addr) != 0 {
+		testInheritedListener(t, addr)
+		return
+	}
+
+	it := assert.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !it.Nil(err) {
+		return
+	}
+
+	defer l.Close()
+
+	addr := l.Addr().String()
+
+	os.Setenv("REDIS_TEST_INHERITED_ADDR", addr)
+	defer os.Unsetenv("REDIS_TEST_INHERITED_ADDR")
+
+	p, err := redis.StartProcess(os.Args[0], []string{"-test.run=^TestStartProcess$"}, l)
+	if !it.Nil(err) {
+		return
+	}
+	defer p.Wait()
+	defer p.Kill()
+
+	// the listener is still open in the current process, the new one can only
+	// accept connections on it if it was inherited
+	conn, err := redis.Dial("tcp", addr)
+	if !it.Nil(err) {
+		return
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	var pid int
+
+	it.Nil(conn.WriteCommands(redis.Command{Cmd: "PID"}))
+	it.Nil(redis.ParseArgs(conn.ReadArgs(), &pid))
+	it.Equal(p.Pid, pid)
+}
+
+// testInheritedListener runs in the process started by TestStartProcess, it
+// serves connections on the inherited listener for a few seconds.
+func testInheritedListener(t *testing.T, addr string) {
+	if os.Getenv("LISTEN_FDS") != "1" {
+		t.Fatal("no listener was inherited")
+	}
+
+	l, err := redis.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &redis.Server{
+		Handler: redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+			r.Close()
+			w.Write(os.Getpid())
+		}),
+		ReadTimeout: time.Second,
+	}
+
+	time.AfterFunc(5*time.Second, func() { srv.Close() })
+
+	srv.Serve(l)
+}
@@ -222,3 +222,72 @@ func (m *Metrics) IncErrors(remoteAddr, localAddr string, cmds []string) {
 
 	m.monitor.server.errors.With(labels).Inc()
 }
+
+func (m *Metrics) IncRejections(remoteAddr, localAddr, reason string) {
+	if !m.Enabled() {
+		return
+	}
+
+	labels := prometheus.Labels{
+		"remote_addr": remoteAddr,
+		"local_addr":  localAddr,
+		"reason":      reason,
+	}
+
+	m.monitor.server.rejections.With(labels).Inc()
+}
+
+func (m *Metrics) SetUpstreamHealth(upstreamAddr string, healthy bool) {
+	if !m.Enabled() {
+		return
+	}
+
+	labels := prometheus.Labels{
+		"upstream_addr": upstreamAddr,
+	}
+
+	value := 0.0
+	if healthy {
+		value = 1
+	}
+
+	m.monitor.server.upstreamHealth.With(labels).Set(value)
+}
+
+func (m *Metrics) IncHealthChecks(upstreamAddr, result string) {
+	if !m.Enabled() {
+		return
+	}
+
+	labels := prometheus.Labels{
+		"upstream_addr": upstreamAddr,
+		"result":        result,
+	}
+
+	m.monitor.server.healthChecks.With(labels).Inc()
+}
+
+func (m *Metrics) SetCircuitState(upstreamAddr string, state int) {
+	if !m.Enabled() {
+		return
+	}
+
+	labels := prometheus.Labels{
+		"upstream_addr": upstreamAddr,
+	}
+
+	m.monitor.server.circuitState.With(labels).Set(float64(state))
+}
+
+func (m *Metrics) IncUpstreamErrors(upstreamAddr, reason string) {
+	if !m.Enabled() {
+		return
+	}
+
+	labels := prometheus.Labels{
+		"upstream_addr": upstreamAddr,
+		"reason":        reason,
+	}
+
+	m.monitor.server.upstreamErrors.With(labels).Inc()
+}
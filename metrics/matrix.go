@@ -19,6 +19,11 @@ type Matrix struct {
 	proxyDuration   *prometheus.HistogramVec
 	requestDuration *prometheus.HistogramVec
 	errors          *prometheus.CounterVec
+	rejections      *prometheus.CounterVec
+	upstreamHealth  *prometheus.GaugeVec
+	healthChecks    *prometheus.CounterVec
+	circuitState    *prometheus.GaugeVec
+	upstreamErrors  *prometheus.CounterVec
 }
 
 // NewServerMatrix creates a new matrix for gRPC server
@@ -153,6 +158,56 @@ func NewServerMatrix(subsystem string, labels prometheus.Labels) *Matrix {
 		},
 		[]string{"remote_addr", "local_addr", "cmds"},
 	)
+	serverRejections := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   "redis",
+			Subsystem:   subsystem,
+			Name:        "rejections_total",
+			Help:        "Total number of connections and commands rejected by the limits of server.",
+			ConstLabels: labels,
+		},
+		[]string{"remote_addr", "local_addr", "reason"},
+	)
+	serverUpstreamHealth := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   "redis",
+			Subsystem:   subsystem,
+			Name:        "upstream_healthy",
+			Help:        "Whether upstream servers are considered healthy (1) or ejected (0) by health checks.",
+			ConstLabels: labels,
+		},
+		[]string{"upstream_addr"},
+	)
+	serverHealthChecks := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   "redis",
+			Subsystem:   subsystem,
+			Name:        "health_checks_total",
+			Help:        "Total number of health checks of upstream servers.",
+			ConstLabels: labels,
+		},
+		[]string{"upstream_addr", "result"},
+	)
+	serverCircuitState := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   "redis",
+			Subsystem:   subsystem,
+			Name:        "upstream_circuit_state",
+			Help:        "State of the circuit breakers of upstream servers, 0 is closed, 1 open and 2 half-open.",
+			ConstLabels: labels,
+		},
+		[]string{"upstream_addr"},
+	)
+	serverUpstreamErrors := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   "redis",
+			Subsystem:   subsystem,
+			Name:        "upstream_errors_total",
+			Help:        "Total number of requests to upstream servers that failed.",
+			ConstLabels: labels,
+		},
+		[]string{"upstream_addr", "reason"},
+	)
 
 	return &Matrix{
 		connections:     serverConnections,
@@ -168,6 +223,11 @@ func NewServerMatrix(subsystem string, labels prometheus.Labels) *Matrix {
 		redisDuration:   serverRedisDuration,
 		requestDuration: serverRequestDuration,
 		errors:          serverErrors,
+		rejections:      serverRejections,
+		upstreamHealth:  serverUpstreamHealth,
+		healthChecks:    serverHealthChecks,
+		circuitState:    serverCircuitState,
+		upstreamErrors:  serverUpstreamErrors,
 	}
 }
 
@@ -182,6 +242,8 @@ func (m *Matrix) Describe(in chan<- *prometheus.Desc) {
 	m.connections.Describe(in)
 	m.requests.Describe(in)
 	m.commands.Describe(in)
+	m.upstreamHealth.Describe(in)
+	m.circuitState.Describe(in)
 
 	// CounterVec
 	m.requestsTotal.Describe(in)
@@ -189,6 +251,9 @@ func (m *Matrix) Describe(in chan<- *prometheus.Desc) {
 	m.bytesReceived.Describe(in)
 	m.bytesSend.Describe(in)
 	m.errors.Describe(in)
+	m.rejections.Describe(in)
+	m.healthChecks.Describe(in)
+	m.upstreamErrors.Describe(in)
 }
 
 // Collect implements prometheus Collector interface.
@@ -202,6 +267,8 @@ func (m *Matrix) Collect(in chan<- prometheus.Metric) {
 	m.connections.Collect(in)
 	m.requests.Collect(in)
 	m.commands.Collect(in)
+	m.upstreamHealth.Collect(in)
+	m.circuitState.Collect(in)
 
 	// CounterVec
 	m.requestsTotal.Collect(in)
@@ -209,4 +276,7 @@ func (m *Matrix) Collect(in chan<- prometheus.Metric) {
 	m.bytesReceived.Collect(in)
 	m.bytesSend.Collect(in)
 	m.errors.Collect(in)
+	m.rejections.Collect(in)
+	m.healthChecks.Collect(in)
+	m.upstreamErrors.Collect(in)
 }
@@ -0,0 +1,284 @@
+package redis
+
+import (
+	"io"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// monitorBufferSize is the number of lines buffered for each monitoring client
+// without output buffer limits, lines are dropped when clients don't read them
+// fast enough.
+const monitorBufferSize = 1024
+
+// monitorMaxArgLen is the number of bytes of arguments reported to monitoring
+// clients, longer ones are truncated to keep the memory of queued lines bounded.
+const monitorMaxArgLen = 128
+
+// monitor is a client connection which receives the commands processed by a
+// Server after sending a MONITOR command.
+type monitor struct {
+	mutex sync.Mutex
+	lines [][]byte
+	out   *outputBuffer
+	ready chan struct{}
+}
+
+// push queues line to be sent to the client. Without output buffer limits,
+// the line is dropped if the client hasn't consumed the previous ones yet so
+// serving commands never stalls.
+func (m *monitor) push(line []byte) {
+	m.mutex.Lock()
+
+	switch {
+	case m.out == nil:
+		if len(m.lines) == monitorBufferSize {
+			m.mutex.Unlock()
+			return
+		}
+
+	case m.out.add(len(line)) != nil:
+		m.mutex.Unlock()
+
+		// wakes up the client so it gets disconnected
+		m.signal()
+		return
+	}
+
+	m.lines = append(m.lines, line)
+	m.mutex.Unlock()
+	m.signal()
+}
+
+func (m *monitor) signal() {
+	select {
+	case m.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns the lines queued for the client, or an error if they exceeded
+// its output buffer limits.
+func (m *monitor) pop() ([][]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.out != nil {
+		if err := m.out.failed(); err != nil {
+			return nil, err
+		}
+	}
+
+	lines := m.lines
+	m.lines = nil
+	return lines, nil
+}
+
+// serveMonitor streams the commands processed by s to the client of sess until
+// it disconnects, the commands it sends are ignored.
+func (s *Server) serveMonitor(sess *Session, config serverConfig) error {
+	c := sess.conn
+	c.setTimeout(0)
+
+	sess.SetClass(ClientMonitor)
+
+	m := &monitor{
+		out:   sess.outputBuffer(),
+		ready: make(chan struct{}, 1),
+	}
+
+	s.addMonitor(m)
+	defer s.removeMonitor(m)
+
+	if err := writeStatus(c, "OK", config); err != nil {
+		return err
+	}
+
+	if err := c.Flush(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		io.Copy(ioutil.Discard, c)
+	}()
+
+	for {
+		select {
+		case <-m.ready:
+			lines, err := m.pop()
+			if err != nil {
+				return err
+			}
+
+			c.setWriteTimeout(config.writeTimeout)
+
+			size := 0
+
+			for _, line := range lines {
+				if _, err := c.Write(line); err != nil {
+					return err
+				}
+
+				size += len(line)
+			}
+
+			if err := c.Flush(); err != nil {
+				return err
+			}
+
+			if m.out != nil {
+				m.out.remove(size)
+			}
+
+		case <-done:
+			return nil
+
+		case <-sess.context.Done():
+			return nil
+		}
+	}
+}
+
+func (s *Server) addMonitor(m *monitor) {
+	s.mutex.Lock()
+
+	if s.monitors == nil {
+		s.monitors = make(map[*monitor]struct{})
+	}
+
+	s.monitors[m] = struct{}{}
+	atomic.AddInt32(&s.nmonitors, 1)
+
+	s.mutex.Unlock()
+}
+
+func (s *Server) removeMonitor(m *monitor) {
+	s.mutex.Lock()
+
+	delete(s.monitors, m)
+	atomic.AddInt32(&s.nmonitors, -1)
+
+	s.mutex.Unlock()
+}
+
+func (s *Server) monitoring() bool {
+	return atomic.LoadInt32(&s.nmonitors) != 0
+}
+
+// publish sends line to the monitoring clients.
+func (s *Server) publish(line []byte) {
+	// the monitors are copied so the server isn't locked while lines get
+	// queued, clients removed meanwhile just never consume the line
+	s.mutex.Lock()
+
+	monitors := make([]*monitor, 0, len(s.monitors))
+	for m := range s.monitors {
+		monitors = append(monitors, m)
+	}
+
+	s.mutex.Unlock()
+
+	for _, m := range monitors {
+		m.push(line)
+	}
+}
+
+// monitorLines loads the arguments of cmds in memory and returns the lines
+// reported to monitoring clients, in the format used by redis:
+//
+//	+1339518083.107412 [0 127.0.0.1:60866] "keys" "*"
+//
+// Arguments longer than monitorMaxArgLen are truncated, like the slow log does:
+//
+//	+1339518083.107412 [0 127.0.0.1:60866] "set" "k" "xxxx"... (872 more bytes)
+//
+// The lines are not terminated yet, so the upstream server that the request is
+// forwarded to can be appended.
+func monitorLines(now time.Time, db int, addr string, cmds []Command) [][]byte {
+	lines := make([][]byte, len(cmds))
+
+	for i := range cmds {
+		cmd := &cmds[i]
+		cmd.loadByteArgs()
+
+		b := append(make([]byte, 0, 64), '+')
+		b = strconv.AppendInt(b, now.Unix(), 10)
+		b = append(b, '.')
+		b = appendPadded(b, int64(now.Nanosecond()/1000), 6)
+		b = append(b, " ["...)
+		b = strconv.AppendInt(b, int64(db), 10)
+		b = append(b, ' ')
+		b = append(b, addr...)
+		b = append(b, ']', ' ')
+		b = appendQuoted(b, []byte(cmd.Cmd))
+
+		if args, ok := cmd.Args.(*byteArgs); ok {
+			for _, arg := range args.args {
+				b = append(b, ' ')
+
+				if len(arg) <= monitorMaxArgLen {
+					b = appendQuoted(b, arg)
+					continue
+				}
+
+				b = appendQuoted(b, arg[:monitorMaxArgLen])
+				b = append(b, "... ("...)
+				b = strconv.AppendInt(b, int64(len(arg)-monitorMaxArgLen), 10)
+				b = append(b, " more bytes)"...)
+			}
+		}
+
+		lines[i] = b
+	}
+
+	return lines
+}
+
+func appendPadded(b []byte, v int64, width int) []byte {
+	s := strconv.FormatInt(v, 10)
+
+	for i := len(s); i < width; i++ {
+		b = append(b, '0')
+	}
+
+	return append(b, s...)
+}
+
+// appendQuoted appends s to b as a quoted string, escaping special and non
+// printable characters like redis does.
+func appendQuoted(b []byte, s []byte) []byte {
+	const hex = "0123456789abcdef"
+
+	b = append(b, '"')
+
+	for _, c := range s {
+		switch c {
+		case '\\', '"':
+			b = append(b, '\\', c)
+		case '\n':
+			b = append(b, '\\', 'n')
+		case '\r':
+			b = append(b, '\\', 'r')
+		case '\t':
+			b = append(b, '\\', 't')
+		case '\a':
+			b = append(b, '\\', 'a')
+		case '\b':
+			b = append(b, '\\', 'b')
+		default:
+			if c < 0x20 || c >= 0x7f {
+				b = append(b, '\\', 'x', hex[c>>4], hex[c&0xf])
+			} else {
+				b = append(b, c)
+			}
+		}
+	}
+
+	return append(b, '"')
+}
@@ -0,0 +1,202 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/dolab/objconv/resp"
+
+	"github.com/dolab/redis-go/metrics"
+)
+
+var (
+	// ErrMaxClients is replied to connections rejected because the server
+	// already has MaxClients connections opened.
+	ErrMaxClients = resp.NewError("ERR max number of clients reached")
+)
+
+// A ClientClass is the kind of a client connection, which determines the output
+// buffer limits that apply to it.
+type ClientClass int
+
+const (
+	// ClientNormal is the class of connections serving regular commands.
+	ClientNormal ClientClass = iota
+
+	// ClientPubSub is the class of connections that handlers flagged as
+	// subscribed to PUB/SUB channels with Session.SetClass.
+	ClientPubSub
+
+	// ClientMonitor is the class of connections that sent a MONITOR command.
+	ClientMonitor
+)
+
+// String returns the name of the class as used by the
+// client-output-buffer-limit directive of redis.
+func (class ClientClass) String() string {
+	switch class {
+	case ClientNormal:
+		return "normal"
+	case ClientPubSub:
+		return "pubsub"
+	case ClientMonitor:
+		return "monitor"
+	}
+	return fmt.Sprintf("ClientClass(%d)", int(class))
+}
+
+// An OutputBufferLimit configures when a server disconnects clients that do not
+// consume their output fast enough, as the client-output-buffer-limit directive
+// of redis does.
+//
+// The output accounted against the limits is the output written for a client
+// since it was last flushed to its connection: the replies to the commands it
+// pipelined, and the commands streamed to MONITOR clients. The output written
+// to hijacked connections, like those of PUB/SUB clients, is accounted while
+// it's being written.
+type OutputBufferLimit struct {
+	// Hard is the number of bytes of pending output above which the client is
+	// disconnected right away, zero means no limit.
+	Hard int64
+
+	// Soft is the number of bytes of pending output above which the client is
+	// disconnected if it remains so for SoftDuration, zero means no limit.
+	Soft         int64
+	SoftDuration time.Duration
+}
+
+func (limit OutputBufferLimit) enabled() bool {
+	return limit.Hard > 0 || limit.Soft > 0
+}
+
+// outputBuffer accounts for the output buffered for the client of a session
+// which was not written to its connection yet. Once the limit is exceeded, all
+// further output is refused with the same error.
+type outputBuffer struct {
+	mutex  sync.Mutex
+	sess   *Session
+	class  ClientClass
+	limit  OutputBufferLimit
+	stats  *serverStats
+	size   int64
+	softAt time.Time
+	err    error
+}
+
+// newOutputBuffer returns an outputBuffer accounting for the output of sess
+// against the limits of class, or nil if no limits are configured for it.
+func newOutputBuffer(sess *Session, class ClientClass) *outputBuffer {
+	limit := sess.limits[class]
+	if !limit.enabled() {
+		return nil
+	}
+
+	return &outputBuffer{
+		sess:  sess,
+		class: class,
+		limit: limit,
+		stats: sess.stats,
+	}
+}
+
+// add accounts for n more bytes of pending output, it returns a non-nil error
+// if the limits are exceeded, which means the client must be disconnected.
+func (out *outputBuffer) add(n int) error {
+	out.mutex.Lock()
+	defer out.mutex.Unlock()
+
+	if out.err != nil {
+		return out.err
+	}
+
+	out.size += int64(n)
+
+	if out.exceeded(time.Now()) {
+		out.err = fmt.Errorf("closing client %s for overcoming of %s output buffer limits", out.sess.addr, out.class)
+		out.stats.addOutputBufferDisconnections(1)
+
+		gometrics.IncRejections(metrics.TrimPort(out.sess.addr), metrics.TrimPort(out.sess.laddr), "output_buffer_limit")
+	}
+
+	return out.err
+}
+
+// remove accounts for n bytes of output that were written to the connection.
+func (out *outputBuffer) remove(n int) {
+	out.mutex.Lock()
+	out.size -= int64(n)
+
+	if out.size <= out.limit.Soft {
+		out.softAt = time.Time{}
+	}
+
+	out.mutex.Unlock()
+}
+
+// reset accounts for all the output being written to the connection.
+func (out *outputBuffer) reset() {
+	out.mutex.Lock()
+	out.size, out.softAt = 0, time.Time{}
+	out.mutex.Unlock()
+}
+
+// failed returns the error that add returned when the limits were exceeded.
+func (out *outputBuffer) failed() error {
+	out.mutex.Lock()
+	defer out.mutex.Unlock()
+	return out.err
+}
+
+func (out *outputBuffer) exceeded(now time.Time) bool {
+	if out.limit.Hard > 0 && out.size > out.limit.Hard {
+		return true
+	}
+
+	if out.limit.Soft <= 0 || out.size <= out.limit.Soft {
+		out.softAt = time.Time{}
+		return false
+	}
+
+	if out.softAt.IsZero() {
+		out.softAt = now
+	}
+
+	return now.Sub(out.softAt) >= out.limit.SoftDuration
+}
+
+// outputWriter is an io.Writer which accounts for the bytes written to w in out.
+type outputWriter struct {
+	w   io.Writer
+	out *outputBuffer
+}
+
+func (w *outputWriter) Write(b []byte) (int, error) {
+	if err := w.out.add(len(b)); err != nil {
+		return 0, err
+	}
+	return w.w.Write(b)
+}
+
+// hijackedWriter is an io.Writer which accounts for the bytes written to the
+// hijacked connection of a client in out, while they're being written.
+type hijackedWriter struct {
+	w   *bufio.Writer
+	out *outputBuffer
+}
+
+func (w *hijackedWriter) Write(b []byte) (int, error) {
+	if err := w.out.add(len(b)); err != nil {
+		return 0, err
+	}
+	defer w.out.remove(len(b))
+
+	n, err := w.w.Write(b)
+	if err == nil {
+		err = w.w.Flush()
+	}
+
+	return n, err
+}
@@ -8,6 +8,8 @@ import (
 	"io"
 	"log"
 	"net"
+	"strconv"
+	"strings"
 
 	"github.com/dolab/objconv/resp"
 )
@@ -22,10 +24,24 @@ type ReverseProxy struct {
 	// requests to.
 	Registry ServerRegistry
 
+	// Databases optionally maps the indexes of logical databases selected by
+	// clients to the registries of servers that their requests are routed to.
+	// Requests on database 0 are routed to the servers of Registry unless it's
+	// in the map, requests on other databases missing from the map are refused
+	// since the upstream servers are always used with their database 0.
+	Databases map[int]ServerRegistry
+
+	// ReadMode configures how readonly commands are routed to the replicas of
+	// the upstream servers, by default all commands are sent to the primary
+	// servers.
+	ReadMode ReadMode
+
 	// ErrorLog specifies an optional logger for errors accepting connections
 	// and unexpected behavior from handlers. If nil, logging goes to os.Stderr
 	// via the log package's standard logger.
 	ErrorLog Logger
+
+	replicas replicaRouter
 }
 
 // ServeRedis satisfies the Handler interface.
@@ -34,16 +50,16 @@ func (proxy *ReverseProxy) ServeRedis(w ResponseWriter, r *Request) {
 }
 
 func (proxy *ReverseProxy) serveRequest(w ResponseWriter, req *Request) {
-	cmds := req.Cmds
-	keys := make([]string, 0, 10)
+	// TODO: looking up servers and rebuilding the hash ring for every request
+	// is not efficient, we should cache and reuse the state.
+	if !proxy.routable(req.DB) {
+		req.Close()
 
-	for i := range cmds {
-		keys = cmds[i].getKeys(keys)
+		w.Write(errorf("ERR DB index is out of range"))
+		return
 	}
 
-	// TODO: looking up servers and rebuilding the hash ring for every request
-	// is not efficient, we should cache and reuse the state.
-	ring, err := proxy.lookupServers(req.Context)
+	ring, err := proxy.lookupServers(req.Context, req.DB)
 	if err != nil {
 		proxy.log(err)
 
@@ -51,32 +67,33 @@ func (proxy *ReverseProxy) serveRequest(w ResponseWriter, req *Request) {
 		return
 	}
 
-	upstream := ""
+	cmds := req.Cmds
+	if len(cmds) == 1 && strings.ToUpper(cmds[0].Cmd) == "SCAN" {
+		proxy.serveScan(w, req, ring)
+		return
+	}
+
+	keys := make([]string, 0, 10)
+
+	for i := range cmds {
+		keys = cmds[i].getKeys(keys)
+	}
+
+	var upstream ServerEndpoint
 	for _, key := range keys {
 		endpoint := ring.LookupServer(key)
 
-		if len(upstream) == 0 {
-			upstream = endpoint.Addr
-		} else if upstream != endpoint.Addr {
+		if len(upstream.Addr) == 0 {
+			upstream = endpoint
+		} else if upstream.Addr != endpoint.Addr {
 			w.Write(errorf("EXECABORT The transaction contains keys that hash to different upstream servers."))
 			return
 		}
 	}
 
-	req.Addr = upstream
-
-	res, err := proxy.roundTrip(req)
-	switch err.(type) {
-	case nil:
-	case *resp.Error:
-		w.Write(err)
-		return
-	default:
-		proxy.log(err)
-
-		proxy.blacklistServer(upstream)
-
-		w.Write(errorf("ERR Connecting to the upstream (%s) server failed.", upstream))
+	res, err := proxy.replicas.roundTrip(proxy.transport(), req, upstream, proxy.ReadMode)
+	if err != nil {
+		proxy.writeUpstreamError(w, req.DB, req.Addr, err)
 		return
 	}
 
@@ -93,6 +110,122 @@ func (proxy *ReverseProxy) serveRequest(w ResponseWriter, req *Request) {
 	}
 }
 
+// serveScan iterates the keys of all upstream servers in turn, the index of the
+// upstream server is encoded in the cursor returned to the client.
+func (proxy *ReverseProxy) serveScan(w ResponseWriter, req *Request, ring ServerRing) {
+	lister, ok := ring.(ServerLister)
+	if !ok {
+		req.Close()
+
+		w.Write(errorf("ERR SCAN is not supported by the registry of upstream servers."))
+		return
+	}
+
+	servers := lister.ListServers()
+	if len(servers) == 0 {
+		req.Close()
+
+		w.Write(errorf("ERR No upstream server was found for the request."))
+		return
+	}
+
+	var (
+		cmd    = req.Cmds[0]
+		cursor uint64
+		args   []interface{}
+		arg    []byte
+	)
+
+	if !cmd.Args.Next(&cursor) {
+		cmd.Args.Close()
+
+		w.Write(errorf("ERR invalid cursor"))
+		return
+	}
+
+	for cmd.Args.Next(&arg) {
+		args = append(args, arg)
+		arg = nil
+	}
+
+	if err := cmd.Args.Close(); err != nil {
+		w.Write(errorf("ERR %s", err))
+		return
+	}
+
+	index, cursor := parseScanCursor(cursor, len(servers))
+	upstream := servers[index].Addr
+
+	req.Addr = upstream
+
+	res, err := proxy.transport().RoundTrip(&Request{
+		Addr:    upstream,
+		Cmds:    []Command{{Cmd: "SCAN", Args: List(append([]interface{}{cursor}, args...)...)}},
+		Context: req.Context,
+		Session: req.Session,
+	})
+	if err != nil {
+		proxy.writeUpstreamError(w, req.DB, upstream, err)
+		return
+	}
+
+	var (
+		next  string
+		items [][]byte
+	)
+
+	switch err = ParseArgs(res.Args, &next, &items); err.(type) {
+	case nil:
+	case *resp.Error:
+		w.Write(err)
+		return
+	default:
+		// Get caught by the server, that way the connection is closed and not
+		// left in an unpredictable state.
+		panic(err)
+	}
+
+	if cursor, err = strconv.ParseUint(next, 10, 64); err != nil {
+		w.Write(errorf("ERR invalid cursor returned by the upstream (%s) server", upstream))
+		return
+	}
+
+	if items == nil {
+		items = [][]byte{}
+	}
+
+	w.WriteStream(2)
+	w.Write([]byte(formatScanCursor(index, cursor, len(servers))))
+	w.Write(items)
+}
+
+func (proxy *ReverseProxy) writeUpstreamError(w ResponseWriter, db int, upstream string, err error) {
+	if _, ok := err.(*resp.Error); ok {
+		w.Write(err)
+		return
+	}
+
+	if err == ErrCircuitOpen {
+		// Requests fail fast while the upstream server is known to be failing
+		// or too slow, it stays blacklisted as long as the circuit is open so
+		// keys get distributed to other servers.
+		proxy.blacklistServer(db, upstream)
+
+		gometrics.IncUpstreamErrors(upstream, "circuit_open")
+
+		w.Write(errorf("ERR The circuit to the upstream (%s) server is open.", upstream))
+		return
+	}
+
+	proxy.log(err)
+
+	proxy.blacklistServer(db, upstream)
+
+	gometrics.IncUpstreamErrors(upstream, "connection")
+
+	w.Write(errorf("ERR Connecting to the upstream (%s) server failed.", upstream))
+}
+
 func (proxy *ReverseProxy) writeTxArgs(w ResponseWriter, res *Response) (err error) {
 	if res.IsRespArray() {
 		w.WriteStream(res.TxArgs.Len())
@@ -159,8 +292,8 @@ func (proxy *ReverseProxy) servePubSub(conn net.Conn, rw *bufio.ReadWriter, comm
 	// - refresh the list of servers periodically so we can rebalance when new servers are added
 }
 
-func (proxy *ReverseProxy) lookupServers(ctx context.Context) (ring ServerRing, err error) {
-	r := proxy.Registry
+func (proxy *ReverseProxy) lookupServers(ctx context.Context, db int) (ring ServerRing, err error) {
+	r := proxy.registry(db)
 	if r == nil {
 		err = errors.New("a redis proxy needs a non-nil registry to LookupServer the list of available servers")
 		return
@@ -169,19 +302,26 @@ func (proxy *ReverseProxy) lookupServers(ctx context.Context) (ring ServerRing,
 	return r.LookupServers(ctx)
 }
 
-func (proxy *ReverseProxy) blacklistServer(upstream string) {
-	if b, ok := proxy.Registry.(ServerBlacklist); ok {
+func (proxy *ReverseProxy) blacklistServer(db int, upstream string) {
+	if b, ok := proxy.registry(db).(ServerBlacklist); ok {
 		b.BlacklistServer(ServerEndpoint{Addr: upstream})
 	}
 }
 
-func (proxy *ReverseProxy) roundTrip(req *Request) (*Response, error) {
-	t := proxy.Transport
-	if t == nil {
-		t = DefaultTransport
-	}
+// routable returns true if requests on the logical database db can be routed
+// to upstream servers.
+func (proxy *ReverseProxy) routable(db int) bool {
+	_, ok := proxy.Databases[db]
+	return ok || db == 0
+}
 
-	return t.RoundTrip(req)
+// registry returns the registry of the servers that requests on the logical
+// database db are routed to.
+func (proxy *ReverseProxy) registry(db int) ServerRegistry {
+	if r, ok := proxy.Databases[db]; ok {
+		return r
+	}
+	return proxy.Registry
 }
 
 func (proxy *ReverseProxy) log(err error) {
@@ -3,7 +3,9 @@ package redis_test
 import (
 	"context"
 	"log"
+	"net"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -149,6 +151,265 @@ func TestReverseProxy_ServeRedisWithOneshot(t *testing.T) {
 	it.Zero(response.shots)
 }
 
+func TestReverseProxy_ServeRedisWithScriptKeys(t *testing.T) {
+	it := assert.New(t)
+
+	srv, addr := redistest.FakeServer(redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+		var (
+			sha, key, arg string
+			numkeys       int
+		)
+		r.Cmds[0].ParseArgs(&sha, &numkeys, &key, &arg)
+
+		w.Write(key + "=" + arg)
+	}))
+	defer srv.Close()
+
+	registry := &lookupRecorder{endpoint: redis.ServerEndpoint{Addr: addr}}
+
+	proxy := &redis.ReverseProxy{
+		Transport: &redis.Transport{},
+		Registry:  registry,
+		ErrorLog:  log.New(os.Stderr, "[Proxy Script] ==> ", 0),
+	}
+
+	script := redis.NewScript("return KEYS[1]")
+
+	request := redis.NewRequest("", "EVALSHA", redis.List(script.Hash(), 1, "key", "value"))
+	request.Context = context.TODO()
+
+	response := &responseWriter{}
+
+	proxy.ServeRedis(response, request)
+
+	it.Equal([]string{"key"}, registry.keys)
+	it.Equal([]interface{}{"key=value"}, response.values)
+}
+
+func TestReverseProxy_ServeRedisWithDatabases(t *testing.T) {
+	it := assert.New(t)
+
+	var servers []string
+
+	for _, name := range []string{"default", "db1"} {
+		name := name
+
+		srv, addr := redistest.FakeServer(redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+			r.Close()
+			w.Write(name)
+		}))
+		defer srv.Close()
+
+		servers = append(servers, addr)
+	}
+
+	transport := &redis.Transport{}
+	defer transport.CloseIdleConnections()
+
+	proxy := &redis.ReverseProxy{
+		Transport: transport,
+		Registry:  redis.ServerList{{Name: "default", Addr: servers[0]}},
+		Databases: map[int]redis.ServerRegistry{
+			1: redis.ServerList{{Name: "db1", Addr: servers[1]}},
+		},
+		ErrorLog: log.New(os.Stderr, "[Proxy Databases] ==> ", 0),
+	}
+
+	for db, expected := range []string{"default", "db1"} {
+		request := redis.NewRequest("", "GET", redis.List("key"))
+		request.Context = context.TODO()
+		request.DB = db
+
+		response := &responseWriter{}
+
+		proxy.ServeRedis(response, request)
+
+		it.Equal([]interface{}{expected}, response.values, "database %d", db)
+	}
+
+	t.Run("refuses requests on databases which are not mapped", func(t *testing.T) {
+		it := assert.New(t)
+
+		request := redis.NewRequest("", "GET", redis.List("key"))
+		request.Context = context.TODO()
+		request.DB = 5
+
+		response := &responseWriter{}
+
+		proxy.ServeRedis(response, request)
+
+		if it.Len(response.values, 1) {
+			err, ok := response.values[0].(error)
+			it.True(ok)
+			it.Contains(err.Error(), "DB index is out of range")
+		}
+	})
+}
+
+func TestReverseProxy_Monitor(t *testing.T) {
+	it := assert.New(t)
+
+	srv, upstream := redistest.FakeServer(redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+		r.Close()
+		w.Write("value")
+	}))
+	defer srv.Close()
+
+	transport := &redis.Transport{}
+	defer transport.CloseIdleConnections()
+
+	proxy, addr := redistest.FakeServer(&redis.ReverseProxy{
+		Transport: transport,
+		Registry:  redis.ServerList{{Name: "upstream", Addr: upstream}},
+		ErrorLog:  log.New(os.Stderr, "[Proxy Monitor] ==> ", 0),
+	})
+	defer proxy.Close()
+
+	mon, err := redis.Dial("tcp", addr)
+	if !it.Nil(err) {
+		return
+	}
+	defer mon.Close()
+
+	mon.SetDeadline(time.Now().Add(3 * time.Second))
+
+	var status string
+
+	it.Nil(mon.WriteCommands(redis.Command{Cmd: "MONITOR"}))
+	it.Nil(redis.ParseArgs(mon.ReadArgs(), &status))
+	it.Equal("OK", status)
+
+	client := &redis.Client{Addr: addr, Transport: transport}
+
+	var value string
+
+	it.Nil(redis.ParseArgs(client.Query(context.Background(), "GET", "key"), &value))
+	it.Equal("value", value)
+
+	line, err := redis.String(mon.ReadArgs())
+	if it.Nil(err) {
+		it.True(strings.HasSuffix(line, `"GET" "key" -> `+upstream), line)
+	}
+}
+
+func TestReverseProxy_SlowLog(t *testing.T) {
+	it := assert.New(t)
+
+	srv, upstream := redistest.FakeServer(redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+		time.Sleep(30 * time.Millisecond)
+		r.Close()
+		w.Write("value")
+	}))
+	defer srv.Close()
+
+	transport := &redis.Transport{}
+	defer transport.CloseIdleConnections()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !it.Nil(err) {
+		return
+	}
+
+	proxy := &redis.Server{
+		Handler: &redis.ReverseProxy{
+			Transport: transport,
+			Registry:  redis.ServerList{{Name: "upstream", Addr: upstream}},
+			ErrorLog:  log.New(os.Stderr, "[Proxy SlowLog] ==> ", 0),
+		},
+		ReadTimeout:      time.Second,
+		SlowLogThreshold: 10 * time.Millisecond,
+	}
+	defer proxy.Close()
+
+	go proxy.Serve(l)
+
+	client := &redis.Client{Addr: l.Addr().String(), Transport: transport}
+
+	it.Nil(redis.ParseArgs(client.Query(context.Background(), "GET", "key"), nil))
+
+	entries := proxy.SlowLog(-1)
+	if it.Equal(1, len(entries)) {
+		it.Equal([]string{"GET", "key"}, entries[0].Args)
+		it.Equal(upstream, entries[0].Upstream)
+	}
+}
+
+func TestReverseProxy_ProxyProtocol(t *testing.T) {
+	it := assert.New(t)
+
+	ul, err := net.Listen("tcp", "127.0.0.1:0")
+	if !it.Nil(err) {
+		return
+	}
+
+	upstream := &redis.Server{
+		Handler: redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+			cmd := r.Cmds[0].Cmd
+			r.Close()
+
+			if cmd == "SCAN" {
+				w.WriteStream(2)
+				w.Write("0")
+				w.Write([]string{r.Addr})
+				return
+			}
+
+			w.Write(r.Addr)
+		}),
+		ProxyProtocol: true,
+		ReadTimeout:   time.Second,
+	}
+	defer upstream.Close()
+
+	go upstream.Serve(ul)
+
+	transport := &redis.Transport{ProxyProtocol: 2}
+	defer transport.CloseIdleConnections()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !it.Nil(err) {
+		return
+	}
+
+	proxy := &redis.Server{
+		Handler: &redis.ReverseProxy{
+			Transport: transport,
+			Registry:  redis.ServerList{{Name: "upstream", Addr: ul.Addr().String()}},
+			ErrorLog:  log.New(os.Stderr, "[Proxy ProxyProtocol] ==> ", 0),
+		},
+		ReadTimeout: time.Second,
+	}
+	defer proxy.Close()
+
+	go proxy.Serve(l)
+
+	for i := 0; i != 2; i++ {
+		conn, err := redis.Dial("tcp", l.Addr().String())
+		if !it.Nil(err) {
+			return
+		}
+
+		conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+		var addr string
+
+		it.Nil(conn.WriteCommands(redis.Command{Cmd: "GET", Args: redis.List("key")}))
+		it.Nil(redis.ParseArgs(conn.ReadArgs(), &addr))
+		it.Equal(conn.LocalAddr().String(), addr)
+
+		var (
+			cursor string
+			addrs  []string
+		)
+
+		it.Nil(conn.WriteCommands(redis.Command{Cmd: "SCAN", Args: redis.List(0)}))
+		it.Nil(redis.ParseArgs(conn.ReadArgs(), &cursor, &addrs))
+		it.Equal([]string{conn.LocalAddr().String()}, addrs)
+
+		conn.Close()
+	}
+}
+
 func BenchmarkReverseProxy_ServeRedis(b *testing.B) {
 	validServers, _, _ := redistest.FakeServerList()
 
@@ -197,3 +458,86 @@ func (w *responseWriter) Write(v interface{}) error {
 
 	return nil
 }
+
+type lookupRecorder struct {
+	mux      sync.Mutex
+	keys     []string
+	endpoint redis.ServerEndpoint
+}
+
+func (r *lookupRecorder) LookupServers(ctx context.Context) (redis.ServerRing, error) {
+	return redis.ServerRingFunc(func(key string) redis.ServerEndpoint {
+		r.mux.Lock()
+		r.keys = append(r.keys, key)
+		r.mux.Unlock()
+
+		return r.endpoint
+	}), nil
+}
+
+func TestReverseProxy_CircuitBreaker(t *testing.T) {
+	it := assert.New(t)
+
+	// reserve an address nothing listens on
+	ul, err := net.Listen("tcp", "127.0.0.1:0")
+	if !it.Nil(err) {
+		return
+	}
+	upstream := ul.Addr().String()
+	ul.Close()
+
+	breaker := &redis.CircuitBreaker{
+		Transport:   &redis.Transport{},
+		MinRequests: 2,
+		OpenTimeout: time.Minute,
+	}
+
+	registry := &blacklistRecorder{
+		ServerList: redis.ServerList{{Name: "upstream", Addr: upstream}},
+	}
+
+	_, serverAddr := redistest.FakeServer(&redis.ReverseProxy{
+		Transport: breaker,
+		Registry:  registry,
+		ErrorLog:  log.New(os.Stderr, "[Proxy CircuitBreaker] ==> ", 0),
+	})
+
+	client := &redis.Client{Addr: serverAddr}
+
+	for i := 0; i != 2; i++ {
+		err := client.Exec(context.Background(), "GET", "key")
+		if it.NotNil(err) {
+			it.Contains(err.Error(), "Connecting to the upstream")
+		}
+	}
+
+	it.Equal(redis.CircuitOpen, breaker.State(upstream))
+
+	err = client.Exec(context.Background(), "GET", "key")
+	if it.NotNil(err) {
+		it.Contains(err.Error(), "circuit to the upstream ("+upstream+") server is open")
+	}
+
+	// the server is blacklisted by the failed requests and while the circuit
+	// is open
+	it.Equal([]string{upstream, upstream, upstream}, registry.list())
+}
+
+type blacklistRecorder struct {
+	redis.ServerList
+
+	mux         sync.Mutex
+	blacklisted []string
+}
+
+func (r *blacklistRecorder) BlacklistServer(endpoint redis.ServerEndpoint) {
+	r.mux.Lock()
+	r.blacklisted = append(r.blacklisted, endpoint.Addr)
+	r.mux.Unlock()
+}
+
+func (r *blacklistRecorder) list() []string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return append([]string{}, r.blacklisted...)
+}
@@ -0,0 +1,287 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrProxyHeader is returned when a connection accepted by a server with
+	// ProxyProtocol enabled doesn't start with a valid PROXY protocol header.
+	ErrProxyHeader = errors.New("redis: invalid PROXY protocol header")
+)
+
+// proxyHeaderTimeout is the maximum duration for reading PROXY protocol headers
+// when the server has no ReadTimeout.
+const proxyHeaderTimeout = 10 * time.Second
+
+// proxyV2Signature is the first 12 bytes of version 2 PROXY protocol headers.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// WriteProxyHeader writes to w a PROXY protocol header of the given version (1
+// or 2), which tells the server receiving the connection that it was opened by
+// the client at src to the address dst.
+//
+// When src and dst are not TCP addresses of the same IP family, the header
+// tells the server to use the addresses of the connection itself.
+func WriteProxyHeader(w io.Writer, version int, src net.Addr, dst net.Addr) error {
+	srcTCP, _ := src.(*net.TCPAddr)
+	dstTCP, _ := dst.(*net.TCPAddr)
+
+	family := 0
+	if srcTCP != nil && dstTCP != nil {
+		switch {
+		case srcTCP.IP.To4() != nil && dstTCP.IP.To4() != nil:
+			family = 4
+		case srcTCP.IP.To4() == nil && dstTCP.IP.To4() == nil:
+			family = 6
+		}
+	}
+
+	var b []byte
+
+	switch version {
+	case 1:
+		if family == 0 {
+			b = []byte("PROXY UNKNOWN\r\n")
+		} else {
+			b = []byte(fmt.Sprintf("PROXY TCP%d %s %s %d %d\r\n", family, srcTCP.IP, dstTCP.IP, srcTCP.Port, dstTCP.Port))
+		}
+
+	case 2:
+		b = append(b, proxyV2Signature...)
+
+		switch family {
+		case 4:
+			b = append(b, 0x21, 0x11, 0, 12)
+			b = append(b, srcTCP.IP.To4()...)
+			b = append(b, dstTCP.IP.To4()...)
+		case 6:
+			b = append(b, 0x21, 0x21, 0, 36)
+			b = append(b, srcTCP.IP.To16()...)
+			b = append(b, dstTCP.IP.To16()...)
+		default:
+			// LOCAL command, the receiver uses the addresses of the connection
+			b = append(b, 0x20, 0x00, 0, 0)
+		}
+
+		if family != 0 {
+			b = append(b, byte(srcTCP.Port>>8), byte(srcTCP.Port), byte(dstTCP.Port>>8), byte(dstTCP.Port))
+		}
+
+	default:
+		return fmt.Errorf("redis: unsupported PROXY protocol version: %d", version)
+	}
+
+	_, err := w.Write(b)
+	return err
+}
+
+// proxyProtocolConn is a connection which was opened through a proxy, its
+// addresses are the ones of the client and of the server it connected to.
+type proxyProtocolConn struct {
+	net.Conn
+	r   *bufio.Reader
+	src net.Addr
+	dst net.Addr
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyProtocolConn) LocalAddr() net.Addr {
+	if c.dst != nil {
+		return c.dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readProxyProtocol reads the PROXY protocol header which conn starts with, it
+// returns a connection exposing the addresses found in the header.
+func readProxyProtocol(conn net.Conn, timeout time.Duration) (net.Conn, error) {
+	if timeout == 0 {
+		timeout = proxyHeaderTimeout
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	r := bufio.NewReader(conn)
+
+	src, dst, err := readProxyHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxyProtocolConn{Conn: conn, r: r, src: src, dst: dst}, nil
+}
+
+// readProxyHeader reads a PROXY protocol header of version 1 or 2 from r, the
+// returned addresses are nil if the header carries none.
+func readProxyHeader(r *bufio.Reader) (src net.Addr, dst net.Addr, err error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch b[0] {
+	case 'P':
+		return readProxyHeaderV1(r)
+	case '\r':
+		return readProxyHeaderV2(r)
+	}
+
+	return nil, nil, ErrProxyHeader
+}
+
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	// the longest header is 107 bytes long
+	var line []byte
+
+	for len(line) <= 107 {
+		b, err := r.ReadSlice('\n')
+		line = append(line, b...)
+
+		if err == nil {
+			break
+		}
+
+		if err != bufio.ErrBufferFull {
+			return nil, nil, err
+		}
+	}
+
+	if len(line) > 107 || !bytes.HasSuffix(line, crlf) {
+		return nil, nil, ErrProxyHeader
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, nil, ErrProxyHeader
+	}
+
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, nil, ErrProxyHeader
+	}
+
+	if len(fields) != 6 {
+		return nil, nil, ErrProxyHeader
+	}
+
+	src, err := parseProxyAddr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dst, err := parseProxyAddr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return src, dst, nil
+}
+
+func parseProxyAddr(host string, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, ErrProxyHeader
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, ErrProxyHeader
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var head [16]byte
+
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, nil, err
+	}
+
+	if !bytes.Equal(head[:12], proxyV2Signature) || head[12]>>4 != 2 {
+		return nil, nil, ErrProxyHeader
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(head[14:]))
+
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+
+	switch head[12] & 0xF {
+	case 0: // LOCAL
+		return nil, nil, nil
+	case 1: // PROXY
+	default:
+		return nil, nil, ErrProxyHeader
+	}
+
+	var size int
+
+	switch head[13] >> 4 {
+	case 1:
+		size = net.IPv4len
+	case 2:
+		size = net.IPv6len
+	default:
+		// unspecified or unix addresses, the addresses of the connection are
+		// used
+		return nil, nil, nil
+	}
+
+	if len(body) < 2*size+4 {
+		return nil, nil, ErrProxyHeader
+	}
+
+	src := &net.TCPAddr{
+		IP:   net.IP(append([]byte{}, body[:size]...)),
+		Port: int(binary.BigEndian.Uint16(body[2*size:])),
+	}
+
+	dst := &net.TCPAddr{
+		IP:   net.IP(append([]byte{}, body[size:2*size]...)),
+		Port: int(binary.BigEndian.Uint16(body[2*size+2:])),
+	}
+
+	return src, dst, nil
+}
+
+// tcpAddr returns the TCP address represented by s, or nil if it is not one.
+func tcpAddr(s string) net.Addr {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return nil
+	}
+
+	addr, err := parseProxyAddr(host, port)
+	if err != nil {
+		return nil
+	}
+
+	return addr
+}
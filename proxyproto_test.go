@@ -0,0 +1,157 @@
+package redis_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+
+	"github.com/dolab/redis-go"
+)
+
+func TestServer_ProxyProtocol(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &redis.Server{
+		Handler: redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+			r.Close()
+			w.WriteStream(2)
+			w.Write(r.Addr)
+			w.Write(r.Session.LocalAddr())
+		}),
+		ProxyProtocol: true,
+		ReadTimeout:   time.Second,
+	}
+	defer srv.Close()
+
+	go srv.Serve(l)
+
+	var (
+		src4 = &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 4242}
+		dst4 = &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 6379}
+		src6 = &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 4242}
+		dst6 = &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 6379}
+	)
+
+	tests := []struct {
+		scenario string
+		version  int
+		src      net.Addr
+		dst      net.Addr
+		expected []string
+	}{
+		{
+			scenario: "version 1 header with IPv4 addresses",
+			version:  1,
+			src:      src4,
+			dst:      dst4,
+			expected: []string{src4.String(), dst4.String()},
+		},
+		{
+			scenario: "version 1 header with IPv6 addresses",
+			version:  1,
+			src:      src6,
+			dst:      dst6,
+			expected: []string{src6.String(), dst6.String()},
+		},
+		{
+			scenario: "version 2 header with IPv4 addresses",
+			version:  2,
+			src:      src4,
+			dst:      dst4,
+			expected: []string{src4.String(), dst4.String()},
+		},
+		{
+			scenario: "version 2 header with IPv6 addresses",
+			version:  2,
+			src:      src6,
+			dst:      dst6,
+			expected: []string{src6.String(), dst6.String()},
+		},
+		{
+			scenario: "version 1 header without addresses",
+			version:  1,
+		},
+		{
+			scenario: "version 2 header without addresses",
+			version:  2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			it := assert.New(t)
+
+			nc, err := net.Dial("tcp", l.Addr().String())
+			if !it.Nil(err) {
+				return
+			}
+
+			conn := redis.NewClientConn(nc)
+			defer conn.Close()
+
+			conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+			if !it.Nil(redis.WriteProxyHeader(nc, test.version, test.src, test.dst)) {
+				return
+			}
+
+			expected := test.expected
+			if expected == nil {
+				expected = []string{nc.LocalAddr().String(), nc.RemoteAddr().String()}
+			}
+
+			var addr, laddr string
+
+			it.Nil(conn.WriteCommands(redis.Command{Cmd: "ADDR"}))
+			it.Nil(redis.ParseArgs(conn.ReadArgs(), &addr, &laddr))
+			it.Equal(expected, []string{addr, laddr})
+		})
+	}
+
+	t.Run("connections without a header are closed", func(t *testing.T) {
+		it := assert.New(t)
+
+		conn, err := redis.Dial("tcp", l.Addr().String())
+		if !it.Nil(err) {
+			return
+		}
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+		it.Nil(conn.WriteCommands(redis.Command{Cmd: "ADDR"}))
+		it.NotNil(conn.ReadArgs().Close())
+	})
+}
+
+func TestWriteProxyHeader(t *testing.T) {
+	it := assert.New(t)
+
+	var (
+		b   bytes.Buffer
+		src = &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 4242}
+		dst = &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 6379}
+	)
+
+	it.Nil(redis.WriteProxyHeader(&b, 1, src, dst))
+	it.Equal("PROXY TCP4 203.0.113.7 198.51.100.1 4242 6379\r\n", b.String())
+
+	b.Reset()
+
+	it.Nil(redis.WriteProxyHeader(&b, 1, src, &net.UnixAddr{Name: "/tmp/redis.sock"}))
+	it.Equal("PROXY UNKNOWN\r\n", b.String())
+
+	b.Reset()
+
+	it.Nil(redis.WriteProxyHeader(&b, 2, src, dst))
+	it.Equal(28, b.Len())
+	it.Equal([]byte("\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c"), b.Bytes()[:16])
+
+	it.NotNil(redis.WriteProxyHeader(&b, 3, src, dst))
+}
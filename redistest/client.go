@@ -254,12 +254,11 @@ func ReadTestPattern(client *redis.Client, total int, templ string, sleep int, t
 				return
 			}
 
-			var v string
-			args.Next(&v)
-			if err := args.Close(); err != nil {
+			v, found, err := redis.LookupString(args)
+			if err != nil {
 				rq <- result{err: err}
 			} else {
-				rq <- result{hit: v == "1"}
+				rq <- result{hit: found && v == "1"}
 			}
 		}(key, results)
 	}
@@ -102,7 +102,7 @@ func TestServerHandler() redis.HandlerFunc {
 				for cmd.Args.Next(&dst) {
 					v, ok := localStore.Load(dst)
 					if !ok {
-						w.Write("")
+						w.Write(nil)
 					} else {
 						vals, ok := v.([]string)
 						if ok {
@@ -128,13 +128,12 @@ func FakeTimeoutServer(handler redis.Handler, timeout time.Duration) (srv *redis
 	}
 
 	srv = &redis.Server{
-		Handler:        handler,
-		ReadTimeout:    3 * time.Second,
-		WriteTimeout:   5 * time.Second,
-		IdleTimeout:    timeout,
-		EnableRetry:    true,
-		EnablePipeline: true,
-		ErrorLog:       log.New(os.Stdout, "[Server Timeout] ", os.O_CREATE|os.O_WRONLY|os.O_APPEND),
+		Handler:      handler,
+		ReadTimeout:  3 * time.Second,
+		WriteTimeout: 5 * time.Second,
+		IdleTimeout:  timeout,
+		EnableRetry:  true,
+		ErrorLog:     log.New(os.Stdout, "[Server Timeout] ", os.O_CREATE|os.O_WRONLY|os.O_APPEND),
 	}
 
 	go func() {
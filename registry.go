@@ -25,6 +25,14 @@ type ServerBlacklist interface {
 	BlacklistServer(ServerEndpoint)
 }
 
+// ServerLister is implemented by some ServerRing to expose the list of server
+// endpoints that keys are distributed to.
+type ServerLister interface {
+	// ListServers returns the server endpoints of the ring, the order of the
+	// endpoints is stable for a given set of servers.
+	ListServers() []ServerEndpoint
+}
+
 // A ServerRingFunc satisfies the ServerRing interface of custom hashing func.
 type ServerRingFunc func(key string) ServerEndpoint
 
@@ -37,6 +45,25 @@ func (fn ServerRingFunc) LookupServer(key string) ServerEndpoint {
 type ServerEndpoint struct {
 	Name string
 	Addr string
+
+	// Weight is the relative share of keys distributed to the server by hash
+	// rings, if zero 1 is used.
+	Weight int
+
+	// Replicas are the addresses of the replicas of the server, readonly
+	// commands may be sent to them depending on the ReadMode of clients.
+	Replicas []string
+
+	// Cluster is true if the server is a node of a redis cluster, the
+	// connections to its replicas are then put in READONLY mode.
+	Cluster bool
+}
+
+func (endpoint ServerEndpoint) weight() int {
+	if endpoint.Weight > 0 {
+		return endpoint.Weight
+	}
+	return 1
 }
 
 // LookupServers satisfies the ServerRegistry interface.
@@ -45,12 +72,20 @@ func (endpoint ServerEndpoint) LookupServers(ctx context.Context) (ServerRing, e
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	default:
-		return ServerRingFunc(func(_ string) ServerEndpoint {
-			return endpoint
-		}), nil
+		return endpoint, nil
 	}
 }
 
+// LookupServer satisfies the ServerRing interface.
+func (endpoint ServerEndpoint) LookupServer(_ string) ServerEndpoint {
+	return endpoint
+}
+
+// ListServers satisfies the ServerLister interface.
+func (endpoint ServerEndpoint) ListServers() []ServerEndpoint {
+	return []ServerEndpoint{endpoint}
+}
+
 // A ServerList represents a list of backend redis servers.
 type ServerList []ServerEndpoint
 
@@ -60,14 +95,6 @@ func (list ServerList) LookupServers(ctx context.Context) (ServerRing, error) {
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	default:
-		endpoints := make([]ServerEndpoint, len(list))
-		copy(endpoints, list)
-
-		return ServerRingFunc(func(key string) ServerEndpoint {
-			// TODO: rebuilding the hash ring for every request is not efficient, we should cache and reuse the state.
-			ring := NewHashRing(endpoints...)
-
-			return ring.LookupServer(key)
-		}), nil
+		return NewHashRing(list...), nil
 	}
 }
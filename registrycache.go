@@ -0,0 +1,154 @@
+package redis
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// A RegistryCache holds the ring of a ServerRegistry which discovers servers in
+// the background, like SentinelRegistry, DNSRegistry and FileRegistry. When
+// discovering servers fails, the ring last discovered is kept.
+//
+// The zero value is ready to use. A RegistryCache must not be copied after
+// first use.
+type RegistryCache struct {
+	once   sync.Once
+	mutex  sync.Mutex
+	ring   ServerRing
+	err    error
+	ready  chan struct{}
+	done   bool
+	cancel context.CancelFunc
+	closed bool
+}
+
+// Lookup starts discover in a new goroutine the first time it's called, then
+// waits for the first call to Update or Fail before returning the ring and
+// error of the cache. The context passed to discover is canceled by Close.
+//
+// Both values are nil when the servers last discovered were an empty list.
+func (c *RegistryCache) Lookup(ctx context.Context, discover func(context.Context)) (ServerRing, error) {
+	c.once.Do(func() { c.start(discover) })
+
+	c.mutex.Lock()
+	ready := c.readyChan()
+	c.mutex.Unlock()
+
+	select {
+	case <-ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ring, c.err
+}
+
+// Update replaces the ring of the cache.
+func (c *RegistryCache) Update(ring ServerRing) {
+	c.mutex.Lock()
+	c.ring, c.err = ring, nil
+	c.setReady()
+	c.mutex.Unlock()
+}
+
+// Fail records that discovering servers failed with err, which is returned by
+// Lookup unless a ring was discovered before.
+func (c *RegistryCache) Fail(err error) {
+	c.mutex.Lock()
+
+	if c.ring == nil {
+		c.err = err
+	}
+
+	c.setReady()
+	c.mutex.Unlock()
+}
+
+// Close cancels the context of the discovery of servers.
+func (c *RegistryCache) Close() {
+	c.mutex.Lock()
+	c.closed = true
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+
+	c.mutex.Unlock()
+}
+
+func (c *RegistryCache) start(discover func(context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c.mutex.Lock()
+	closed := c.closed
+	c.cancel = cancel
+	c.mutex.Unlock()
+
+	if closed {
+		cancel()
+	}
+
+	go func() {
+		discover(ctx)
+
+		// discover may return before discovering anything once closed
+		c.mutex.Lock()
+		c.setReady()
+		c.mutex.Unlock()
+	}()
+}
+
+// current returns the ring of the cache, without waiting for servers to be
+// discovered.
+func (c *RegistryCache) current() ServerRing {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ring
+}
+
+// readyChan returns the channel closed once servers were discovered, the mutex
+// must be locked.
+func (c *RegistryCache) readyChan() chan struct{} {
+	if c.ready == nil {
+		c.ready = make(chan struct{})
+	}
+	return c.ready
+}
+
+// setReady wakes up the calls to Lookup waiting for servers to be discovered,
+// the mutex must be locked.
+func (c *RegistryCache) setReady() {
+	if !c.done {
+		close(c.readyChan())
+		c.done = true
+	}
+}
+
+// refreshEvery calls refresh on interval until ctx is canceled.
+func refreshEvery(ctx context.Context, interval time.Duration, refresh func(context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			refresh(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// logError logs err to logger, or to the standard logger of the log package if
+// it's nil.
+func logError(logger Logger, err error) {
+	if logger != nil {
+		logger.Print(err)
+	} else {
+		log.Print(err)
+	}
+}
@@ -0,0 +1,95 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+
+	"github.com/dolab/redis-go"
+)
+
+func TestRegistryCache(t *testing.T) {
+	ring := redis.ServerEndpoint{Addr: "127.0.0.1:6379"}
+
+	t.Run("waits for servers to be discovered", func(t *testing.T) {
+		it := assert.New(t)
+
+		var cache redis.RegistryCache
+		defer cache.Close()
+
+		discover := func(ctx context.Context) {
+			time.Sleep(10 * time.Millisecond)
+			cache.Update(ring)
+		}
+
+		r, err := cache.Lookup(context.Background(), discover)
+		it.Nil(err)
+		it.Equal(ring, r)
+	})
+
+	t.Run("keeps the ring discovered when discovering servers fails", func(t *testing.T) {
+		it := assert.New(t)
+
+		var cache redis.RegistryCache
+		defer cache.Close()
+
+		failed := errors.New("failed")
+		fail := make(chan struct{})
+
+		discover := func(ctx context.Context) {
+			cache.Fail(failed)
+			<-fail
+			cache.Update(ring)
+			cache.Fail(failed)
+		}
+
+		_, err := cache.Lookup(context.Background(), discover)
+		it.Equal(failed, err)
+
+		close(fail)
+
+		for i := 0; i != 100; i++ {
+			if r, err := cache.Lookup(context.Background(), discover); err == nil {
+				it.Equal(ring, r)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		t.Error("the ring was never discovered")
+	})
+
+	t.Run("stops discovering servers when closed", func(t *testing.T) {
+		it := assert.New(t)
+
+		var cache redis.RegistryCache
+
+		stopped := make(chan struct{})
+
+		discover := func(ctx context.Context) {
+			<-ctx.Done()
+			close(stopped)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err := cache.Lookup(ctx, discover)
+		it.Equal(context.DeadlineExceeded, err)
+
+		cache.Close()
+
+		select {
+		case <-stopped:
+		case <-time.After(time.Second):
+			t.Error("discovering servers was not stopped")
+		}
+
+		r, err := cache.Lookup(context.Background(), discover)
+		it.Nil(r)
+		it.Nil(err)
+	})
+}
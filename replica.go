@@ -0,0 +1,191 @@
+package redis
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A ReadMode configures how clients route readonly commands to the replicas of
+// servers.
+type ReadMode int
+
+const (
+	// ReadPrimary sends all commands to the primary servers.
+	ReadPrimary ReadMode = iota
+
+	// ReadRoundRobin sends readonly commands to the replicas of servers in
+	// turn.
+	ReadRoundRobin
+
+	// ReadLeastLatency sends readonly commands to the replicas of servers
+	// which responded the fastest to the last requests.
+	ReadLeastLatency
+)
+
+// String returns a human-readable representation of the read mode.
+func (mode ReadMode) String() string {
+	switch mode {
+	case ReadPrimary:
+		return "primary"
+	case ReadRoundRobin:
+		return "round-robin"
+	case ReadLeastLatency:
+		return "least-latency"
+	}
+	return fmt.Sprintf("ReadMode(%d)", int(mode))
+}
+
+const (
+	// replicaDownTimeout is how long replicas that failed are not sent
+	// requests.
+	replicaDownTimeout = 10 * time.Second
+
+	// replicaLatencyDecay is the weight of the latest request in the moving
+	// average of the latency of replicas.
+	replicaLatencyDecay = 0.2
+)
+
+// replicaRouter holds the state of the routing of readonly requests to the
+// replicas of servers.
+type replicaRouter struct {
+	mutex   sync.Mutex
+	next    map[string]int           // round-robin index, by primary address
+	latency map[string]time.Duration // moving average, by replica address
+	down    map[string]time.Time     // end of the down period, by replica address
+}
+
+// roundTrip sends req to endpoint with t. Readonly requests are sent to one of
+// the replicas of endpoint selected according to mode, and sent to the primary
+// if the replica fails or if none are available. req.Addr is set to the address
+// that the request was sent to.
+func (r *replicaRouter) roundTrip(t RoundTripper, req *Request, endpoint ServerEndpoint, mode ReadMode) (*Response, error) {
+	req.Addr = endpoint.Addr
+
+	if mode == ReadPrimary || len(endpoint.Replicas) == 0 || !isReadonly(req) {
+		return t.RoundTrip(req)
+	}
+
+	addr := r.route(endpoint, mode)
+	if len(addr) == 0 {
+		return t.RoundTrip(req)
+	}
+
+	// the arguments are buffered so the request can be sent to the primary
+	// if the replica fails
+	args, err := bufferArgs(req)
+	if err != nil {
+		return nil, err
+	}
+
+	replicaReq := newRetryRequest(req, args)
+	replicaReq.Addr = addr
+	replicaReq.readonly = endpoint.Cluster
+
+	issuedAt := time.Now()
+
+	res, err := t.RoundTrip(replicaReq)
+	if err == nil {
+		r.observe(addr, time.Since(issuedAt))
+
+		req.Addr = addr
+		return res, nil
+	}
+
+	if req.Context != nil && req.Context.Err() != nil {
+		return nil, err
+	}
+
+	r.markDown(addr)
+
+	return t.RoundTrip(newRetryRequest(req, args))
+}
+
+// route returns the address of the replica of endpoint that readonly requests
+// are sent to, or an empty string if all replicas are down.
+func (r *replicaRouter) route(endpoint ServerEndpoint, mode ReadMode) string {
+	now := time.Now()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var (
+		selected string
+		best     time.Duration
+	)
+
+	switch mode {
+	case ReadLeastLatency:
+		for _, addr := range endpoint.Replicas {
+			if r.isDown(addr, now) {
+				continue
+			}
+
+			// replicas without measures are tried first
+			if latency := r.latency[addr]; len(selected) == 0 || latency < best {
+				selected, best = addr, latency
+			}
+		}
+
+	default:
+		if r.next == nil {
+			r.next = make(map[string]int)
+		}
+
+		n := len(endpoint.Replicas)
+
+		for i := 0; i != n; i++ {
+			addr := endpoint.Replicas[(r.next[endpoint.Addr]+i)%n]
+
+			if !r.isDown(addr, now) {
+				r.next[endpoint.Addr] = (r.next[endpoint.Addr] + i + 1) % n
+				selected = addr
+				break
+			}
+		}
+	}
+
+	return selected
+}
+
+func (r *replicaRouter) isDown(addr string, now time.Time) bool {
+	until, ok := r.down[addr]
+	if !ok {
+		return false
+	}
+
+	if now.After(until) {
+		delete(r.down, addr)
+		return false
+	}
+
+	return true
+}
+
+// observe records that a request to the replica at addr took d.
+func (r *replicaRouter) observe(addr string, d time.Duration) {
+	r.mutex.Lock()
+
+	if r.latency == nil {
+		r.latency = make(map[string]time.Duration)
+	}
+
+	if latency, ok := r.latency[addr]; ok {
+		d = time.Duration(replicaLatencyDecay*float64(d) + (1-replicaLatencyDecay)*float64(latency))
+	}
+
+	r.latency[addr] = d
+	r.mutex.Unlock()
+}
+
+// markDown stops sending requests to the replica at addr for a while.
+func (r *replicaRouter) markDown(addr string) {
+	r.mutex.Lock()
+
+	if r.down == nil {
+		r.down = make(map[string]time.Time)
+	}
+
+	r.down[addr] = time.Now().Add(replicaDownTimeout)
+	r.mutex.Unlock()
+}
@@ -0,0 +1,157 @@
+package redis_test
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+
+	"github.com/dolab/redis-go"
+	"github.com/dolab/redis-go/redistest"
+)
+
+// replicaServer starts a server replying its name to all commands, after
+// waiting for delay.
+func replicaServer(name string, delay time.Duration) (*redis.Server, string) {
+	return redistest.FakeServer(redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+		r.Close()
+		time.Sleep(delay)
+		w.Write(name)
+	}))
+}
+
+func TestReplicaRouting(t *testing.T) {
+	primary, primaryAddr := replicaServer("primary", 0)
+	defer primary.Close()
+
+	replica1, replica1Addr := replicaServer("replica-1", 0)
+	defer replica1.Close()
+
+	replica2, replica2Addr := replicaServer("replica-2", 20*time.Millisecond)
+	defer replica2.Close()
+
+	// reserve an address nothing listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	downAddr := l.Addr().String()
+	l.Close()
+
+	query := func(it *assert.Assertions, client *redis.Client, cmd string) string {
+		var s string
+
+		it.Nil(redis.ParseArgs(client.Query(context.Background(), cmd, "key"), &s))
+		return s
+	}
+
+	t.Run("sends readonly commands to replicas in turn", func(t *testing.T) {
+		it := assert.New(t)
+
+		client := &redis.Client{
+			Addr:     primaryAddr,
+			Replicas: []string{replica1Addr, replica2Addr},
+			ReadMode: redis.ReadRoundRobin,
+		}
+
+		it.Equal("replica-1", query(it, client, "GET"))
+		it.Equal("replica-2", query(it, client, "GET"))
+		it.Equal("replica-1", query(it, client, "GET"))
+		it.Equal("primary", query(it, client, "SET"))
+		it.Equal("primary", query(it, client, "INCR"))
+	})
+
+	t.Run("sends readonly commands to the fastest replica", func(t *testing.T) {
+		it := assert.New(t)
+
+		client := &redis.Client{
+			Addr:     primaryAddr,
+			Replicas: []string{replica2Addr, replica1Addr},
+			ReadMode: redis.ReadLeastLatency,
+		}
+
+		// the replicas are tried once each before their latency is known
+		query(it, client, "GET")
+		query(it, client, "GET")
+
+		for i := 0; i != 5; i++ {
+			it.Equal("replica-1", query(it, client, "GET"))
+		}
+	})
+
+	t.Run("sends all commands to the primary by default", func(t *testing.T) {
+		it := assert.New(t)
+
+		client := &redis.Client{
+			Addr:     primaryAddr,
+			Replicas: []string{replica1Addr, replica2Addr},
+		}
+
+		it.Equal("primary", query(it, client, "GET"))
+	})
+
+	t.Run("falls back to the primary when replicas are down", func(t *testing.T) {
+		it := assert.New(t)
+
+		client := &redis.Client{
+			Addr:     primaryAddr,
+			Replicas: []string{downAddr, replica1Addr},
+			ReadMode: redis.ReadRoundRobin,
+		}
+
+		it.Equal("primary", query(it, client, "GET"))
+		it.Equal("replica-1", query(it, client, "GET"))
+
+		// the replica that failed isn't sent requests anymore
+		it.Equal("replica-1", query(it, client, "GET"))
+		it.Equal("replica-1", query(it, client, "GET"))
+
+		client.Replicas = []string{downAddr}
+		it.Equal("primary", query(it, client, "GET"))
+	})
+
+	t.Run("routes proxied requests to replicas of upstream servers", func(t *testing.T) {
+		it := assert.New(t)
+
+		var readonly int64
+
+		replica, replicaAddr := redistest.FakeServer(redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+			r.Close()
+
+			if r.Cmds[0].Cmd == "READONLY" {
+				atomic.AddInt64(&readonly, 1)
+				w.Write("OK")
+				return
+			}
+
+			w.Write("replica")
+		}))
+		defer replica.Close()
+
+		_, proxyAddr := redistest.FakeServer(&redis.ReverseProxy{
+			Transport: &redis.Transport{},
+			Registry: redis.ServerList{{
+				Name:     "upstream",
+				Addr:     primaryAddr,
+				Replicas: []string{replicaAddr},
+				Cluster:  true,
+			}},
+			ReadMode: redis.ReadRoundRobin,
+			ErrorLog: log.New(os.Stderr, "[Proxy Replicas] ==> ", 0),
+		})
+
+		client := &redis.Client{Addr: proxyAddr}
+
+		it.Equal("replica", query(it, client, "GET"))
+		it.Equal("replica", query(it, client, "GET"))
+		it.Equal("primary", query(it, client, "SET"))
+
+		// READONLY is sent once on the connection to the replica
+		it.EqualValues(1, atomic.LoadInt64(&readonly))
+	})
+}
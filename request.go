@@ -14,15 +14,33 @@ type Request struct {
 	//
 	// For server requests (when received in a Handler's ServeRedis method),
 	// the Addr field contains the remote address of the client that sent the
-	// request.
+	// request. Handlers which forward requests, like ReverseProxy, set it to
+	// the address of the upstream server, which is then reported to clients
+	// of the MONITOR command.
 	Addr string
 
 	// Cmds is the list of commands submitted by the request.
 	Cmds []Command
 
+	// For server requests, DB is the index of the logical database selected
+	// by the client with the SELECT command when the request was received,
+	// after applying the swaps made by SWAPDB commands.
+	//
+	// For client requests, DB is ignored, commands are sent to the database
+	// selected on the connections to the server.
+	DB int
+
 	// If not nil, this context is used to control asynchronous cancellation of
 	// the request when it is passed to a RoundTripper.
 	Context context.Context
+
+	// For server requests, Session is the state of the client connection that
+	// the request was received on. It is nil for client requests.
+	Session *Session
+
+	// readonly is true when the request is sent to a replica of a redis
+	// cluster node, which serves it only on connections in READONLY mode.
+	readonly bool
 }
 
 // NewRequest returns a new Request, given an address, command, and list of
@@ -49,6 +67,27 @@ func (req *Request) Close() error {
 	return err
 }
 
+// Block marks a server request as blocking, like BLPOP, XREAD BLOCK or WAIT,
+// and returns the context that the handler should wait on, req.Context is set
+// to the returned value as well.
+//
+// The request is then exempt from the read and write timeouts of the server,
+// its context is canceled only when the client disconnects, the server shuts
+// down, or the connection is unblocked by a CLIENT UNBLOCK command. In the
+// latter case the Err method of the context returns context.DeadlineExceeded
+// for the TIMEOUT reason (the default), which handlers should treat as if the
+// command timed out, or ErrUnblocked for the ERROR reason.
+//
+// Handlers must read the arguments of the request before calling Block. Calling
+// Block on client requests has no effect and returns req.Context.
+func (req *Request) Block() context.Context {
+	if req.Session != nil {
+		req.Context = req.Session.block()
+	}
+
+	return req.Context
+}
+
 // IsTransaction returns true if the request is configured to run as a
 // transaction, false otherwise.
 func (req *Request) IsTransaction() bool {
@@ -62,6 +101,7 @@ func (req *Request) newRequest() (reqn *Request, err error) {
 	reqn = &Request{
 		Addr:    "",
 		Cmds:    make([]Command, len(req.Cmds)),
+		DB:      req.DB,
 		Context: req.Context,
 	}
 
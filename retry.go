@@ -0,0 +1,243 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/dolab/objconv/resp"
+)
+
+// A RetryPolicy is a set of conditions under which a RetryTransport retries
+// requests.
+type RetryPolicy int
+
+const (
+	// RetryConnectionErrors retries requests that failed because of network
+	// errors other than timeouts.
+	RetryConnectionErrors RetryPolicy = 1 << iota
+
+	// RetryTimeouts retries requests that timed out.
+	RetryTimeouts
+
+	// RetryLoading retries requests that servers replied LOADING errors to,
+	// while they load their dataset in memory.
+	RetryLoading
+
+	// RetryTryAgain retries requests that servers replied TRYAGAIN errors to,
+	// like multi-key commands during resharding of redis clusters.
+	RetryTryAgain
+
+	// RetryBusy retries requests that servers replied BUSY errors to, while
+	// they run a script.
+	RetryBusy
+
+	// DefaultRetryPolicy retries requests under all conditions.
+	DefaultRetryPolicy = RetryConnectionErrors | RetryTimeouts | RetryLoading | RetryTryAgain | RetryBusy
+)
+
+// A RetryTransport is a RoundTripper which retries requests that failed, with
+// an exponential backoff between attempts.
+//
+// Requests are retried when the conditions of Policy are met, but requests
+// containing commands which aren't known to be idempotent, like INCR, LPUSH,
+// SET with the NX option or module commands, are only retried if they were not sent to the server: when
+// it couldn't be connected to or when it replied a LOADING, TRYAGAIN or BUSY
+// error, since the commands were then not executed.
+//
+// The arguments of requests are buffered in memory to be sent again.
+type RetryTransport struct {
+	// Transport is the RoundTripper sending the requests, if nil
+	// DefaultTransport is used.
+	Transport RoundTripper
+
+	// Policy is the set of conditions under which requests are retried, if
+	// zero DefaultRetryPolicy is used.
+	Policy RetryPolicy
+
+	// MaxAttempts is the maximum number of times requests are sent, including
+	// the first one, if zero 3 is used.
+	MaxAttempts int
+
+	// MinBackoff and MaxBackoff bound the duration waited between attempts,
+	// which doubles after each attempt and is randomized by up to half of its
+	// value. If zero, 10 milliseconds and 1 second are used.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// RoundTrip implements the RoundTripper interface.
+func (t *RetryTransport) RoundTrip(req *Request) (*Response, error) {
+	args, err := bufferArgs(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := req.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	idempotent := isIdempotent(req.Cmds, args)
+
+	for attempt := 1; ; attempt++ {
+		res, err := t.transport().RoundTrip(newRetryRequest(req, args))
+
+		if attempt >= t.maxAttempts() || ctx.Err() != nil || !t.retryable(res, err, idempotent) {
+			return res, err
+		}
+
+		if res != nil {
+			res.Close()
+		}
+
+		timer := time.NewTimer(t.backoff(attempt))
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// retryable reports whether the request which produced res and err can be
+// sent again. Error responses are consumed, res.Args is replaced so the error
+// is still returned when it's not retried.
+func (t *RetryTransport) retryable(res *Response, err error, idempotent bool) bool {
+	policy := t.policy()
+
+	if err != nil {
+		if err == ErrCircuitOpen {
+			return false
+		}
+
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			return policy&RetryTimeouts != 0 && (idempotent || !sent(err))
+		}
+
+		if _, ok := err.(*net.OpError); ok {
+			return policy&RetryConnectionErrors != 0 && (idempotent || !sent(err))
+		}
+
+		return false
+	}
+
+	if res.Args == nil || !res.IsRespError() {
+		return false
+	}
+
+	err = res.Args.Close()
+	res.Args = newArgsError(err)
+
+	e, ok := err.(*resp.Error)
+	if !ok {
+		return false
+	}
+
+	switch e.Type() {
+	case "LOADING":
+		return policy&RetryLoading != 0
+	case "TRYAGAIN":
+		return policy&RetryTryAgain != 0
+	case "BUSY":
+		return policy&RetryBusy != 0
+	}
+
+	return false
+}
+
+func (t *RetryTransport) backoff(attempt int) time.Duration {
+	min, max := t.MinBackoff, t.MaxBackoff
+
+	if min <= 0 {
+		min = 10 * time.Millisecond
+	}
+
+	if max <= 0 {
+		max = 1 * time.Second
+	}
+
+	d := min
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+
+	if d > max {
+		d = max
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (t *RetryTransport) transport() RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return DefaultTransport
+}
+
+func (t *RetryTransport) policy() RetryPolicy {
+	if t.Policy != 0 {
+		return t.Policy
+	}
+	return DefaultRetryPolicy
+}
+
+func (t *RetryTransport) maxAttempts() int {
+	if t.MaxAttempts > 0 {
+		return t.MaxAttempts
+	}
+	return 3
+}
+
+// bufferArgs reads the arguments of the commands of req, which is closed.
+func bufferArgs(req *Request) ([][]interface{}, error) {
+	list := make([][]interface{}, len(req.Cmds))
+
+	for i, cmd := range req.Cmds {
+		if cmd.Args == nil {
+			continue
+		}
+
+		var v interface{}
+
+		for cmd.Args.Next(&v) {
+			list[i] = append(list[i], v)
+			v = nil
+		}
+
+		if err := cmd.Args.Close(); err != nil {
+			req.Close()
+			return nil, fmt.Errorf("redis: reading arguments of %s command to retry it: %s", cmd.Cmd, err)
+		}
+	}
+
+	return list, nil
+}
+
+// newRetryRequest returns a copy of req with the arguments in args.
+func newRetryRequest(req *Request, args [][]interface{}) *Request {
+	r := *req
+	r.Cmds = make([]Command, len(req.Cmds))
+
+	for i, cmd := range req.Cmds {
+		r.Cmds[i] = Command{Cmd: cmd.Cmd}
+
+		if cmd.Args != nil {
+			r.Cmds[i].Args = List(args[i]...)
+		}
+	}
+
+	return &r
+}
+
+// sent reports whether the request which failed with err may have been sent to
+// the server, only errors dialing the server guarantee that it was not.
+func sent(err error) bool {
+	e, ok := err.(*net.OpError)
+	return !ok || e.Op != "dial"
+}
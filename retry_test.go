@@ -0,0 +1,232 @@
+package redis_test
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+
+	"github.com/dolab/objconv/resp"
+
+	"github.com/dolab/redis-go"
+	"github.com/dolab/redis-go/redistest"
+)
+
+func TestRetryTransport(t *testing.T) {
+	const addr = "127.0.0.1:6379"
+
+	var (
+		dialErr    = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+		requestErr = &net.OpError{Op: "request", Net: "redis", Err: errors.New("connection reset by peer")}
+		timeoutErr = &net.OpError{Op: "read", Net: "tcp", Err: &timeoutError{}}
+	)
+
+	// the fake transport fails n times with err, then replies OK
+	failing := func(n int, err error, calls *int) redis.RoundTripper {
+		return roundTripperFunc(func(req *redis.Request) (*redis.Response, error) {
+			req.Close()
+
+			if *calls++; *calls <= n {
+				return nil, err
+			}
+
+			return &redis.Response{Args: redis.List("OK")}, nil
+		})
+	}
+
+	t.Run("retries connection errors", func(t *testing.T) {
+		it := assert.New(t)
+
+		var calls int
+
+		tr := &redis.RetryTransport{Transport: failing(2, requestErr, &calls), MinBackoff: time.Millisecond}
+
+		res, err := tr.RoundTrip(redis.NewRequest(addr, "GET", redis.List("key")))
+		if it.Nil(err) {
+			it.Nil(res.Args.Close())
+		}
+		it.Equal(3, calls)
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		it := assert.New(t)
+
+		var calls int
+
+		tr := &redis.RetryTransport{Transport: failing(5, requestErr, &calls), MaxAttempts: 2, MinBackoff: time.Millisecond}
+
+		_, err := tr.RoundTrip(redis.NewRequest(addr, "GET", redis.List("key")))
+		it.Equal(requestErr, err)
+		it.Equal(2, calls)
+	})
+
+	t.Run("never retries non-idempotent commands once sent", func(t *testing.T) {
+		it := assert.New(t)
+
+		var calls int
+
+		tr := &redis.RetryTransport{Transport: failing(1, requestErr, &calls), MinBackoff: time.Millisecond}
+
+		_, err := tr.RoundTrip(redis.NewRequest(addr, "INCR", redis.List("counter")))
+		it.Equal(requestErr, err)
+		it.Equal(1, calls)
+
+		calls = 0
+		tr.Transport = failing(1, dialErr, &calls)
+
+		res, err := tr.RoundTrip(redis.NewRequest(addr, "LPUSH", redis.List("list", 1)))
+		if it.Nil(err) {
+			it.Nil(res.Args.Close())
+		}
+		it.Equal(2, calls)
+	})
+
+	t.Run("never retries unknown commands once sent", func(t *testing.T) {
+		it := assert.New(t)
+
+		var calls int
+
+		tr := &redis.RetryTransport{Transport: failing(1, timeoutErr, &calls), MinBackoff: time.Millisecond}
+
+		_, err := tr.RoundTrip(redis.NewRequest(addr, "FCALL", redis.List("fn", 1, "key")))
+		it.Equal(timeoutErr, err)
+		it.Equal(1, calls)
+
+		calls = 0
+		tr.Transport = failing(1, timeoutErr, &calls)
+
+		res, err := tr.RoundTrip(redis.NewRequest(addr, "SET", redis.List("key", "value")))
+		if it.Nil(err) {
+			it.Nil(res.Args.Close())
+		}
+		it.Equal(2, calls)
+	})
+
+	t.Run("never retries conditional writes once executed", func(t *testing.T) {
+		it := assert.New(t)
+
+		var (
+			keys  = map[string]string{}
+			calls int
+		)
+
+		// the fake transport executes SET commands, then fails the first time
+		// as if the connection was lost before the reply was received
+		tr := &redis.RetryTransport{
+			Transport: roundTripperFunc(func(req *redis.Request) (*redis.Response, error) {
+				var key, value, option string
+
+				req.Cmds[0].ParseArgs(&key, &value, &option)
+
+				_, exists := keys[key]
+				if !exists || option != "NX" {
+					keys[key] = value
+				}
+
+				if calls++; calls == 1 {
+					return nil, requestErr
+				}
+
+				if exists && option == "NX" {
+					return &redis.Response{Args: redis.List(nil)}, nil
+				}
+				return &redis.Response{Args: redis.List("OK")}, nil
+			}),
+			MinBackoff: time.Millisecond,
+		}
+
+		_, err := tr.RoundTrip(redis.NewRequest(addr, "SET", redis.List("lock", "token", "NX", "PX", 30000)))
+		it.Equal(requestErr, err)
+		it.Equal(1, calls)
+
+		// the lock is held even though the request failed
+		it.Equal("token", keys["lock"])
+
+		calls = 0
+
+		res, err := tr.RoundTrip(redis.NewRequest(addr, "SET", redis.List("key", "value", "PX", 30000)))
+		if it.Nil(err) {
+			it.Nil(res.Args.Close())
+		}
+		it.Equal(2, calls)
+	})
+
+	t.Run("follows the retry policy", func(t *testing.T) {
+		it := assert.New(t)
+
+		var calls int
+
+		tr := &redis.RetryTransport{
+			Transport:  failing(1, requestErr, &calls),
+			Policy:     redis.RetryTimeouts | redis.RetryLoading,
+			MinBackoff: time.Millisecond,
+		}
+
+		_, err := tr.RoundTrip(redis.NewRequest(addr, "GET", redis.List("key")))
+		it.Equal(requestErr, err)
+		it.Equal(1, calls)
+	})
+
+	t.Run("retries LOADING errors with the same arguments", func(t *testing.T) {
+		it := assert.New(t)
+
+		var calls int64
+
+		srv, serverAddr := redistest.FakeServer(redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+			var (
+				key   string
+				value int
+			)
+
+			if err := r.Cmds[0].ParseArgs(&key, &value); err != nil {
+				w.Write(err)
+				return
+			}
+
+			if atomic.AddInt64(&calls, 1) <= 2 {
+				w.Write(resp.NewError("LOADING Redis is loading the dataset in memory"))
+				return
+			}
+
+			w.Write(key + "=" + string(rune('0'+value)))
+		}))
+		defer srv.Close()
+
+		transport := &redis.Transport{}
+		defer transport.CloseIdleConnections()
+
+		tr := &redis.RetryTransport{Transport: transport, MinBackoff: time.Millisecond}
+
+		res, err := tr.RoundTrip(redis.NewRequest(serverAddr, "LPUSH", redis.List("key", 7)))
+		if it.Nil(err) {
+			var s string
+
+			it.Nil(redis.ParseArgs(res.Args, &s))
+			it.Equal("key=7", s)
+		}
+		it.EqualValues(3, atomic.LoadInt64(&calls))
+
+		// the error is returned once the attempts are exhausted
+		atomic.StoreInt64(&calls, 0)
+		tr.MaxAttempts = 2
+
+		res, err = tr.RoundTrip(redis.NewRequest(serverAddr, "SET", redis.List("key", 1)))
+		if it.Nil(err) {
+			e, ok := res.Args.Close().(*resp.Error)
+			if it.True(ok) {
+				it.Equal("LOADING", e.Type())
+			}
+		}
+		it.EqualValues(2, atomic.LoadInt64(&calls))
+	})
+}
+
+// timeoutError is a net.Error reporting a timeout.
+type timeoutError struct{}
+
+func (*timeoutError) Error() string   { return "i/o timeout" }
+func (*timeoutError) Timeout() bool   { return true }
+func (*timeoutError) Temporary() bool { return true }
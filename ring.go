@@ -26,19 +26,43 @@ type ringNode struct {
 type hashRing []ringNode
 
 func NewHashRing(endpoints ...ServerEndpoint) ServerRing {
+	return newHashRing(endpoints, func(endpoint ServerEndpoint) string { return endpoint.Addr })
+}
+
+// newNamedHashRing is like NewHashRing but distributes keys by endpoint names,
+// so keys remain mapped to the same endpoints when their addresses change.
+func newNamedHashRing(endpoints ...ServerEndpoint) ServerRing {
+	return newHashRing(endpoints, func(endpoint ServerEndpoint) string { return endpoint.Name })
+}
+
+func newHashRing(endpoints []ServerEndpoint, key func(ServerEndpoint) string) ServerRing {
 	if len(endpoints) == 0 {
 		return nil
 	}
 
-	ring := make(hashRing, 0, maxRingReplication*len(endpoints))
+	size := 0
+	for _, endpoint := range endpoints {
+		size += maxRingReplication * endpoint.weight()
+	}
+
+	ring := make(hashRing, 0, size)
 
 	for _, endpoint := range endpoints {
-		h := jody.HashString64(endpoint.Addr)
+		h := jody.HashString64(key(endpoint))
+
+		// endpoints are replicated in proportion to their weight, the hashes
+		// of the first nodes don't depend on it so keys remain mapped to the
+		// same endpoints when weights are added to a ring
+		for i, n := 0, maxRingReplication*endpoint.weight(); i != n; i++ {
+			p := jody.AddUint64(h, uint64(i))
+
+			if i >= maxRingReplication {
+				p = jody.AddUint64(h, jody.HashUint64(uint64(i)))
+			}
 
-		for i := 0; i != maxRingReplication; i++ {
 			ring = append(ring, ringNode{
 				endpoint: endpoint,
-				hash:     consistentHash(jody.AddUint64(h, uint64(i))),
+				hash:     consistentHash(p),
 			})
 		}
 	}
@@ -60,6 +84,48 @@ func (r hashRing) LookupServer(key string) ServerEndpoint {
 	return r[i].endpoint
 }
 
+// ListServers satisfies the ServerLister interface, endpoints are ordered by
+// address.
+func (r hashRing) ListServers() []ServerEndpoint {
+	endpoints := make([]ServerEndpoint, 0, len(r)/maxRingReplication)
+	seen := make(map[string]struct{}, cap(endpoints))
+
+	for _, node := range r {
+		if _, ok := seen[node.endpoint.Addr]; ok {
+			continue
+		}
+
+		seen[node.endpoint.Addr] = struct{}{}
+		endpoints = append(endpoints, node.endpoint)
+	}
+
+	sort.Slice(endpoints, func(i int, j int) bool {
+		return endpoints[i].Addr < endpoints[j].Addr
+	})
+
+	return endpoints
+}
+
+// filter returns the ring of the nodes of r whose endpoints are in endpoints.
+// The hashes of the nodes are kept, so only the keys of the endpoints removed
+// from the ring are distributed to other endpoints.
+func (r hashRing) filter(endpoints []ServerEndpoint) hashRing {
+	addrs := make(map[string]struct{}, len(endpoints))
+	for _, endpoint := range endpoints {
+		addrs[endpoint.Addr] = struct{}{}
+	}
+
+	ring := make(hashRing, 0, len(endpoints)*maxRingReplication)
+
+	for _, node := range r {
+		if _, ok := addrs[node.endpoint.Addr]; ok {
+			ring = append(ring, node)
+		}
+	}
+
+	return ring
+}
+
 func (r hashRing) Len() int {
 	return len(r)
 }
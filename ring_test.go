@@ -94,6 +94,73 @@ func difference(dist1, dist2 map[string]string) map[string]struct{} {
 	return diff
 }
 
+func TestHashRingWeight(t *testing.T) {
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = strconv.Itoa(rand.Int())
+	}
+
+	share := func(weight int) int {
+		ring := NewHashRing(
+			ServerEndpoint{Addr: "127.0.0.1:1000"},
+			ServerEndpoint{Addr: "127.0.0.1:1001", Weight: weight},
+		)
+
+		if n := len(ring.(ServerLister).ListServers()); n != 2 {
+			t.Errorf("the ring should list 2 servers but lists %d", n)
+		}
+
+		count := 0
+
+		for _, addr := range distribute(ring, keys...) {
+			if addr == "127.0.0.1:1001" {
+				count++
+			}
+		}
+
+		return (100 * count) / len(keys)
+	}
+
+	share1, share3 := share(1), share(3)
+
+	if share3 <= share1 || share3 < 50 {
+		t.Errorf("the server of weight 3 should receive more keys than of weight 1 (%d%% <= %d%%)", share3, share1)
+	} else {
+		t.Logf("the server received ~%d%% of the keys with weight 1 and ~%d%% with weight 3", share1, share3)
+	}
+}
+
+func TestHashRingFilter(t *testing.T) {
+	endpoints := []ServerEndpoint{
+		{Name: "shard-0", Addr: "127.0.0.1:1000"},
+		{Name: "shard-1", Addr: "127.0.0.1:1001"},
+		{Name: "shard-2", Addr: "127.0.0.1:1002"},
+	}
+
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = strconv.Itoa(rand.Int())
+	}
+
+	ring := newNamedHashRing(endpoints...).(hashRing)
+	filtered := ring.filter(endpoints[:2])
+
+	if n := len(filtered.ListServers()); n != 2 {
+		t.Errorf("the filtered ring should list 2 servers but lists %d", n)
+	}
+
+	dist := distribute(ring, keys...)
+
+	for key, addr := range distribute(filtered, keys...) {
+		switch {
+		case addr == endpoints[2].Addr:
+			t.Errorf("key %s was distributed to the filtered out server", key)
+		case dist[key] != endpoints[2].Addr && dist[key] != addr:
+			t.Errorf("key %s was moved from %s to %s", key, dist[key], addr)
+		}
+	}
+}
+
 func BenchmarkHashRing(b *testing.B) {
 	ring := NewHashRing(
 		ServerEndpoint{Addr: "127.0.0.1:1000"},
@@ -0,0 +1,173 @@
+package redis
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+// ScanOptions represents the options of the SCAN family of commands.
+type ScanOptions struct {
+	// Match filters the elements with a glob-style pattern, all elements are
+	// returned if empty.
+	Match string
+
+	// Count is a hint of the number of elements returned by the server for
+	// each iteration, the server default is used if zero.
+	Count int
+
+	// Type filters the keys by type of value, it is only supported by SCAN.
+	Type string
+}
+
+// ScanIter is an iterator over the elements returned by the SCAN, HSCAN, SSCAN
+// and ZSCAN commands, it hides the cursor bookkeeping by fetching the next page
+// of elements from the server when the current one has been consumed.
+//
+// For HSCAN and ZSCAN the elements are produced as a flat sequence of field and
+// value (or member and score) pairs.
+//
+// Instances of ScanIter are not safe for concurrent use by multiple goroutines.
+type ScanIter struct {
+	ctx    context.Context
+	client *Client
+	cmd    string
+	key    string
+	opts   ScanOptions
+	cursor string
+	page   byteArgs
+	done   bool
+	err    error
+	once   sync.Once
+}
+
+// Scan returns an iterator over the keys of the redis server at the client's
+// address.
+//
+// When the client is connected to a ReverseProxy the iterator walks through the
+// keys of every upstream server in turn.
+func (c *Client) Scan(ctx context.Context, opts ScanOptions) *ScanIter {
+	return newScanIter(ctx, c, "SCAN", "", opts)
+}
+
+// HScan returns an iterator over the fields and values of the hash at key.
+func (c *Client) HScan(ctx context.Context, key string, opts ScanOptions) *ScanIter {
+	return newScanIter(ctx, c, "HSCAN", key, opts)
+}
+
+// SScan returns an iterator over the members of the set at key.
+func (c *Client) SScan(ctx context.Context, key string, opts ScanOptions) *ScanIter {
+	return newScanIter(ctx, c, "SSCAN", key, opts)
+}
+
+// ZScan returns an iterator over the members and scores of the sorted set at
+// key.
+func (c *Client) ZScan(ctx context.Context, key string, opts ScanOptions) *ScanIter {
+	return newScanIter(ctx, c, "ZSCAN", key, opts)
+}
+
+func newScanIter(ctx context.Context, c *Client, cmd string, key string, opts ScanOptions) *ScanIter {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return &ScanIter{
+		ctx:    ctx,
+		client: c,
+		cmd:    cmd,
+		key:    key,
+		opts:   opts,
+		cursor: "0",
+	}
+}
+
+// Next reads the next element into dst, which must be a pointer, fetching the
+// next page of elements from the server if needed.
+//
+// The method returns false when all elements have been read, when an error
+// occurred, or when the iterator's context was canceled. The error is returned
+// by the Close method.
+func (it *ScanIter) Next(dst interface{}) bool {
+	for it.page.Len() == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+
+		it.fetch()
+	}
+
+	if !it.page.Next(dst) {
+		it.err = it.page.err
+		return false
+	}
+
+	return true
+}
+
+// Close closes the iterator, returning any error that occurred while reading
+// the elements.
+func (it *ScanIter) Close() error {
+	it.once.Do(func() {
+		it.page.Close()
+		it.done = true
+	})
+	return it.err
+}
+
+func (it *ScanIter) fetch() {
+	if it.err = it.ctx.Err(); it.err != nil {
+		return
+	}
+
+	args := make([]interface{}, 0, 8)
+
+	if len(it.key) != 0 {
+		args = append(args, it.key)
+	}
+
+	args = append(args, it.cursor)
+
+	if len(it.opts.Match) != 0 {
+		args = append(args, "MATCH", it.opts.Match)
+	}
+
+	if it.opts.Count != 0 {
+		args = append(args, "COUNT", it.opts.Count)
+	}
+
+	if len(it.opts.Type) != 0 && it.cmd == "SCAN" {
+		args = append(args, "TYPE", it.opts.Type)
+	}
+
+	var (
+		cursor string
+		items  [][]byte
+	)
+
+	if it.err = ParseArgs(it.client.Query(it.ctx, it.cmd, args...), &cursor, &items); it.err != nil {
+		return
+	}
+
+	it.cursor = cursor
+	it.page = byteArgs{args: items}
+	it.done = cursor == "0"
+}
+
+// parseScanCursor splits the cursor of a SCAN command sent to a ReverseProxy
+// into the index of the upstream server and the cursor of that server.
+func parseScanCursor(cursor uint64, n int) (index int, upstream uint64) {
+	return int(cursor % uint64(n)), cursor / uint64(n)
+}
+
+// formatScanCursor is the inverse of parseScanCursor, it returns "0" when all
+// the servers have been iterated.
+func formatScanCursor(index int, upstream uint64, n int) string {
+	if upstream == 0 {
+		if index++; index == n {
+			return "0"
+		}
+		return strconv.Itoa(index)
+	}
+
+	return strconv.FormatUint(upstream*uint64(n)+uint64(index), 10)
+}
@@ -0,0 +1,201 @@
+package redis_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dolab/objconv/resp"
+	"github.com/golib/assert"
+
+	"github.com/dolab/redis-go"
+	"github.com/dolab/redis-go/redistest"
+)
+
+func TestClient_Scan(t *testing.T) {
+	it := assert.New(t)
+
+	keys := []string{"a:1", "a:2", "b:1", "a:3", "b:2"}
+
+	srv, addr := redistest.FakeServer(scanHandler(keys, 2))
+	defer srv.Close()
+
+	tr := &redis.Transport{}
+	defer tr.CloseIdleConnections()
+
+	client := &redis.Client{Addr: addr, Transport: tr, Timeout: time.Second}
+
+	iter := client.Scan(context.Background(), redis.ScanOptions{Match: "a:*", Count: 2})
+
+	var (
+		key   string
+		found []string
+	)
+	for iter.Next(&key) {
+		found = append(found, key)
+	}
+
+	if it.Nil(iter.Close()) {
+		it.Equal([]string{"a:1", "a:2", "a:3"}, found)
+	}
+}
+
+func TestClient_HScan(t *testing.T) {
+	it := assert.New(t)
+
+	srv, addr := redistest.FakeServer(scanHandler([]string{"f1", "v1", "f2", "v2"}, 2))
+	defer srv.Close()
+
+	tr := &redis.Transport{}
+	defer tr.CloseIdleConnections()
+
+	client := &redis.Client{Addr: addr, Transport: tr, Timeout: time.Second}
+
+	iter := client.HScan(context.Background(), "hash", redis.ScanOptions{})
+
+	var (
+		field, value string
+		found        = map[string]string{}
+	)
+	for iter.Next(&field) && iter.Next(&value) {
+		found[field] = value
+	}
+
+	if it.Nil(iter.Close()) {
+		it.Equal(map[string]string{"f1": "v1", "f2": "v2"}, found)
+	}
+}
+
+func TestClient_ScanCanceled(t *testing.T) {
+	it := assert.New(t)
+
+	srv, addr := redistest.FakeServer(scanHandler([]string{"a", "b", "c"}, 1))
+	defer srv.Close()
+
+	tr := &redis.Transport{}
+	defer tr.CloseIdleConnections()
+
+	client := &redis.Client{Addr: addr, Transport: tr, Timeout: time.Second}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	iter := client.Scan(ctx, redis.ScanOptions{})
+
+	var key string
+	it.True(iter.Next(&key))
+
+	cancel()
+
+	it.False(iter.Next(&key))
+	it.Equal(context.Canceled, iter.Close())
+}
+
+func TestReverseProxy_Scan(t *testing.T) {
+	it := assert.New(t)
+
+	var (
+		servers  redis.ServerList
+		expected []string
+	)
+
+	for i := 0; i != 3; i++ {
+		keys := make([]string, 5)
+		for j := range keys {
+			keys[j] = fmt.Sprintf("server-%d:key-%d", i, j)
+		}
+		expected = append(expected, keys...)
+
+		srv, addr := redistest.FakeServer(scanHandler(keys, 2))
+		defer srv.Close()
+
+		servers = append(servers, redis.ServerEndpoint{Name: strconv.Itoa(i), Addr: addr})
+	}
+
+	tr := &redis.Transport{}
+	defer tr.CloseIdleConnections()
+
+	proxy, addr := redistest.FakeServer(&redis.ReverseProxy{
+		Transport: tr,
+		Registry:  servers,
+		ErrorLog:  log.New(os.Stderr, "[Proxy Scan] ==> ", 0),
+	})
+	defer proxy.Close()
+
+	client := &redis.Client{Addr: addr, Transport: tr, Timeout: time.Second}
+
+	iter := client.Scan(context.Background(), redis.ScanOptions{})
+
+	var (
+		key   string
+		found []string
+	)
+	for iter.Next(&key) {
+		found = append(found, key)
+	}
+
+	if it.Nil(iter.Close()) {
+		sort.Strings(found)
+		it.Equal(expected, found)
+	}
+}
+
+// scanHandler returns a handler which serves the SCAN family of commands over
+// a fixed list of elements, returning pages of size elements.
+func scanHandler(elements []string, size int) redis.Handler {
+	return redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+		var (
+			cmd    = r.Cmds[0]
+			cursor int
+			match  = "*"
+			opt    string
+			val    string
+		)
+
+		if cmd.Cmd != "SCAN" {
+			var key string
+			cmd.Args.Next(&key)
+		}
+
+		if !cmd.Args.Next(&cursor) {
+			w.Write(resp.NewError("ERR invalid cursor"))
+			return
+		}
+
+		for cmd.Args.Next(&opt) && cmd.Args.Next(&val) {
+			if opt == "MATCH" {
+				match = val
+			}
+		}
+
+		if err := cmd.Args.Close(); err != nil {
+			w.Write(err)
+			return
+		}
+
+		end := cursor + size
+		if end > len(elements) {
+			end = len(elements)
+		}
+
+		page := []string{}
+		for _, elem := range elements[cursor:end] {
+			if ok, _ := path.Match(match, elem); ok {
+				page = append(page, elem)
+			}
+		}
+
+		if cursor += size; cursor >= len(elements) {
+			cursor = 0
+		}
+
+		w.WriteStream(2)
+		w.Write(strconv.Itoa(cursor))
+		w.Write(page)
+	})
+}
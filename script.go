@@ -0,0 +1,130 @@
+package redis
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+
+	"github.com/dolab/objconv/resp"
+)
+
+// Script represents a Lua script which is executed by the redis server.
+//
+// The SHA1 digest of the script is computed locally when the script is created,
+// so the Run method can use EVALSHA to avoid sending the script body on every
+// call, transparently falling back to EVAL when the server doesn't have the
+// script in its cache yet.
+//
+// Scripts are safe for concurrent use by multiple goroutines.
+type Script struct {
+	src  string
+	hash string
+}
+
+// NewScript returns a new Script which executes the given Lua source code.
+func NewScript(src string) *Script {
+	sum := sha1.Sum([]byte(src))
+
+	return &Script{
+		src:  src,
+		hash: hex.EncodeToString(sum[:]),
+	}
+}
+
+// Hash returns the hex encoded SHA1 digest of the script source.
+func (s *Script) Hash() string {
+	return s.hash
+}
+
+// Source returns the Lua source code of the script.
+func (s *Script) Source() string {
+	return s.src
+}
+
+// Load loads the script into the scripts cache of the redis server at the
+// client's address with SCRIPT LOAD.
+func (s *Script) Load(ctx context.Context, c *Client) error {
+	return ParseArgs(scriptClient(c).Query(ctx, "SCRIPT", "LOAD", s.src), nil)
+}
+
+// Exists returns true if the script exists in the scripts cache of the redis
+// server at the client's address.
+func (s *Script) Exists(ctx context.Context, c *Client) (ok bool, err error) {
+	args := scriptClient(c).Query(ctx, "SCRIPT", "EXISTS", s.hash)
+
+	var found int
+	if args.Next(&found) {
+		ok = found != 0
+	}
+
+	err = args.Close()
+	return
+}
+
+// Eval executes the script with EVAL, always sending the script source to the
+// redis server.
+//
+// The keys and args are passed separately so the KEYS and ARGV tables of the
+// script are populated, and so proxies can route the request on the keys.
+func (s *Script) Eval(ctx context.Context, c *Client, keys []string, args ...interface{}) Args {
+	return scriptClient(c).Query(ctx, "EVAL", s.args(s.src, keys, args)...)
+}
+
+// EvalSha executes the script with EVALSHA, the method returns a NOSCRIPT error
+// if the script doesn't exist in the scripts cache of the redis server.
+func (s *Script) EvalSha(ctx context.Context, c *Client, keys []string, args ...interface{}) Args {
+	return scriptClient(c).Query(ctx, "EVALSHA", s.args(s.hash, keys, args)...)
+}
+
+// Run executes the script with EVALSHA, falling back to EVAL if the redis server
+// responds with a NOSCRIPT error. EVAL caches the script on the server, so only
+// the first call made to a server pays the cost of sending the script source.
+//
+// Any error occurring while running the script will be returned by the
+// Args.Close method of the returned value.
+func (s *Script) Run(ctx context.Context, c *Client, keys []string, args ...interface{}) Args {
+	c = scriptClient(c)
+
+	res, err := c.Do(&Request{
+		Addr:    c.addr(),
+		Cmds:    []Command{{Cmd: "EVALSHA", Args: List(s.args(s.hash, keys, args)...)}},
+		Context: ctx,
+	})
+	if err != nil {
+		return newArgsError(err)
+	}
+
+	if !res.IsRespError() {
+		return res.Args
+	}
+
+	if err = res.Args.Close(); !isNoScriptError(err) {
+		return newArgsError(err)
+	}
+
+	return s.Eval(ctx, c, keys, args...)
+}
+
+func (s *Script) args(script string, keys []string, args []interface{}) []interface{} {
+	list := make([]interface{}, 0, 2+len(keys)+len(args))
+	list = append(list, script, len(keys))
+
+	for _, key := range keys {
+		list = append(list, key)
+	}
+
+	return append(list, args...)
+}
+
+func scriptClient(c *Client) *Client {
+	if c == nil {
+		c = DefaultClient
+	}
+	return c
+}
+
+func isNoScriptError(err error) bool {
+	e, ok := err.(*resp.Error)
+	return ok && strings.HasPrefix(e.Error(), "NOSCRIPT")
+}
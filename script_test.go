@@ -0,0 +1,92 @@
+package redis_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dolab/objconv/resp"
+	"github.com/golib/assert"
+
+	"github.com/dolab/redis-go"
+	"github.com/dolab/redis-go/redistest"
+)
+
+func TestScript(t *testing.T) {
+	it := assert.New(t)
+
+	script := redis.NewScript("return redis.call('GET', KEYS[1])")
+	it.Equal("d3c21d0c2b9ca22f82737626a27bcaf5d288f99f", script.Hash())
+
+	var (
+		mutex   sync.Mutex
+		scripts = map[string]string{}
+		calls   []string
+	)
+
+	srv, addr := redistest.FakeServer(redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		cmd := r.Cmds[0]
+		calls = append(calls, cmd.Cmd)
+
+		var (
+			src, key string
+			numkeys  int
+		)
+
+		switch cmd.Cmd {
+		case "SCRIPT":
+			var sub string
+			cmd.ParseArgs(&sub, &src)
+
+			scripts[redis.NewScript(src).Hash()] = src
+			w.Write(redis.NewScript(src).Hash())
+
+		case "EVAL":
+			cmd.ParseArgs(&src, &numkeys, &key)
+
+			scripts[redis.NewScript(src).Hash()] = src
+			w.Write(key)
+
+		case "EVALSHA":
+			cmd.ParseArgs(&src, &numkeys, &key)
+
+			if _, ok := scripts[src]; !ok {
+				w.Write(resp.NewError("NOSCRIPT No matching script. Please use EVAL."))
+				return
+			}
+			w.Write(key)
+
+		default:
+			w.Write(resp.NewError("ERR unknown command"))
+		}
+	}))
+	defer srv.Close()
+
+	tr := &redis.Transport{}
+	defer tr.CloseIdleConnections()
+
+	client := &redis.Client{Addr: addr, Transport: tr, Timeout: time.Second}
+
+	for i := 0; i != 2; i++ {
+		value, err := redis.String(script.Run(context.Background(), client, []string{"hello"}, "world"))
+		if it.Nil(err) {
+			it.Equal("hello", value)
+		}
+	}
+
+	mutex.Lock()
+	it.Equal([]string{"EVALSHA", "EVAL", "EVALSHA"}, calls)
+	mutex.Unlock()
+
+	other := redis.NewScript("return 1")
+	if it.Nil(other.Load(context.Background(), client)) {
+		value, err := redis.String(other.EvalSha(context.Background(), client, []string{"key"}))
+		if it.Nil(err) {
+			it.Equal("key", value)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNoSentinel is returned by SentinelRegistry when none of the sentinels
+	// could tell the address of a master.
+	ErrNoSentinel = errors.New("redis: no sentinel could be reached")
+)
+
+// sentinelSwitchMaster is the channel that sentinels publish failovers to.
+const sentinelSwitchMaster = "+switch-master"
+
+// A SentinelRegistry is a ServerRegistry which discovers the addresses of redis
+// masters from Redis Sentinel. Each master is a shard of the ring returned by
+// LookupServers, keys are distributed by master names so they remain mapped to
+// the same shards when masters fail over.
+//
+// The registry subscribes to the failover events published by the sentinels,
+// and queries the sentinels on an interval in case some events were missed.
+type SentinelRegistry struct {
+	// Sentinels are the addresses of the sentinels, which are queried in turn
+	// until one of them answers.
+	Sentinels []string
+
+	// Masters are the names of the masters monitored by the sentinels.
+	Masters []string
+
+	// Replicas configures whether the replicas of the masters are discovered
+	// as well, they are then exposed in the Replicas field of endpoints.
+	Replicas bool
+
+	// Transport is used to query the sentinels, if nil DefaultTransport is
+	// used. The transport must be a *Transport to subscribe to failover
+	// events, otherwise the registry only queries the sentinels on interval.
+	Transport RoundTripper
+
+	// Timeout is the time limit of queries to the sentinels, if zero 1 second
+	// is used.
+	Timeout time.Duration
+
+	// RefreshInterval is the interval at which the sentinels are queried, if
+	// zero 30 seconds is used.
+	RefreshInterval time.Duration
+
+	// ErrorLog specifies an optional logger for errors querying sentinels and
+	// failovers. If nil, logging goes to os.Stderr via the log package's
+	// standard logger.
+	ErrorLog Logger
+
+	cache  RegistryCache
+	mutex  sync.Mutex
+	sub    *SubConn
+	closed bool
+}
+
+// LookupServers satisfies the ServerRegistry interface.
+func (r *SentinelRegistry) LookupServers(ctx context.Context) (ServerRing, error) {
+	ring, err := r.cache.Lookup(ctx, func(ctx context.Context) {
+		r.refresh(ctx)
+		go r.watch(ctx)
+		refreshEvery(ctx, r.refreshInterval(), r.refresh)
+	})
+
+	if ring == nil && err == nil {
+		err = fmt.Errorf("redis: no masters were found by sentinels %s", strings.Join(r.Sentinels, ", "))
+	}
+
+	return ring, err
+}
+
+// Close stops watching the sentinels.
+func (r *SentinelRegistry) Close() error {
+	r.cache.Close()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.closed = true
+
+	if r.sub != nil {
+		r.sub.Close()
+	}
+
+	return nil
+}
+
+// refresh queries the sentinels for the addresses of all masters and rebuilds
+// the ring. The previous ring is kept when no sentinel could be reached.
+func (r *SentinelRegistry) refresh(ctx context.Context) {
+	endpoints := make([]ServerEndpoint, 0, len(r.Masters))
+
+	for _, name := range r.Masters {
+		endpoint, err := r.discover(ctx, name)
+		if err != nil {
+			logError(r.ErrorLog, err)
+
+			r.cache.Fail(err)
+			return
+		}
+
+		endpoints = append(endpoints, endpoint)
+	}
+
+	r.update(endpoints)
+}
+
+// update replaces the ring of the registry with one distributing keys to
+// endpoints.
+func (r *SentinelRegistry) update(endpoints []ServerEndpoint) {
+	r.cache.Update(newNamedHashRing(endpoints...))
+}
+
+// discover returns the endpoint of the master called name, as told by the first
+// sentinel that answers.
+func (r *SentinelRegistry) discover(ctx context.Context, name string) (ServerEndpoint, error) {
+	err := ErrNoSentinel
+
+	for _, sentinel := range r.Sentinels {
+		var endpoint ServerEndpoint
+
+		if endpoint, err = r.discoverFrom(ctx, sentinel, name); err == nil {
+			return endpoint, nil
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return ServerEndpoint{}, fmt.Errorf("redis: looking up master %s from sentinels: %s", name, err)
+}
+
+func (r *SentinelRegistry) discoverFrom(ctx context.Context, sentinel string, name string) (ServerEndpoint, error) {
+	client := &Client{
+		Addr:      sentinel,
+		Transport: r.Transport,
+		Timeout:   r.timeout(),
+	}
+
+	var host, port string
+
+	if err := ParseArgs(client.Query(ctx, "SENTINEL", "get-master-addr-by-name", name), &host, &port); err != nil {
+		return ServerEndpoint{}, err
+	}
+
+	if len(host) == 0 {
+		return ServerEndpoint{}, fmt.Errorf("sentinel %s doesn't monitor master %s", sentinel, name)
+	}
+
+	endpoint := ServerEndpoint{
+		Name: name,
+		Addr: net.JoinHostPort(host, port),
+	}
+
+	if r.Replicas {
+		replicas, err := r.discoverReplicas(client.Query(ctx, "SENTINEL", "replicas", name))
+		if err != nil {
+			return ServerEndpoint{}, err
+		}
+
+		endpoint.Replicas = replicas
+	}
+
+	return endpoint, nil
+}
+
+// discoverReplicas reads the addresses of the replicas which are up from the
+// response to a SENTINEL REPLICAS command, each replica is described by a list
+// of field names and values.
+func (r *SentinelRegistry) discoverReplicas(args Args) ([]string, error) {
+	var (
+		replicas []string
+		v        interface{}
+	)
+
+	for args.Next(&v) {
+		fields, _ := v.([]interface{})
+		v = nil
+
+		info := make(map[string]string, len(fields)/2)
+
+		for i := 0; i+1 < len(fields); i += 2 {
+			info[sentinelString(fields[i])] = sentinelString(fields[i+1])
+		}
+
+		if len(info["ip"]) == 0 || sentinelDown(info["flags"]) {
+			continue
+		}
+
+		replicas = append(replicas, net.JoinHostPort(info["ip"], info["port"]))
+	}
+
+	return replicas, args.Close()
+}
+
+// watch subscribes to the failover events of the sentinels, and updates the
+// ring when masters are switched.
+func (r *SentinelRegistry) watch(ctx context.Context) {
+	transport := r.Transport
+	if transport == nil {
+		transport = DefaultTransport
+	}
+
+	t, ok := transport.(*Transport)
+	if !ok || len(r.Sentinels) == 0 {
+		return
+	}
+
+	for i := 0; ctx.Err() == nil; i++ {
+		sentinel := r.Sentinels[i%len(r.Sentinels)]
+
+		sub, err := t.Subscribe(ctx, "tcp", sentinel, sentinelSwitchMaster)
+		if err != nil {
+			logError(r.ErrorLog, fmt.Errorf("redis: subscribing to failovers of sentinel %s: %s", sentinel, err))
+
+			select {
+			case <-time.After(r.timeout()):
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		r.mutex.Lock()
+		closed := r.closed
+		r.sub = sub
+		r.mutex.Unlock()
+
+		if closed {
+			sub.Close()
+			return
+		}
+
+		// events may have been missed while no sentinel was subscribed to
+		if i != 0 {
+			r.refresh(ctx)
+		}
+
+		for {
+			channel, message, err := sub.ReadMessage()
+			if err != nil {
+				break
+			}
+
+			if channel == sentinelSwitchMaster {
+				r.switchMaster(string(message))
+			}
+		}
+
+		sub.Close()
+	}
+}
+
+// switchMaster applies a +switch-master event, its message is formatted as
+// "<master name> <old ip> <old port> <new ip> <new port>".
+func (r *SentinelRegistry) switchMaster(message string) {
+	fields := strings.Fields(message)
+	if len(fields) != 5 {
+		return
+	}
+
+	name, addr := fields[0], net.JoinHostPort(fields[3], fields[4])
+
+	lister, ok := r.cache.current().(ServerLister)
+	if !ok {
+		return
+	}
+
+	endpoints := lister.ListServers()
+	switched := false
+
+	for i, endpoint := range endpoints {
+		if endpoint.Name == name && endpoint.Addr != addr {
+			// the replicas changed as well, they are discovered again by
+			// the next refresh
+			endpoints[i] = ServerEndpoint{Name: name, Addr: addr}
+			switched = true
+		}
+	}
+
+	if switched {
+		logError(r.ErrorLog, fmt.Errorf("redis: master %s switched to %s", name, addr))
+		r.update(endpoints)
+	}
+}
+
+func (r *SentinelRegistry) timeout() time.Duration {
+	if r.Timeout > 0 {
+		return r.Timeout
+	}
+	return 1 * time.Second
+}
+
+func (r *SentinelRegistry) refreshInterval() time.Duration {
+	if r.RefreshInterval > 0 {
+		return r.RefreshInterval
+	}
+	return 30 * time.Second
+}
+
+func sentinelString(v interface{}) string {
+	switch s := v.(type) {
+	case []byte:
+		return string(s)
+	case string:
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// sentinelDown reports whether the flags of a replica mean that it's down.
+func sentinelDown(flags string) bool {
+	for _, flag := range strings.Split(flags, ",") {
+		switch flag {
+		case "s_down", "o_down", "disconnected":
+			return true
+		}
+	}
+	return false
+}
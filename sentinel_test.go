@@ -0,0 +1,201 @@
+package redis_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+
+	"github.com/dolab/redis-go"
+	"github.com/dolab/redis-go/redistest"
+)
+
+// fakeSentinel is a redis server answering the SENTINEL commands used by
+// SentinelRegistry, and publishing failovers to its subscribers.
+type fakeSentinel struct {
+	mutex    sync.Mutex
+	masters  map[string]string
+	replicas map[string][][]string
+	subs     chan net.Conn
+}
+
+func (s *fakeSentinel) ServeRedis(w redis.ResponseWriter, r *redis.Request) {
+	cmd := r.Cmds[0]
+
+	switch strings.ToUpper(cmd.Cmd) {
+	case "SENTINEL":
+		var sub, name string
+
+		if err := cmd.ParseArgs(&sub, &name); err != nil {
+			w.Write(err)
+			return
+		}
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		switch strings.ToLower(sub) {
+		case "get-master-addr-by-name":
+			addr, ok := s.masters[name]
+			if !ok {
+				w.Write(nil)
+				return
+			}
+
+			host, port, _ := net.SplitHostPort(addr)
+
+			w.WriteStream(2)
+			w.Write(host)
+			w.Write(port)
+
+		case "replicas":
+			w.WriteStream(len(s.replicas[name]))
+
+			for _, fields := range s.replicas[name] {
+				w.Write(fields)
+			}
+		}
+
+	case "SUBSCRIBE":
+		r.Close()
+
+		conn, rw, err := w.(redis.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+
+		rw.WriteString("*3\r\n$9\r\nsubscribe\r\n$14\r\n+switch-master\r\n:1\r\n")
+		rw.Flush()
+
+		s.subs <- conn
+
+	default:
+		r.Close()
+		w.Write(fmt.Errorf("ERR unknown command '%s'", cmd.Cmd))
+	}
+}
+
+// failover switches the master called name to addr and publishes the event to
+// the subscriber conn.
+func (s *fakeSentinel) failover(conn net.Conn, name string, addr string) {
+	s.mutex.Lock()
+	old := s.masters[name]
+	s.masters[name] = addr
+	s.replicas[name] = nil
+	s.mutex.Unlock()
+
+	oldHost, oldPort, _ := net.SplitHostPort(old)
+	newHost, newPort, _ := net.SplitHostPort(addr)
+
+	msg := strings.Join([]string{name, oldHost, oldPort, newHost, newPort}, " ")
+	fmt.Fprintf(conn, "*3\r\n$7\r\nmessage\r\n$14\r\n+switch-master\r\n$%d\r\n%s\r\n", len(msg), msg)
+}
+
+func TestSentinelRegistry(t *testing.T) {
+	sentinel := &fakeSentinel{
+		masters: map[string]string{
+			"cache":    "127.0.0.1:7001",
+			"sessions": "127.0.0.1:7101",
+		},
+		replicas: map[string][][]string{
+			"cache": {
+				{"name", "127.0.0.1:7002", "ip", "127.0.0.1", "port", "7002", "flags", "slave"},
+				{"name", "127.0.0.1:7003", "ip", "127.0.0.1", "port", "7003", "flags", "s_down,slave"},
+			},
+		},
+		subs: make(chan net.Conn, 1),
+	}
+
+	srv, sentinelAddr := redistest.FakeServer(sentinel)
+	defer srv.Close()
+
+	// reserve an address nothing listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	downAddr := l.Addr().String()
+	l.Close()
+
+	t.Run("discovers masters and follows failovers", func(t *testing.T) {
+		it := assert.New(t)
+
+		registry := &redis.SentinelRegistry{
+			Sentinels: []string{downAddr, sentinelAddr},
+			Masters:   []string{"cache", "sessions"},
+			Replicas:  true,
+			Transport: &redis.Transport{},
+			ErrorLog:  log.New(ioutil.Discard, "", 0),
+		}
+		defer registry.Close()
+
+		ring, err := registry.LookupServers(context.Background())
+		if !it.Nil(err) {
+			return
+		}
+
+		it.Equal([]redis.ServerEndpoint{
+			{Name: "cache", Addr: "127.0.0.1:7001", Replicas: []string{"127.0.0.1:7002"}},
+			{Name: "sessions", Addr: "127.0.0.1:7101"},
+		}, ring.(redis.ServerLister).ListServers())
+
+		keys := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+		shards := make(map[string]string, len(keys))
+
+		for _, key := range keys {
+			shards[key] = ring.LookupServer(key).Name
+		}
+
+		var sub net.Conn
+
+		select {
+		case sub = <-sentinel.subs:
+			defer sub.Close()
+		case <-time.After(3 * time.Second):
+			t.Fatal("the registry did not subscribe to failovers")
+		}
+
+		sentinel.failover(sub, "cache", "127.0.0.1:7002")
+
+		deadline := time.Now().Add(3 * time.Second)
+
+		for time.Now().Before(deadline) {
+			if ring, err = registry.LookupServers(context.Background()); err == nil && ring.(redis.ServerLister).ListServers()[0].Addr != "127.0.0.1:7001" {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		it.Equal([]redis.ServerEndpoint{
+			{Name: "cache", Addr: "127.0.0.1:7002"},
+			{Name: "sessions", Addr: "127.0.0.1:7101"},
+		}, ring.(redis.ServerLister).ListServers())
+
+		// keys remain mapped to the same shards
+		for _, key := range keys {
+			it.Equal(shards[key], ring.LookupServer(key).Name)
+		}
+	})
+
+	t.Run("fails when masters are unknown", func(t *testing.T) {
+		it := assert.New(t)
+
+		registry := &redis.SentinelRegistry{
+			Sentinels: []string{sentinelAddr},
+			Masters:   []string{"unknown"},
+			Transport: &redis.Transport{},
+			ErrorLog:  log.New(ioutil.Discard, "", 0),
+		}
+		defer registry.Close()
+
+		_, err := registry.LookupServers(context.Background())
+		it.NotNil(err)
+	})
+}
@@ -2,11 +2,15 @@ package redis
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dolab/objconv"
@@ -15,6 +19,17 @@ import (
 	"github.com/dolab/redis-go/metrics"
 )
 
+var (
+	// ErrShuttingDown is an error that servers can reply to clients while
+	// shutting down (see Server.ShutdownError). Clients retry commands failing
+	// with LOADING errors, possibly on another server.
+	ErrShuttingDown = resp.NewError("LOADING server is shutting down")
+
+	// errExecAbort is replied to EXEC commands of transactions that commands
+	// failed to be queued in.
+	errExecAbort = resp.NewError("EXECABORT Transaction discarded because of previous errors.")
+)
+
 // A ResponseWriter interface is used by a Redis handler to construct an Redis
 // response.
 //
@@ -31,6 +46,9 @@ type ResponseWriter interface {
 	//
 	// Write may not be called more than once, or more than n times, when n is
 	// passed to a previous call to WriteStream.
+	//
+	// Passing a *BulkReader streams a bulk string to the client without
+	// loading it in memory.
 	Write(v interface{}) error
 }
 
@@ -70,8 +88,87 @@ type Server struct {
 	// Handler invoked to handle Redis requests, must not be nil.
 	Handler Handler
 
-	// features of command retry and pipeline
-	EnableRetry    bool
+	// Databases is the number of logical databases that clients can select
+	// with the SELECT command, 16 if zero.
+	Databases int
+
+	// Commands is the table of commands served by the Handler, it is reported
+	// to clients by COMMAND commands along with the commands built into the
+	// server (PING, SELECT, SWAPDB, CLIENT, INFO, COMMAND, MONITOR and
+	// SLOWLOG).
+	//
+	// While MONITOR clients are connected, the server loads the arguments of
+	// commands in memory before passing them to the handler so they can be
+	// reported. Arguments longer than 128 bytes are truncated in the lines
+	// sent to MONITOR clients.
+	Commands []CommandInfo
+
+	// SlowLogThreshold is the duration above which requests are recorded in
+	// the slow log of the server, zero disables the slow log.
+	//
+	// Enabling the slow log makes the server load the arguments of commands
+	// in memory before passing them to the handler.
+	SlowLogThreshold time.Duration
+
+	// SlowLogMaxLen is the maximum number of entries retained in the slow log,
+	// the oldest ones are discarded first. If zero, 128 entries are retained.
+	SlowLogMaxLen int
+
+	// ProxyProtocol makes the server read a PROXY protocol header, of version 1
+	// or 2, at the beginning of every connection it accepts. The addresses of
+	// the client and server that the header reports are then used as the
+	// addresses of the connection, which is closed if the header is missing
+	// or invalid.
+	//
+	// It must only be enabled when the server is reached through a proxy or
+	// load balancer sending PROXY protocol headers, otherwise clients can
+	// claim any address.
+	ProxyProtocol bool
+
+	// MaxClients is the maximum number of connections opened to the server at
+	// once, zero means no limit. Connections exceeding the limit are replied
+	// ErrMaxClients and closed.
+	MaxClients int
+
+	// OutputBufferLimits configures the output buffer limits of each class of
+	// client connections, clients exceeding them are disconnected. There are
+	// no limits for classes missing from the map, in which case the commands
+	// streamed to MONITOR clients that don't read them fast enough are
+	// dropped.
+	OutputBufferLimits map[ClientClass]OutputBufferLimit
+
+	// MaxConnsPerIP is the maximum number of connections accepted from a
+	// single client IP address, zero means no limit. Connections exceeding the
+	// limit are replied ErrMaxClientsPerIP and closed.
+	MaxConnsPerIP int
+
+	// MaxInflight is the maximum number of requests that the server serves at
+	// once, zero means no limit. Pipelined commands served concurrently and
+	// transactions each count as one request.
+	MaxInflight int
+
+	// ConnRateLimit, IPRateLimit and UserRateLimit limit the rate of commands
+	// served per connection, per client IP address and per user that clients
+	// authenticated as (see Session.SetUser). Commands exceeding any of them
+	// are replied ErrRateLimited.
+	ConnRateLimit RateLimit
+	IPRateLimit   RateLimit
+	UserRateLimit RateLimit
+
+	// LimitWait is the maximum duration that commands exceeding the rate
+	// limits or MaxInflight wait for their turn before being rejected, zero
+	// rejects them right away.
+	LimitWait time.Duration
+
+	// EnableRetry makes the server retain the arguments of commands so they
+	// can be retried.
+	EnableRetry bool
+
+	// EnablePipeline has no effect, commands pipelined by clients are always
+	// served in order, and replies are flushed once all the commands already
+	// received on the connection have been served.
+	//
+	// Deprecated: pipelining is always enabled.
 	EnablePipeline bool
 
 	// ReadTimeout is the maximum duration for reading the entire request,
@@ -87,6 +184,12 @@ type Server struct {
 	// zero, there is no timeout.
 	IdleTimeout time.Duration
 
+	// ShutdownError is replied to the commands that clients have already sent
+	// on connections which are closed because the server is shutting down,
+	// like ErrShuttingDown. If nil, the connections are closed without replying to
+	// those commands.
+	ShutdownError error
+
 	// ErrorLog specifies an optional logger for errors accepting connections
 	// and unexpected behavior from handlers. If nil, logging goes to os.Stderr
 	// via the log package's standard logger.
@@ -95,7 +198,18 @@ type Server struct {
 	mutex       sync.Mutex
 	serveOnce   sync.Once
 	listeners   map[net.Listener]struct{}
-	connections map[*Conn]struct{}
+	connections map[*Conn]*Session
+	lastID      int64
+	dbs         atomic.Value // []int, replaced by SWAPDB commands
+	swapMutex   sync.Mutex
+	monitors    map[*monitor]struct{}
+	nmonitors   int32
+	stats       *serverStats
+	slowlog     slowLog
+	limiter     limiter
+	pausedUntil time.Time
+	unpaused    chan struct{}
+	onShutdown  []func()
 	context     context.Context
 	shutdown    context.CancelFunc
 }
@@ -111,6 +225,9 @@ func (s *Server) WithMetrics(opts metrics.Options) *Server {
 
 // ListenAndServe listens on the network address s.Addr and then calls Serve to
 // handle requests on incoming connections. If s.Addr is blank, ":6379" is used.
+// The socket is inherited from the parent process if it passed one listening on
+// the same address (see Listen).
+//
 // ListenAndServe always returns a non-nil error.
 func (s *Server) ListenAndServe() error {
 	addr := s.Addr
@@ -123,7 +240,7 @@ func (s *Server) ListenAndServe() error {
 		network = "tcp"
 	}
 
-	l, err := net.Listen(network, address)
+	l, err := Listen(network, address)
 	if err != nil {
 		return err
 	}
@@ -156,16 +273,30 @@ func (s *Server) Close() error {
 }
 
 // Shutdown gracefully shuts down the server without interrupting any active
-// connections. Shutdown works by first closing all open listeners, then closing
-// all idle connections, and then waiting indefinitely for connections to return
-// to idle and then shut down. If the provided context expires before the shutdown
-// is complete, then the context's error is returned.
+// connections. Shutdown works by first calling the functions registered with
+// RegisterOnShutdown, then closing all open listeners, then closing all idle
+// connections, and then waiting indefinitely for connections to return to idle
+// and then shut down. If the provided context expires before the shutdown is
+// complete, then the context's error is returned.
+//
+// Connections serving requests are closed once their current request was
+// served, blocked requests are canceled. If ShutdownError is set, it is
+// replied to the commands that clients have already sent on those
+// connections.
 func (s *Server) Shutdown(ctx context.Context) error {
 	const (
 		minPollInterval = 10 * time.Millisecond
 		maxPollInterval = 500 * time.Millisecond
 	)
 
+	s.mutex.Lock()
+	onShutdown := s.onShutdown
+	s.mutex.Unlock()
+
+	for _, f := range onShutdown {
+		f()
+	}
+
 	s.mutex.Lock()
 
 	if s.shutdown != nil {
@@ -178,14 +309,39 @@ func (s *Server) Shutdown(ctx context.Context) error {
 
 	s.mutex.Unlock()
 
-	for i := 0; s.numberOfActors() != 0; i++ {
+	for i := 0; ; i++ {
+		s.closeIdleConnections()
+
+		if s.numberOfActors() == 0 {
+			return ctx.Err()
+		}
+
 		select {
 		case <-ctx.Done():
+			return ctx.Err()
 		case <-time.After(backoff(i, minPollInterval, maxPollInterval)):
 		}
 	}
+}
 
-	return ctx.Err()
+// RegisterOnShutdown registers a function to call on Shutdown, before the
+// listeners are closed. The functions are called in the order they were
+// registered, which can be used to deregister the server from a service
+// discovery system before its connections are drained.
+func (s *Server) RegisterOnShutdown(f func()) {
+	s.mutex.Lock()
+	s.onShutdown = append(s.onShutdown, f)
+	s.mutex.Unlock()
+}
+
+func (s *Server) closeIdleConnections() {
+	s.mutex.Lock()
+
+	for _, sess := range s.connections {
+		sess.closeIfIdle()
+	}
+
+	s.mutex.Unlock()
 }
 
 // Serve accepts incoming connections on the Listener l, creating a new service
@@ -210,6 +366,30 @@ func (s *Server) Serve(l net.Listener) error {
 		readTimeout:  s.ReadTimeout,
 		writeTimeout: s.WriteTimeout,
 		retryable:    s.EnableRetry,
+		shutdownErr:  s.ShutdownError,
+		stats:        s.serverStats(),
+
+		slowLogThreshold: s.SlowLogThreshold,
+		slowLogMaxLen:    s.SlowLogMaxLen,
+
+		proxyProtocol:      s.ProxyProtocol,
+		maxClients:         s.MaxClients,
+		outputBufferLimits: s.OutputBufferLimits,
+
+		maxConnsPerIP: s.MaxConnsPerIP,
+		maxInflight:   s.MaxInflight,
+		connRateLimit: s.ConnRateLimit,
+		ipRateLimit:   s.IPRateLimit,
+		userRateLimit: s.UserRateLimit,
+		limitWait:     s.LimitWait,
+	}
+
+	if config.slowLogMaxLen == 0 {
+		config.slowLogMaxLen = defaultSlowLogMaxLen
+	}
+
+	if h, ok := s.Handler.(ConcurrentHandler); ok {
+		config.concurrent = h.ServeConcurrently()
 	}
 
 	if config.idleTimeout == 0 {
@@ -247,22 +427,84 @@ func (s *Server) Serve(l net.Listener) error {
 		}
 
 		attempt = 0
-		c := NewServerConn(conn)
-		s.trackConnection(c)
-		go s.serveConnection(s.context, c, config)
+
+		if config.proxyProtocol {
+			// the header is read by the connection's goroutine so slow clients
+			// don't prevent other connections from being accepted
+			go s.serveProxyProtocol(conn, config)
+			continue
+		}
+
+		if sess := s.acceptConnection(conn, config); sess != nil {
+			go s.serveConnection(sess, config)
+		}
 	}
 }
 
-func (s *Server) serveConnection(ctx context.Context, c *Conn, config serverConfig) {
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	defer c.Close()
-	defer s.untrackConnection(c)
+// serveProxyProtocol reads the PROXY protocol header that conn starts with,
+// then serves the connection as if it was accepted from the client that the
+// header reports.
+func (s *Server) serveProxyProtocol(conn net.Conn, config serverConfig) {
+	pc, err := readProxyProtocol(conn, config.readTimeout)
+	if err != nil {
+		conn.Close()
+		s.log(fmt.Errorf("%s: %s", conn.RemoteAddr(), err))
+		return
+	}
+
+	select {
+	default:
+	case <-s.context.Done():
+		conn.Close()
+		return
+	}
+
+	if sess := s.acceptConnection(pc, config); sess != nil {
+		s.serveConnection(sess, config)
+	}
+}
+
+// acceptConnection returns the session of conn, or nil if the connection was
+// rejected because of the limits of the server.
+func (s *Server) acceptConnection(conn net.Conn, config serverConfig) *Session {
+	if config.maxConnsPerIP > 0 && !s.limiter.acquireConn(clientIP(conn.RemoteAddr().String()), config.maxConnsPerIP) {
+		go s.rejectConnection(conn, ErrMaxClientsPerIP, "max_clients_per_ip", config)
+		return nil
+	}
+
+	c := NewServerConn(&statsConn{Conn: conn, stats: config.stats})
+
+	sess := s.trackConnection(c, config.maxClients)
+	if sess == nil {
+		if config.maxConnsPerIP > 0 {
+			s.limiter.releaseConn(clientIP(conn.RemoteAddr().String()))
+		}
+
+		go s.rejectConnection(conn, ErrMaxClients, "max_clients", config)
+		return nil
+	}
+
+	sess.limits, sess.stats = config.outputBufferLimits, config.stats
 
+	atomic.AddInt64(&config.stats.connections, 1)
+	return sess
+}
+
+func (s *Server) serveConnection(sess *Session, config serverConfig) {
 	var (
-		remoteAddr = c.RemoteAddr().String()
-		localAddr  = c.LocalAddr().String()
+		c          = sess.conn
+		ctx        = sess.context
+		remoteAddr = sess.addr
+		localAddr  = sess.laddr
 	)
+	defer sess.cancel()
+	defer c.Close()
+	defer s.untrackConnection(c)
+
+	if config.maxConnsPerIP > 0 {
+		defer s.limiter.releaseConn(clientIP(remoteAddr))
+	}
+
 	gometrics.IncConnection(remoteAddr, localAddr)
 	defer gometrics.DecConnection(remoteAddr, localAddr)
 
@@ -270,6 +512,11 @@ func (s *Server) serveConnection(ctx context.Context, c *Conn, config serverConf
 		select {
 		default:
 		case <-ctx.Done():
+			if s.context.Err() != nil && config.shutdownErr != nil {
+				if err := s.refuseCommands(sess, config.shutdownErr, config); err != nil {
+					s.log(err)
+				}
+			}
 			return
 		}
 
@@ -277,111 +524,438 @@ func (s *Server) serveConnection(ctx context.Context, c *Conn, config serverConf
 			return
 		}
 
+		if !sess.setBusy(true) {
+			return
+		}
+
 		c.setTimeout(config.readTimeout)
 		cmdReader := c.ReadCommands(config.retryable)
 
-		cmds := make([]Command, 0, 4)
-		cmds = append(cmds, Command{})
+		cmds := make([]Command, 1, 4)
 
 		if !cmdReader.Read(&cmds[0]) {
 			s.log(cmdReader.Close())
 			return
 		}
 
-		// for transaction
-		if cmds[0].Cmd == "MULTI" {
-			// Transactions have to be loaded in memory because the server has to
-			// interleave responses between each command it receives.
-			for {
-				lastIndex := len(cmds)
-				if lastIndex > 0 {
-					cmds[lastIndex-1].loadByteArgs()
-				}
+		if strings.EqualFold(cmds[0].Cmd, "MONITOR") {
+			cmds[0].Args.Close()
 
-				if lastIndex == 0 {
-					c.WriteArgs(List("OK")) // response to MULTI
-				} else {
-					c.WriteArgs(List("QUEUED"))
-				}
+			if err := cmdReader.Close(); err != nil {
+				s.log(err)
+				return
+			}
+
+			if err := s.serveMonitor(sess, config); err != nil {
+				s.log(err)
+			}
+			return
+		}
 
-				cmds = append(cmds, Command{})
-				cmd := &cmds[lastIndex]
+		if config.concurrent && cmds[0].Cmd != "MULTI" && !changesSession(cmds[0].Cmd) && c.buffered() != 0 {
+			var err error
 
-				if !cmdReader.Read(cmd) {
-					cmds = cmds[:lastIndex]
-					break
-				}
+			if cmdReader, err = s.servePipeline(sess, cmdReader, &cmds[0], config); err != nil {
+				s.log(err)
+				return
+			}
+		}
+
+		if cmdReader != nil {
+			if err := s.serveCommandReader(sess, cmdReader, cmds, config); err != nil {
+				s.log(err)
+				return
 			}
+		}
+
+		// Replies are buffered as long as the client has pipelined more
+		// commands, so they all get sent with a single flush.
+		if c.buffered() == 0 {
+			if err := c.Flush(); err != nil {
+				s.log(err)
+				return
+			}
+
+			sess.flushedOutput()
+			sess.setBusy(false)
+		}
+	}
+}
+
+// refuseCommands replies err to the commands that the client of sess has
+// already sent, then flushes the replies.
+func (s *Server) refuseCommands(sess *Session, err error, config serverConfig) error {
+	c := sess.conn
+
+	for c.buffered() != 0 {
+		c.setTimeout(config.readTimeout)
+		r := c.ReadCommands(false)
+
+		var cmd Command
+
+		for r.Read(&cmd) {
+			cmd.Args.Close()
+
+			if werr := writeError(&responseWriter{conn: c, timeout: config.writeTimeout}, err); werr != nil {
+				r.Close()
+				return werr
+			}
+		}
+
+		if rerr := r.Close(); rerr != nil {
+			return rerr
+		}
+	}
+
+	return c.Flush()
+}
+
+// serveCommandReader serves the command read from r into cmds[0], or the
+// transaction it opens if it's a MULTI command, then closes r.
+func (s *Server) serveCommandReader(sess *Session, r *CommandReader, cmds []Command, config serverConfig) error {
+	c := sess.conn
 
-			lastIndex := len(cmds) - 1
+	// for transaction
+	if cmds[0].Cmd == "MULTI" {
+		sess.setMulti(true)
+		defer sess.setMulti(false)
 
-			if cmds[lastIndex].Cmd == "DISCARD" {
-				cmds[lastIndex].Args.Close()
+		aborted := false
 
-				if err := c.WriteArgs(List("OK")); err != nil {
-					return
+		// Transactions have to be loaded in memory because the server has to
+		// interleave responses between each command it receives.
+		for {
+			lastIndex := len(cmds)
+
+			var err error
+
+			switch {
+			case lastIndex == 1:
+				cmds[0].Args.Close()
+				err = writeStatus(c, "OK", config) // response to MULTI
+
+			case changesDB(cmds[lastIndex-1].Cmd):
+				// all commands of a transaction are served by the database
+				// selected when it starts, which can't be changed within
+				cmds[lastIndex-1].Args.Close()
+				err = writeReply(c, errorf("ERR %s is not allowed in transactions", strings.ToUpper(cmds[lastIndex-1].Cmd)), config)
+				cmds, lastIndex, aborted = cmds[:lastIndex-1], lastIndex-1, true
+
+			default:
+				cmds[lastIndex-1].loadByteArgs()
+				err = writeStatus(c, "QUEUED", config)
+			}
+
+			if err != nil {
+				r.Close()
+				return err
+			}
+
+			cmds = append(cmds, Command{})
+
+			if !r.Read(&cmds[lastIndex]) {
+				if err := r.Close(); err != nil {
+					return err
 				}
+				return io.ErrUnexpectedEOF
+			}
+
+			if cmd := cmds[lastIndex].Cmd; cmd == "EXEC" || cmd == "DISCARD" {
+				break
+			}
+		}
+
+		lastIndex := len(cmds) - 1
+		cmds[lastIndex].Args.Close()
 
-				continue // discarded transactions are not passed to the handler
+		if cmds[lastIndex].Cmd == "DISCARD" {
+			if err := r.Close(); err != nil {
+				return err
 			}
 
-			cmds = cmds[1:lastIndex]
+			// discarded transactions are not passed to the handler
+			return writeStatus(c, "OK", config)
 		}
 
-		if err := s.serveCommands(c, remoteAddr, cmds, config); err != nil {
-			s.log(err)
-			return
+		if aborted {
+			if err := r.Close(); err != nil {
+				return err
+			}
+
+			return writeReply(c, errExecAbort, config)
 		}
 
-		if err := cmdReader.Close(); err != nil {
-			s.log(err)
-			return
+		cmds = cmds[1:lastIndex]
+	}
+
+	res := &responseWriter{
+		conn:    c,
+		sess:    sess,
+		timeout: config.writeTimeout,
+	}
+
+	if err := s.serveCommands(res, sess, cmds, config); err != nil {
+		r.Close()
+		return err
+	}
+
+	// the handler may not have checked the error of writing a reply which
+	// exceeded the output buffer limits of the client
+	if res.out != nil {
+		if err := res.out.failed(); err != nil {
+			r.Close()
+			return err
+		}
+	}
+
+	return r.Close()
+}
+
+// writeStatus writes the simple string status to c, the reply is flushed along
+// with the next ones if the client has pipelined more commands.
+func writeStatus(c *Conn, status string, config serverConfig) error {
+	return writeReply(c, status, config)
+}
+
+// writeReply writes the value v to c as the reply of a command, like
+// writeStatus does.
+func writeReply(c *Conn, v interface{}, config serverConfig) error {
+	res := &responseWriter{
+		conn:    c,
+		timeout: config.writeTimeout,
+	}
+
+	if err := res.Write(v); err != nil {
+		return err
+	}
+
+	return res.Flush()
+}
+
+// writeError writes err as the reply of the request that res is writing the
+// response of, in place of the reply of the handler.
+func writeError(res *responseWriter, err error) error {
+	if err := res.Write(err); err != nil {
+		return err
+	}
+	return res.finish()
+}
+
+// servePipeline reads ahead the commands that were already received on c and
+// serves them concurrently, starting with cmd which was read from r. Replies are
+// written in the order of the commands, but are not flushed.
+//
+// Reading stops when no more bytes are buffered on the connection, or when a
+// transaction or a command changing the session is found in the pipeline. In
+// the latter cases the method returns the reader of the command, which is set
+// to cmd, so it gets served after the commands that preceded it and before the
+// ones that follow it.
+func (s *Server) servePipeline(sess *Session, r *CommandReader, cmd *Command, config serverConfig) (*CommandReader, error) {
+	var (
+		c    = sess.conn
+		cmds []Command
+		rerr error
+	)
+
+	for {
+		cmd.loadByteArgs()
+
+		if rerr = r.Close(); rerr != nil {
+			break
+		}
+
+		cmds = append(cmds, *cmd)
+		r = nil
+
+		if len(cmds) == maxPipelineCommands || c.buffered() == 0 {
+			break
+		}
+
+		c.setTimeout(config.readTimeout)
+		r = c.ReadCommands(config.retryable)
+
+		if *cmd = (Command{}); !r.Read(cmd) {
+			rerr = r.Close()
+			r = nil
+
+			if rerr == nil {
+				rerr = io.ErrUnexpectedEOF
+			}
+			break
+		}
+
+		if cmd.Cmd == "MULTI" || changesSession(cmd.Cmd) {
+			break
 		}
 	}
+
+	var (
+		wg   sync.WaitGroup
+		res  = make([]responseWriter, len(cmds))
+		errs = make([]error, len(cmds))
+		out  = sess.outputBuffer()
+	)
+
+	for i := range cmds {
+		res[i] = responseWriter{
+			conn: c,
+			sess: sess,
+			buf:  &bytes.Buffer{},
+			out:  out,
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.serveCommands(&res[i], sess, cmds[i:i+1], config)
+		}(i)
+	}
+
+	wg.Wait()
+
+	// the client is disconnected without getting any of the replies if they
+	// exceed its output buffer limits
+	if out != nil {
+		if err := out.failed(); err != nil {
+			return nil, err
+		}
+	}
+
+	c.setWriteTimeout(config.writeTimeout)
+
+	for i := range res {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+
+		if _, err := c.Write(res[i].buf.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, rerr
+}
+
+// changesDB reports whether cmd is a built-in command which changes the database
+// that the commands after it are served by.
+func changesDB(cmd string) bool {
+	switch strings.ToUpper(cmd) {
+	case "SELECT", "SWAPDB":
+		return true
+	}
+	return false
+}
+
+// changesSession reports whether cmd is a built-in command which changes the
+// state of sessions that the commands after it depend on, it must not be served
+// concurrently with them.
+func changesSession(cmd string) bool {
+	return changesDB(cmd) || strings.EqualFold(cmd, "CLIENT")
 }
 
-func (s *Server) serveCommands(c *Conn, addr string, cmds []Command, config serverConfig) (err error) {
+func (s *Server) serveCommands(res *responseWriter, sess *Session, cmds []Command, config serverConfig) (err error) {
 	var (
 		names      = make([]string, len(cmds))
-		remoteAddr = metrics.TrimPort(addr)
-		localAddr  = metrics.TrimPort(c.LocalAddr().String())
+		remoteAddr = metrics.TrimPort(sess.addr)
+		localAddr  = metrics.TrimPort(res.conn.LocalAddr().String())
 		issuedAt   = time.Now()
 	)
 	for i, cmd := range cmds {
 		names[i] = cmd.Cmd
 	}
 
+	if len(cmds) != 0 {
+		sess.touch(cmds[len(cmds)-1].Cmd)
+
+		if !strings.EqualFold(cmds[0].Cmd, "CLIENT") && s.waitUnpaused(sess.context) {
+			sess.conn.setTimeout(config.readTimeout)
+		}
+	}
+
+	release, rejected := s.admit(sess, len(cmds), config)
+	if _, ok := rejected.(*resp.Error); rejected != nil && !ok {
+		return rejected
+	}
+
 	// inc request and commands of processing
 	gometrics.IncRequest(remoteAddr, localAddr)
 	gometrics.IncCommands(remoteAddr, localAddr, names)
 
+	var (
+		db    = s.database(sess.DB())
+		lines [][]byte
+		argv  []string
+	)
+
+	if s.monitoring() && rejected == nil {
+		lines = monitorLines(issuedAt, db, sess.addr, cmds)
+	}
+
+	if config.slowLogThreshold > 0 && rejected == nil {
+		argv = slowLogArgs(cmds)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), config.readTimeout)
 
 	req := &Request{
-		Addr:    addr,
+		Addr:    sess.addr,
 		Cmds:    cmds,
+		DB:      db,
 		Context: ctx,
+		Session: sess,
 	}
 
-	res := &responseWriter{
-		conn:    c,
-		timeout: config.writeTimeout,
+	startedAt := time.Now()
+
+	if rejected != nil {
+		err = writeError(res, rejected)
+	} else {
+		err = s.serveRequest(res, req)
+		release()
 	}
 
-	err = s.serveRequest(res, req)
+	if elapsed := time.Since(startedAt); argv != nil && elapsed > config.slowLogThreshold {
+		entry := SlowLogEntry{
+			Time:     startedAt,
+			Duration: elapsed,
+			Args:     argv,
+			Addr:     sess.addr,
+			Name:     sess.Name(),
+		}
+
+		if req.Addr != sess.addr {
+			entry.Upstream = req.Addr
+		}
+
+		s.slowlog.add(entry, config.slowLogMaxLen)
+	}
 
-	// is this a pipeline?
-	reqErr := req.Close()
-	if s.EnablePipeline && err == nil && reqErr == nil {
-		pipeErr := s.servePipeline(c, addr, cmds, config)
-		if pipeErr != ErrNotPipeline {
-			err = pipeErr
+	for _, line := range lines {
+		// handlers forwarding requests set their address to the upstream
+		if req.Addr != sess.addr {
+			line = append(append(line, " -> "...), req.Addr...)
 		}
+
+		s.publish(append(line, '\r', '\n'))
+	}
+
+	// the connection must not be watched anymore when the remaining arguments
+	// of the request are read
+	if b, ok := req.Context.(*blockContext); ok {
+		sess.release(b)
+	}
+
+	if reqErr := req.Close(); err == nil {
+		err = reqErr
 	}
 
 	// cancel context
 	cancel()
 
+	if rejected == nil {
+		config.stats.addCommands(len(cmds))
+	}
+
 	// for request duration
 	gometrics.ObserveRequest(remoteAddr, localAddr, issuedAt)
 
@@ -394,34 +968,6 @@ func (s *Server) serveCommands(c *Conn, addr string, cmds []Command, config serv
 	return
 }
 
-func (s *Server) servePipeline(c *Conn, addr string, cmds []Command, config serverConfig) (err error) {
-	var (
-		pipeCmds []Command
-	)
-	for _, cmd := range cmds {
-		pipeCmd, pipeErr := cmd.pipeCommand()
-		if pipeErr != nil {
-			err = pipeErr
-			break
-		}
-
-		pipeCmds = append(pipeCmds, pipeCmd)
-	}
-
-	if err != nil {
-		return
-	}
-
-	if len(pipeCmds) > 0 {
-		// TODO: This is for temporary solution and it should refactor to pipeline way!
-		c.setTimeout(config.readTimeout)
-
-		err = s.serveCommands(c, addr, pipeCmds, config)
-	}
-
-	return
-}
-
 func (s *Server) serveRequest(res *responseWriter, req *Request) (err error) {
 	var w ResponseWriter = res
 	var (
@@ -440,12 +986,45 @@ func (s *Server) serveRequest(res *responseWriter, req *Request) (err error) {
 	}
 
 	for _, cmd := range req.Cmds {
-		switch cmd.Cmd {
+		switch strings.ToUpper(cmd.Cmd) {
 		case "PING":
 			msg := "PONG"
 			cmd.ParseArgs(&msg)
 			addPreparedResponse(i, msg)
 
+		case "INFO":
+			addPreparedResponse(i, s.serveInfo(cmd.Args))
+
+		case "COMMAND":
+			addPreparedResponse(i, s.serveCommand(cmd.Args))
+
+		case "SLOWLOG":
+			addPreparedResponse(i, s.serveSlowLog(cmd.Args))
+
+		case "SELECT":
+			addPreparedResponse(i, s.selectDB(req.Session, cmd.Args))
+
+		case "SWAPDB":
+			addPreparedResponse(i, s.swapDB(cmd.Args))
+
+		case "CLIENT":
+			var sub string
+
+			if !cmd.Args.Next(&sub) {
+				req.Cmds[i] = cmd
+				i++
+				break
+			}
+
+			if v, ok := s.serveClient(req.Session, sub, cmd.Args); ok {
+				addPreparedResponse(i, v)
+				break
+			}
+
+			cmd.Args = MultiArgs(List(sub), cmd.Args)
+			req.Cmds[i] = cmd
+			i++
+
 		default:
 			req.Cmds[i] = cmd
 			i++
@@ -467,7 +1046,7 @@ func (s *Server) serveRequest(res *responseWriter, req *Request) (err error) {
 	}
 
 	if err == nil {
-		err = res.Flush()
+		err = res.finish()
 	}
 
 	return
@@ -485,7 +1064,7 @@ func (s *Server) serveRedis(res ResponseWriter, req *Request) (err error) {
 }
 
 func (s *Server) log(err error) {
-	if err == ErrHijacked || err == ErrNotPipeline {
+	if err == ErrHijacked {
 		return
 	}
 
@@ -514,16 +1093,27 @@ func (s *Server) untrackListener(l net.Listener) {
 	s.mutex.Unlock()
 }
 
-func (s *Server) trackConnection(c *Conn) {
+// trackConnection registers the session of c, it returns nil if there are
+// already max connections opened to the server.
+func (s *Server) trackConnection(c *Conn, max int) *Session {
 	s.mutex.Lock()
 
+	if max > 0 && len(s.connections) >= max {
+		s.mutex.Unlock()
+		return nil
+	}
+
 	if s.connections == nil {
-		s.connections = map[*Conn]struct{}{}
+		s.connections = map[*Conn]*Session{}
 	}
 
-	s.connections[c] = struct{}{}
+	s.lastID++
+
+	sess := newSession(s.context, s.lastID, c)
+	s.connections[c] = sess
 
 	s.mutex.Unlock()
+	return sess
 }
 
 func (s *Server) untrackConnection(c *Conn) {
@@ -534,6 +1124,80 @@ func (s *Server) untrackConnection(c *Conn) {
 	s.mutex.Unlock()
 }
 
+// selectDB serves SELECT commands, changing the logical database selected on
+// the session.
+func (s *Server) selectDB(sess *Session, args Args) interface{} {
+	var index int
+
+	if err := ParseArgs(args, &index); err != nil {
+		return errorf("ERR value is not an integer or out of range")
+	}
+
+	if index < 0 || index >= s.databases() {
+		return errorf("ERR DB index is out of range")
+	}
+
+	sess.selectDB(index)
+	return "OK"
+}
+
+// swapDB serves SWAPDB commands, clients which selected either of the two
+// databases see the content of the other one afterward.
+func (s *Server) swapDB(args Args) interface{} {
+	var a, b int
+
+	if err := ParseArgs(args, &a, &b); err != nil {
+		return errorf("ERR invalid first or second DB index")
+	}
+
+	n := s.databases()
+
+	if a < 0 || a >= n || b < 0 || b >= n {
+		return errorf("ERR DB index is out of range")
+	}
+
+	// the databases are copied on write so commands look them up without
+	// locking
+	s.swapMutex.Lock()
+
+	dbs := make([]int, n)
+	for i := range dbs {
+		dbs[i] = i
+	}
+
+	if swapped, ok := s.dbs.Load().([]int); ok {
+		copy(dbs, swapped)
+	}
+
+	dbs[a], dbs[b] = dbs[b], dbs[a]
+	s.dbs.Store(dbs)
+
+	s.swapMutex.Unlock()
+	return "OK"
+}
+
+// database returns the index of the database that requests on the logical
+// database index are served from.
+func (s *Server) database(index int) int {
+	dbs, ok := s.dbs.Load().([]int)
+	if !ok {
+		return index
+	}
+
+	if index < len(dbs) {
+		index = dbs[index]
+	}
+
+	return index
+}
+
+func (s *Server) databases() int {
+	if s.Databases > 0 {
+		return s.Databases
+	}
+	return 16
+}
+
 func (s *Server) numberOfActors() int {
 	s.mutex.Lock()
 	n := len(s.connections) + len(s.listeners)
@@ -581,6 +1245,23 @@ type serverConfig struct {
 	readTimeout  time.Duration
 	writeTimeout time.Duration
 	retryable    bool
+	concurrent   bool
+	shutdownErr  error
+	stats        *serverStats
+
+	slowLogThreshold time.Duration
+	slowLogMaxLen    int
+
+	proxyProtocol      bool
+	maxClients         int
+	outputBufferLimits map[ClientClass]OutputBufferLimit
+
+	maxConnsPerIP int
+	maxInflight   int
+	connRateLimit RateLimit
+	ipRateLimit   RateLimit
+	userRateLimit RateLimit
+	limitWait     time.Duration
 }
 
 func backoff(attempt int, minDelay time.Duration, maxDelay time.Duration) time.Duration {
@@ -611,6 +1292,10 @@ func convertPanicToError(v interface{}) (err error) {
 
 type responseWriterType int
 
+// maxPipelineCommands is the maximum number of commands read ahead when serving
+// pipelined commands concurrently.
+const maxPipelineCommands = 1024
+
 const (
 	notype responseWriterType = iota
 	oneshot
@@ -619,6 +1304,9 @@ const (
 
 type responseWriter struct {
 	conn    *Conn
+	sess    *Session
+	buf     *bytes.Buffer // set when serving pipelined commands concurrently
+	out     *outputBuffer // accounts for the output when the client has limits
 	wtype   responseWriterType
 	remain  int
 	enc     objconv.Encoder
@@ -643,9 +1331,10 @@ func (res *responseWriter) WriteStream(n int) error {
 	}
 
 	res.waitReadyWrite()
+	res.accountOutput()
 	res.wtype = stream
 	res.remain = n
-	res.stream = *resp.NewStreamEncoder(&res.conn.wbuffer)
+	res.stream = *resp.NewStreamEncoder(res.writer())
 	return res.stream.Open(n)
 }
 
@@ -656,9 +1345,10 @@ func (res *responseWriter) Write(val interface{}) error {
 
 	if res.wtype == notype {
 		res.waitReadyWrite()
+		res.accountOutput()
 		res.wtype = oneshot
 		res.remain = 1
-		res.enc = *resp.NewEncoder(&res.conn.wbuffer)
+		res.enc = *resp.NewEncoder(res.writer())
 	}
 
 	if res.remain == 0 {
@@ -666,6 +1356,8 @@ func (res *responseWriter) Write(val interface{}) error {
 	}
 	res.remain--
 
+	val = bulkValue(val, res.writer())
+
 	if res.wtype == oneshot {
 		return res.enc.Encode(val)
 	}
@@ -674,6 +1366,30 @@ func (res *responseWriter) Write(val interface{}) error {
 }
 
 func (res *responseWriter) Flush() error {
+	if err := res.finish(); err != nil {
+		return err
+	}
+
+	// Replies to pipelined commands are flushed by the server once all the
+	// commands received on the connection have been served.
+	if res.buf != nil || res.conn.buffered() != 0 {
+		return nil
+	}
+
+	if err := res.conn.wbuffer.Flush(); err != nil {
+		return err
+	}
+
+	if res.sess != nil {
+		res.sess.flushedOutput()
+	}
+
+	return nil
+}
+
+// finish completes the response, writing OK if the handler didn't write any
+// value.
+func (res *responseWriter) finish() error {
 	if res.conn == nil {
 		return ErrHijacked
 	}
@@ -688,18 +1404,51 @@ func (res *responseWriter) Flush() error {
 		return ErrWriteCalledNotEnoughTimes
 	}
 
-	return res.conn.wbuffer.Flush()
+	return nil
+}
+
+func (res *responseWriter) writer() io.Writer {
+	var w io.Writer = &res.conn.wbuffer
+
+	if res.buf != nil {
+		w = res.buf
+	}
+
+	if res.out != nil {
+		w = &outputWriter{w: w, out: res.out}
+	}
+
+	return w
+}
+
+// accountOutput sets the outputBuffer that the response is accounted in, from
+// the class of the client when the response starts.
+func (res *responseWriter) accountOutput() {
+	if res.out == nil && res.sess != nil {
+		res.out = res.sess.outputBuffer()
+	}
 }
 
 func (res *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	if res.conn == nil {
 		return nil, nil, ErrHijacked
 	}
+	if res.buf != nil {
+		return nil, nil, ErrNotHijackable
+	}
 	nc := res.conn.conn
 	rw := &bufio.ReadWriter{
 		Reader: &res.conn.rbuffer,
 		Writer: &res.conn.wbuffer,
 	}
+
+	// the class of the client may have been changed by the handler
+	if res.sess != nil {
+		if out := res.sess.outputBuffer(); out != nil {
+			rw.Writer = bufio.NewWriter(&hijackedWriter{w: &res.conn.wbuffer, out: out})
+		}
+	}
+
 	res.conn = nil
 	return nc, rw, nil
 }
@@ -707,7 +1456,7 @@ func (res *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 // TODO: figure out here how to wait for the previous response to flush to
 // support pipeline.
 func (res *responseWriter) waitReadyWrite() {
-	if res.timeout != 0 {
+	if res.timeout != 0 && res.buf == nil {
 		res.conn.setWriteTimeout(res.timeout)
 	}
 }
@@ -2,12 +2,19 @@ package redis_test
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
+	"net/http/httptest"
 	"os"
+	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -29,6 +36,90 @@ func TestServer(t *testing.T) {
 			scenario: "server with pipeline",
 			function: testServerWithPipeline,
 		},
+		{
+			scenario: "pipelined commands are served in order and replies are sent with a single flush",
+			function: testServerPipelineSingleFlush,
+		},
+		{
+			scenario: "pipelined commands are served concurrently by concurrent handlers",
+			function: testServerPipelineConcurrent,
+		},
+		{
+			scenario: "pipelined SELECT commands apply to the commands that follow them",
+			function: testServerPipelineSelect,
+		},
+		{
+			scenario: "blocking requests are not canceled by the read timeout of the server",
+			function: testServerBlockingRequest,
+		},
+		{
+			scenario: "replies to commands pipelined before blocking requests are sent without waiting for them",
+			function: testServerBlockingRequestPipelined,
+		},
+		{
+			scenario: "blocking requests are canceled when the client disconnects",
+			function: testServerBlockingRequestDisconnect,
+		},
+		{
+			scenario: "blocking requests are unblocked by CLIENT UNBLOCK commands",
+			function: testServerClientUnblock,
+		},
+		{
+			scenario: "handlers have access to the session of client connections",
+			function: testServerSession,
+		},
+		{
+			scenario: "connections are closed by CLIENT KILL commands",
+			function: testServerClientKill,
+		},
+		{
+			scenario: "commands are not served while the server is paused by CLIENT PAUSE",
+			function: testServerClientPause,
+		},
+		{
+			scenario: "clients select logical databases with SELECT and SWAPDB",
+			function: testServerSelectDatabase,
+		},
+		{
+			scenario: "INFO replies report the statistics of the server and the sections of the handler",
+			function: testServerInfo,
+		},
+		{
+			scenario: "COMMAND replies describe the built-in commands and the command table of the server",
+			function: testServerCommand,
+		},
+		{
+			scenario: "MONITOR clients receive the commands processed by the server",
+			function: testServerMonitor,
+		},
+		{
+			scenario: "slow requests are recorded in the slow log of the server",
+			function: testServerSlowLog,
+		},
+		{
+			scenario: "connections exceeding the limit of clients per IP are rejected",
+			function: testServerMaxConnsPerIP,
+		},
+		{
+			scenario: "connections exceeding the maximum number of clients are rejected",
+			function: testServerMaxClients,
+		},
+		{
+			scenario: "clients exceeding their output buffer limits are disconnected",
+			function: testServerOutputBufferLimits,
+		},
+		{
+			scenario: "clients exceeding their output buffer limits with replies or PUB/SUB messages are disconnected",
+			function: testServerOutputBufferLimitsSequential,
+		},
+		{
+			scenario: "commands exceeding the rate limits of the server are rejected or delayed",
+			function: testServerRateLimit,
+		},
+		{
+			scenario: "requests exceeding the maximum number of in-flight requests are rejected",
+			function: testServerMaxInflight,
+		},
 		{
 			scenario: "close a server right after starting it",
 			function: testServerCloseAfterStart,
@@ -41,6 +132,10 @@ func TestServer(t *testing.T) {
 			scenario: "cancelling a graceful shutdown returns context.Canceled",
 			function: testServerCancelGracefulShutdown,
 		},
+		{
+			scenario: "gracefully shutdown closes idle connections and drains busy ones",
+			function: testServerShutdownDrain,
+		},
 		{
 			scenario: "listener errors are reported by the Serve method",
 			function: testServerServeError,
@@ -185,6 +280,83 @@ func testServerCancelGracefulShutdown(t *testing.T, ctx context.Context) {
 	}
 }
 
+func testServerShutdownDrain(t *testing.T, ctx context.Context) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served := make(chan struct{})
+
+	srv := &redis.Server{
+		Handler: redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+			if r.Cmds[0].Cmd == "SLOW" {
+				close(served)
+				time.Sleep(100 * time.Millisecond)
+			}
+
+			echoHandler(w, r)
+		}),
+		ShutdownError: redis.ErrShuttingDown,
+		ReadTimeout:   time.Second,
+	}
+	defer srv.Close()
+
+	go srv.Serve(l)
+
+	idle := dialSessionServer(t, l.Addr().String())
+	defer idle.Close()
+
+	if s, err := redis.String(sendCommand(t, idle, "ECHO", "hello")); err != nil || s != "hello" {
+		t.Fatalf("bad reply: %q (%v)", s, err)
+	}
+
+	busy := dialSessionServer(t, l.Addr().String())
+	defer busy.Close()
+
+	if err := busy.WriteCommands(
+		redis.Command{Cmd: "SLOW", Args: redis.List("slow")},
+		redis.Command{Cmd: "ECHO", Args: redis.List("hello")},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	<-served
+
+	listening := false
+
+	srv.RegisterOnShutdown(func() {
+		if conn, err := net.Dial("tcp", l.Addr().String()); err == nil {
+			listening = true
+			conn.Close()
+		}
+	})
+
+	shutdown := make(chan error, 1)
+
+	go func() { shutdown <- srv.Shutdown(ctx) }()
+
+	if _, err := redis.String(idle.ReadArgs()); err == nil {
+		t.Error("the idle connection was not closed")
+	}
+
+	if s, err := redis.String(busy.ReadArgs()); err != nil || s != "slow" {
+		t.Errorf("bad reply to the request being served: %q (%v)", s, err)
+	}
+
+	if _, err := redis.String(busy.ReadArgs()); err == nil || err.Error() != redis.ErrShuttingDown.Error() {
+		t.Errorf("bad reply to the pipelined command: %v", err)
+	}
+
+	if err := <-shutdown; err != nil {
+		t.Error(err)
+	}
+
+	if !listening {
+		t.Error("the functions registered with RegisterOnShutdown were not called before closing the listeners")
+	}
+}
+
 func testServerServeError(t *testing.T, ctx context.Context) {
 	e := &testError{temporary: false}
 	l := &testErrorListener{err: e}
@@ -469,3 +641,1322 @@ type testErrorListener struct {
 func (l *testErrorListener) Accept() (net.Conn, error) { return nil, l.err }
 func (l *testErrorListener) Addr() net.Addr            { return &testAddr{} }
 func (l *testErrorListener) Close() error              { return nil }
+
+func testServerPipelineSingleFlush(t *testing.T, ctx context.Context) {
+	testServerPipeline(t, ctx, redis.HandlerFunc(echoHandler), false)
+}
+
+func testServerPipelineConcurrent(t *testing.T, ctx context.Context) {
+	var inflight, maxInflight int32
+
+	handler := &testConcurrentHandler{
+		Handler: redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+			n := atomic.AddInt32(&inflight, 1)
+			defer atomic.AddInt32(&inflight, -1)
+
+			for {
+				max := atomic.LoadInt32(&maxInflight)
+				if n <= max || atomic.CompareAndSwapInt32(&maxInflight, max, n) {
+					break
+				}
+			}
+
+			time.Sleep(10 * time.Millisecond)
+			echoHandler(w, r)
+		}),
+	}
+
+	testServerPipeline(t, ctx, handler, true)
+
+	if atomic.LoadInt32(&maxInflight) < 2 {
+		t.Error("pipelined commands were not served concurrently")
+	}
+}
+
+func testServerPipelineSelect(t *testing.T, ctx context.Context) {
+	addr, shutdown := newSessionServer(t, &testConcurrentHandler{
+		Handler: redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+			r.Close()
+			time.Sleep(10 * time.Millisecond)
+			w.Write(r.DB)
+		}),
+	})
+	defer shutdown()
+
+	conn := dialSessionServer(t, addr)
+	defer conn.Close()
+
+	if err := conn.WriteCommands(
+		redis.Command{Cmd: "DBINDEX"},
+		redis.Command{Cmd: "SELECT", Args: redis.List(1)},
+		redis.Command{Cmd: "DBINDEX"},
+		redis.Command{Cmd: "DBINDEX"},
+		redis.Command{Cmd: "SELECT", Args: redis.List(2)},
+		redis.Command{Cmd: "DBINDEX"},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expected := range []string{"0", "OK", "1", "1", "OK", "2"} {
+		if found, err := redis.String(conn.ReadArgs()); err != nil {
+			t.Fatal(err)
+		} else if found != expected {
+			t.Errorf("bad reply to the pipelined command #%d: expected %q but found %q", i, expected, found)
+		}
+	}
+}
+
+func testServerPipeline(t *testing.T, ctx context.Context, handler redis.Handler, multi bool) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counter := &testCountingListener{Listener: l}
+
+	srv := &redis.Server{
+		Handler:      handler,
+		ReadTimeout:  time.Second,
+		WriteTimeout: time.Second,
+	}
+	defer srv.Close()
+
+	go srv.Serve(counter)
+
+	nc, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := redis.NewClientConn(nc)
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+	var (
+		cmds     []redis.Command
+		expected []string
+	)
+
+	for i := 0; i != 16; i++ {
+		cmds = append(cmds, redis.Command{Cmd: "ECHO", Args: redis.List(strconv.Itoa(i))})
+		expected = append(expected, strconv.Itoa(i))
+	}
+
+	if multi {
+		cmds = append(cmds,
+			redis.Command{Cmd: "MULTI"},
+			redis.Command{Cmd: "ECHO", Args: redis.List("tx1")},
+			redis.Command{Cmd: "ECHO", Args: redis.List("tx2")},
+			redis.Command{Cmd: "EXEC"},
+			redis.Command{Cmd: "ECHO", Args: redis.List("last")},
+		)
+	}
+
+	if err := conn.WriteCommands(cmds...); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, value := range expected {
+		if found, err := redis.String(conn.ReadArgs()); err != nil {
+			t.Fatal(err)
+		} else if found != value {
+			t.Errorf("bad reply: expected %q but found %q", value, found)
+		}
+	}
+
+	if !multi {
+		if n := atomic.LoadInt32(&counter.writes); n != 1 {
+			t.Errorf("replies were written in %d writes", n)
+		}
+		return
+	}
+
+	tx := conn.ReadTxArgs(2)
+
+	for _, value := range []string{"tx1", "tx2"} {
+		if args := tx.Next(); args == nil {
+			t.Error("missing reply to the transaction")
+		} else if found, err := redis.String(args); err != nil || found != value {
+			t.Errorf("bad transaction reply: %q (%v)", found, err)
+		}
+	}
+
+	if err := tx.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if found, err := redis.String(conn.ReadArgs()); err != nil || found != "last" {
+		t.Errorf("bad reply: %q (%v)", found, err)
+	}
+}
+
+func testServerBlockingRequest(t *testing.T, ctx context.Context) {
+	queue := make(chan string, 1)
+
+	addr, _, shutdown := newBlockingServer(t, queue)
+	defer shutdown()
+
+	conn, _ := dialBlockingServer(t, addr)
+	defer conn.Close()
+
+	time.AfterFunc(300*time.Millisecond, func() { queue <- "hello" })
+
+	var key, value string
+
+	if err := redis.ParseArgs(conn.ReadArgs(), &key, &value); err != nil {
+		t.Error(err)
+	} else if key != "queue" || value != "hello" {
+		t.Errorf("bad reply: %q %q", key, value)
+	}
+}
+
+func testServerBlockingRequestPipelined(t *testing.T, ctx context.Context) {
+	queue := make(chan string, 1)
+
+	addr, _, shutdown := newBlockingServer(t, queue)
+	defer shutdown()
+
+	conn, err := redis.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteCommands(
+		redis.Command{Cmd: "CLIENT", Args: redis.List("ID")},
+		redis.Command{Cmd: "BLPOP", Args: redis.List("queue", 0)},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	var id int64
+
+	if err := redis.ParseArgs(conn.ReadArgs(), &id); err != nil {
+		t.Fatal("the reply to the pipelined command was not sent:", err)
+	}
+
+	queue <- "hello"
+
+	var key, value string
+
+	if err := redis.ParseArgs(conn.ReadArgs(), &key, &value); err != nil {
+		t.Error(err)
+	} else if key != "queue" || value != "hello" {
+		t.Errorf("bad reply: %q %q", key, value)
+	}
+}
+
+func testServerBlockingRequestDisconnect(t *testing.T, ctx context.Context) {
+	addr, errs, shutdown := newBlockingServer(t, nil)
+	defer shutdown()
+
+	conn, _ := dialBlockingServer(t, addr)
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+
+	select {
+	case err := <-errs:
+		if err != context.Canceled {
+			t.Error("bad error:", err)
+		}
+	case <-ctx.Done():
+		t.Error("the blocking request was not canceled")
+	}
+}
+
+func testServerClientUnblock(t *testing.T, ctx context.Context) {
+	addr, errs, shutdown := newBlockingServer(t, nil)
+	defer shutdown()
+
+	tr := &redis.Transport{}
+	defer tr.CloseIdleConnections()
+
+	client := &redis.Client{Addr: addr, Transport: tr}
+
+	for _, test := range []struct {
+		reason string
+		err    error
+	}{
+		{reason: "TIMEOUT", err: context.DeadlineExceeded},
+		{reason: "ERROR", err: redis.ErrUnblocked},
+	} {
+		conn, id := dialBlockingServer(t, addr)
+		defer conn.Close()
+
+		var n int
+
+		for n == 0 {
+			if err := redis.ParseArgs(client.Query(ctx, "CLIENT", "UNBLOCK", id, test.reason), &n); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := <-errs; err != test.err {
+			t.Errorf("%s: bad error: %v", test.reason, err)
+		}
+
+		var value []byte
+
+		err := redis.ParseArgs(conn.ReadArgs(), &value)
+
+		switch test.err {
+		case redis.ErrUnblocked:
+			if e, ok := err.(*resp.Error); !ok || e.Type() != "UNBLOCKED" {
+				t.Errorf("%s: bad reply error: %v", test.reason, err)
+			}
+		default:
+			if err != nil || value != nil {
+				t.Errorf("%s: bad reply: %q (%v)", test.reason, value, err)
+			}
+		}
+
+		if err := redis.ParseArgs(client.Query(ctx, "CLIENT", "UNBLOCK", id), &n); err != nil || n != 0 {
+			t.Errorf("%s: the connection was unblocked twice: %d (%v)", test.reason, n, err)
+		}
+	}
+}
+
+// newBlockingServer starts a server with a short read timeout, serving BLPOP
+// commands from queue. The errors of the contexts of blocking requests which
+// got canceled are sent to the returned channel.
+func newBlockingServer(t *testing.T, queue <-chan string) (string, <-chan error, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 1)
+
+	srv := &redis.Server{
+		Handler: redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+			var key string
+			r.Cmds[0].ParseArgs(&key)
+
+			ctx := r.Block()
+
+			select {
+			case value := <-queue:
+				w.Write([]string{key, value})
+
+			case <-ctx.Done():
+				errs <- ctx.Err()
+
+				if ctx.Err() == redis.ErrUnblocked {
+					w.Write(ctx.Err())
+				} else {
+					w.Write(nil)
+				}
+			}
+		}),
+		ReadTimeout:  100 * time.Millisecond,
+		WriteTimeout: 100 * time.Millisecond,
+	}
+
+	go srv.Serve(l)
+
+	return l.Addr().String(), errs, func() { srv.Close() }
+}
+
+// dialBlockingServer opens a connection to addr and sends a BLPOP command, it
+// returns the connection and its id.
+func dialBlockingServer(t *testing.T, addr string) (*redis.Conn, int64) {
+	conn, err := redis.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+	var id int64
+
+	if err := conn.WriteCommands(redis.Command{Cmd: "CLIENT", Args: redis.List("ID")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := redis.ParseArgs(conn.ReadArgs(), &id); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.WriteCommands(redis.Command{Cmd: "BLPOP", Args: redis.List("queue", 0)}); err != nil {
+		t.Fatal(err)
+	}
+
+	return conn, id
+}
+
+type testUserKey struct{}
+
+func testServerSession(t *testing.T, ctx context.Context) {
+	addr, shutdown := newSessionServer(t, redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+		switch cmd := r.Cmds[0]; cmd.Cmd {
+		case "LOGIN":
+			var user string
+			cmd.ParseArgs(&user)
+
+			r.Session.SetValue(testUserKey{}, user)
+			w.Write("OK")
+
+		case "WHOAMI":
+			user, _ := r.Session.Value(testUserKey{}).(string)
+
+			w.WriteStream(3)
+			w.Write(r.Session.ID())
+			w.Write(r.Session.Name())
+			w.Write(user)
+		}
+	}))
+	defer shutdown()
+
+	conn := dialSessionServer(t, addr)
+	defer conn.Close()
+
+	var (
+		id   int64
+		name string
+		user string
+		info string
+	)
+
+	if err := redis.ParseArgs(sendCommand(t, conn, "CLIENT", "ID"), &id); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := redis.ParseArgs(sendCommand(t, conn, "CLIENT", "SETNAME", "alice"), nil); err != nil {
+		t.Error(err)
+	}
+
+	if err := redis.ParseArgs(sendCommand(t, conn, "CLIENT", "GETNAME"), &name); err != nil || name != "alice" {
+		t.Errorf("bad name: %q (%v)", name, err)
+	}
+
+	if err := redis.ParseArgs(sendCommand(t, conn, "CLIENT", "SETNAME", "a b"), nil); err == nil {
+		t.Error("client names with spaces must be rejected")
+	}
+
+	if err := redis.ParseArgs(sendCommand(t, conn, "LOGIN", "bob"), nil); err != nil {
+		t.Error(err)
+	}
+
+	var found int64
+
+	if err := redis.ParseArgs(sendCommand(t, conn, "WHOAMI"), &found, &name, &user); err != nil {
+		t.Error(err)
+	} else if found != id || name != "alice" || user != "bob" {
+		t.Errorf("bad session: id=%d name=%q user=%q", found, name, user)
+	}
+
+	if err := redis.ParseArgs(sendCommand(t, conn, "CLIENT", "INFO"), &info); err != nil {
+		t.Error(err)
+	} else if prefix := "id=" + strconv.FormatInt(id, 10) + " "; !strings.HasPrefix(info, prefix) ||
+		!strings.Contains(info, " name=alice ") || !strings.HasSuffix(info, " cmd=client\n") {
+		t.Errorf("bad client info: %q", info)
+	}
+
+	other := dialSessionServer(t, addr)
+	defer other.Close()
+
+	if err := redis.ParseArgs(sendCommand(t, other, "CLIENT", "LIST"), &info); err != nil {
+		t.Error(err)
+	} else if lines := strings.Split(strings.TrimSpace(info), "\n"); len(lines) != 2 {
+		t.Errorf("bad client list: %q", info)
+	} else if !strings.Contains(lines[0], " name=alice ") || !strings.HasSuffix(lines[1], " cmd=client") {
+		t.Errorf("bad client list: %q", info)
+	}
+}
+
+func testServerClientKill(t *testing.T, ctx context.Context) {
+	addr, shutdown := newSessionServer(t, redis.HandlerFunc(echoHandler))
+	defer shutdown()
+
+	conn := dialSessionServer(t, addr)
+	defer conn.Close()
+
+	other := dialSessionServer(t, addr)
+	defer other.Close()
+
+	var id, n int64
+
+	if err := redis.ParseArgs(sendCommand(t, conn, "CLIENT", "ID"), &id); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := redis.ParseArgs(sendCommand(t, other, "CLIENT", "KILL", "ID", id), &n); err != nil || n != 1 {
+		t.Errorf("bad number of killed clients: %d (%v)", n, err)
+	}
+
+	if err := redis.ParseArgs(sendCommand(t, other, "CLIENT", "KILL", "ID", id), &n); err != nil || n != 0 {
+		t.Errorf("bad number of killed clients: %d (%v)", n, err)
+	}
+
+	if err := conn.WriteCommands(redis.Command{Cmd: "ECHO", Args: redis.List("hello")}); err == nil {
+		if _, err = redis.String(conn.ReadArgs()); err == nil {
+			t.Error("the connection was not closed")
+		}
+	}
+}
+
+func testServerClientPause(t *testing.T, ctx context.Context) {
+	addr, shutdown := newSessionServer(t, redis.HandlerFunc(echoHandler))
+	defer shutdown()
+
+	conn := dialSessionServer(t, addr)
+	defer conn.Close()
+
+	other := dialSessionServer(t, addr)
+	defer other.Close()
+
+	if err := redis.ParseArgs(sendCommand(t, conn, "CLIENT", "PAUSE", 100), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+
+	if s, err := redis.String(sendCommand(t, conn, "ECHO", "hello")); err != nil || s != "hello" {
+		t.Errorf("bad reply: %q (%v)", s, err)
+	} else if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("the command was served %s after pausing the server", elapsed)
+	}
+
+	if err := redis.ParseArgs(sendCommand(t, conn, "CLIENT", "PAUSE", 10000), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	time.AfterFunc(50*time.Millisecond, func() {
+		sendCommand(t, other, "CLIENT", "UNPAUSE").Close()
+	})
+
+	if s, err := redis.String(sendCommand(t, conn, "ECHO", "world")); err != nil || s != "world" {
+		t.Errorf("bad reply: %q (%v)", s, err)
+	} else if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Error("the server was not unpaused")
+	}
+}
+
+func testServerSelectDatabase(t *testing.T, ctx context.Context) {
+	addr, shutdown := newSessionServer(t, redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+		r.Close()
+		w.Write(r.DB)
+	}))
+	defer shutdown()
+
+	conn := dialSessionServer(t, addr)
+	defer conn.Close()
+
+	other := dialSessionServer(t, addr)
+	defer other.Close()
+
+	database := func(conn *redis.Conn) int {
+		var db int
+
+		if err := redis.ParseArgs(sendCommand(t, conn, "DBINDEX"), &db); err != nil {
+			t.Error(err)
+		}
+
+		return db
+	}
+
+	if db := database(conn); db != 0 {
+		t.Errorf("bad default database: %d", db)
+	}
+
+	if err := redis.ParseArgs(sendCommand(t, conn, "SELECT", 3), nil); err != nil {
+		t.Error(err)
+	}
+
+	if err := redis.ParseArgs(sendCommand(t, conn, "SELECT", 16), nil); err == nil {
+		t.Error("selecting a database out of range must fail")
+	}
+
+	if db := database(conn); db != 3 {
+		t.Errorf("bad selected database: %d", db)
+	}
+
+	if err := redis.ParseArgs(sendCommand(t, other, "SWAPDB", 3, 5), nil); err != nil {
+		t.Error(err)
+	}
+
+	if db := database(conn); db != 5 {
+		t.Errorf("bad database after SWAPDB: %d", db)
+	}
+
+	if err := redis.ParseArgs(sendCommand(t, other, "SELECT", 5), nil); err != nil {
+		t.Error(err)
+	}
+
+	if db := database(other); db != 3 {
+		t.Errorf("bad database after SWAPDB: %d", db)
+	}
+
+	var info string
+
+	if err := redis.ParseArgs(sendCommand(t, conn, "CLIENT", "INFO"), &info); err != nil || !strings.Contains(info, " db=3 ") {
+		t.Errorf("bad client info: %q (%v)", info, err)
+	}
+
+	// the database can't be changed within transactions
+	if err := conn.WriteCommands(
+		redis.Command{Cmd: "MULTI"},
+		redis.Command{Cmd: "SELECT", Args: redis.List(1)},
+		redis.Command{Cmd: "DBINDEX"},
+		redis.Command{Cmd: "EXEC"},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"OK", "ERR", "QUEUED", "EXECABORT"} {
+		var status string
+
+		err := redis.ParseArgs(conn.ReadArgs(), &status)
+
+		if e, ok := err.(*resp.Error); ok {
+			status = e.Type()
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		if status != expected {
+			t.Errorf("bad reply to the transaction: expected %q but found %q", expected, status)
+		}
+	}
+
+	if db := database(conn); db != 5 {
+		t.Errorf("bad database after an aborted transaction: %d", db)
+	}
+}
+
+func testServerInfo(t *testing.T, ctx context.Context) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &redis.Server{Handler: &testInfoHandler{Handler: redis.HandlerFunc(echoHandler)}}
+	defer srv.Close()
+
+	go srv.Serve(l)
+
+	client := goredis.NewClient(&goredis.Options{Addr: l.Addr().String()})
+	defer client.Close()
+
+	for i := 0; i != 3; i++ {
+		if err := client.Echo("hello").Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info, err := client.Info().Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		"# Server\r\n",
+		"\r\nconnected_clients:1\r\n",
+		"\r\ntotal_connections_received:1\r\n",
+		"\r\ntotal_commands_processed:3\r\n",
+		"\r\n# Keyspace\r\ndb0:keys=1,expires=0\r\n",
+	} {
+		if !strings.Contains(info, line) {
+			t.Errorf("%q not found in INFO reply:\n%s", line, info)
+		}
+	}
+
+	if !regexp.MustCompile(`total_net_input_bytes:[1-9]`).MatchString(info) {
+		t.Errorf("input bytes were not counted:\n%s", info)
+	}
+
+	if info, err = client.Info("keyspace").Result(); err != nil {
+		t.Error(err)
+	} else if info != "# Keyspace\r\ndb0:keys=1,expires=0\r\n" {
+		t.Errorf("bad INFO keyspace reply: %q", info)
+	}
+}
+
+func testServerCommand(t *testing.T, ctx context.Context) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &redis.Server{
+		Handler: redis.HandlerFunc(echoHandler),
+		Commands: []redis.CommandInfo{
+			{Name: "ECHO", Arity: 2, Flags: []string{"fast"}, Summary: "Echoes the given string", Group: "connection"},
+		},
+	}
+	defer srv.Close()
+
+	go srv.Serve(l)
+
+	client := goredis.NewClient(&goredis.Options{Addr: l.Addr().String()})
+	defer client.Close()
+
+	cmds, err := client.Command().Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if echo := cmds["echo"]; echo == nil {
+		t.Error("ECHO is missing from the command table")
+	} else if echo.Arity != 2 || len(echo.Flags) != 1 || echo.Flags[0] != "fast" {
+		t.Errorf("bad description of ECHO: %+v", echo)
+	}
+
+	for _, name := range []string{"ping", "select", "client", "info", "command"} {
+		if cmds[name] == nil {
+			t.Errorf("%s is missing from the command table", name)
+		}
+	}
+
+	if n, err := client.Do("COMMAND", "COUNT").Int64(); err != nil || n != int64(len(cmds)) {
+		t.Errorf("bad COMMAND COUNT reply: %d (%v)", n, err)
+	}
+
+	docs, err := client.Do("COMMAND", "DOCS", "echo", "unknown").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []interface{}{"echo", []interface{}{"summary", "Echoes the given string", "group", "connection"}}
+
+	if !reflect.DeepEqual(expected, docs) {
+		t.Errorf("bad COMMAND DOCS reply: %#v", docs)
+	}
+}
+
+func testServerMonitor(t *testing.T, ctx context.Context) {
+	addr, shutdown := newSessionServer(t, redis.HandlerFunc(echoHandler))
+	defer shutdown()
+
+	mon := dialSessionServer(t, addr)
+	defer mon.Close()
+
+	if err := redis.ParseArgs(sendCommand(t, mon, "MONITOR"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := dialSessionServer(t, addr)
+	defer conn.Close()
+
+	sendCommand(t, conn, "SELECT", 2).Close()
+	sendCommand(t, conn, "ECHO", "a \"b\"\n").Close()
+	sendCommand(t, conn, "ECHO", strings.Repeat("x", 1000)).Close()
+
+	clientAddr := conn.LocalAddr().String()
+
+	for _, suffix := range []string{
+		` [0 ` + clientAddr + `] "SELECT" "2"`,
+		` [2 ` + clientAddr + `] "ECHO" "a \"b\"\n"`,
+		` [2 ` + clientAddr + `] "ECHO" "` + strings.Repeat("x", 128) + `"... (872 more bytes)`,
+	} {
+		line, err := redis.String(mon.ReadArgs())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasSuffix(line, suffix) {
+			t.Errorf("bad monitor line: %q", line)
+		}
+
+		if ts, err := strconv.ParseFloat(strings.Fields(line)[0], 64); err != nil || time.Since(time.Unix(int64(ts), 0)) > time.Minute {
+			t.Errorf("bad monitor timestamp: %q", line)
+		}
+	}
+}
+
+func testServerSlowLog(t *testing.T, ctx context.Context) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &redis.Server{
+		Handler: redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+			if r.Cmds[0].Cmd == "SLOW" {
+				time.Sleep(50 * time.Millisecond)
+			}
+			r.Close()
+			w.Write("OK")
+		}),
+		SlowLogThreshold: 20 * time.Millisecond,
+		SlowLogMaxLen:    2,
+	}
+	defer srv.Close()
+
+	go srv.Serve(l)
+
+	conn := dialSessionServer(t, l.Addr().String())
+	defer conn.Close()
+
+	sendCommand(t, conn, "CLIENT", "SETNAME", "slowpoke").Close()
+
+	long := make([]interface{}, 40)
+	for i := range long {
+		long[i] = strconv.Itoa(i)
+	}
+	long[0] = strings.Repeat("x", 200)
+
+	for _, args := range [][]interface{}{{"a"}, {"b"}, long} {
+		sendCommand(t, conn, "SLOW", args...).Close()
+		sendCommand(t, conn, "FAST", args...).Close()
+	}
+
+	var n int
+
+	if err := redis.ParseArgs(sendCommand(t, conn, "SLOWLOG", "LEN"), &n); err != nil || n != 2 {
+		t.Errorf("bad slow log length: %d (%v)", n, err)
+	}
+
+	// the reply is a list of entries, which are lists of values
+	var e []interface{}
+
+	if err := redis.ParseArgs(sendCommand(t, conn, "SLOWLOG", "GET", 1), &e); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e) != 6 {
+		t.Fatalf("bad slow log entry: %q", e)
+	}
+
+	if e[0] != int64(3) || e[2].(int64) < 50000 || string(e[4].([]byte)) != conn.LocalAddr().String() || string(e[5].([]byte)) != "slowpoke" {
+		t.Errorf("bad slow log entry: %q", e)
+	}
+
+	if args := e[3].([]interface{}); len(args) != 32 || args[0] != "SLOW" || args[1] != strings.Repeat("x", 128)+"... (72 more bytes)" || args[31] != "... (10 more arguments)" {
+		t.Errorf("bad arguments of slow log entry: %q", args)
+	}
+
+	rec := httptest.NewRecorder()
+	srv.ServeSlowLog(rec, httptest.NewRequest("GET", "/slowlog?count=5", nil))
+
+	var exported []redis.SlowLogEntry
+
+	if err := json.Unmarshal(rec.Body.Bytes(), &exported); err != nil {
+		t.Error(err)
+	} else if len(exported) != 2 || exported[0].ID != 3 || exported[1].ID != 2 || exported[1].Args[1] != "b" {
+		t.Errorf("bad exported slow log: %s", rec.Body.String())
+	}
+
+	if err := redis.ParseArgs(sendCommand(t, conn, "SLOWLOG", "RESET"), nil); err != nil {
+		t.Error(err)
+	}
+
+	if n := len(srv.SlowLog(-1)); n != 0 {
+		t.Errorf("the slow log was not reset: %d entries", n)
+	}
+}
+
+func testServerMaxConnsPerIP(t *testing.T, ctx context.Context) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &redis.Server{
+		Handler:       redis.HandlerFunc(echoHandler),
+		MaxConnsPerIP: 1,
+		ReadTimeout:   time.Second,
+	}
+	defer srv.Close()
+
+	go srv.Serve(l)
+
+	conn := dialSessionServer(t, l.Addr().String())
+
+	if s, err := redis.String(sendCommand(t, conn, "ECHO", "hello")); err != nil || s != "hello" {
+		t.Fatalf("bad reply: %q (%v)", s, err)
+	}
+
+	other := dialSessionServer(t, l.Addr().String())
+	defer other.Close()
+
+	if _, err := redis.String(sendCommand(t, other, "ECHO", "hello")); err == nil || err.Error() != redis.ErrMaxClientsPerIP.Error() {
+		t.Errorf("bad error: %v", err)
+	}
+
+	conn.Close()
+
+	// the server releases the connection asynchronously
+	for i := 0; ; i++ {
+		conn = dialSessionServer(t, l.Addr().String())
+
+		s, err := redis.String(sendCommand(t, conn, "ECHO", "world"))
+		if err == nil && s == "world" {
+			break
+		}
+		conn.Close()
+
+		if i == 20 {
+			t.Fatalf("the connection was not released: %v", err)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer conn.Close()
+
+	var info string
+
+	if err := redis.ParseArgs(sendCommand(t, conn, "INFO", "stats"), &info); err != nil || !strings.Contains(info, "\r\nrejected_connections:") || strings.Contains(info, "\r\nrejected_connections:0\r\n") {
+		t.Errorf("rejected connections were not counted: %q (%v)", info, err)
+	}
+}
+
+func testServerMaxClients(t *testing.T, ctx context.Context) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &redis.Server{
+		Handler:     redis.HandlerFunc(echoHandler),
+		MaxClients:  1,
+		ReadTimeout: time.Second,
+	}
+	defer srv.Close()
+
+	go srv.Serve(l)
+
+	conn := dialSessionServer(t, l.Addr().String())
+	defer conn.Close()
+
+	if s, err := redis.String(sendCommand(t, conn, "ECHO", "hello")); err != nil || s != "hello" {
+		t.Fatalf("bad reply: %q (%v)", s, err)
+	}
+
+	other := dialSessionServer(t, l.Addr().String())
+	defer other.Close()
+
+	if _, err := redis.String(sendCommand(t, other, "ECHO", "hello")); err == nil || err.Error() != redis.ErrMaxClients.Error() {
+		t.Errorf("bad error: %v", err)
+	}
+
+	var info string
+
+	if err := redis.ParseArgs(sendCommand(t, conn, "INFO", "stats"), &info); err != nil || !strings.Contains(info, "\r\nrejected_connections:1\r\n") {
+		t.Errorf("rejected connections were not counted: %q (%v)", info, err)
+	}
+}
+
+func testServerOutputBufferLimits(t *testing.T, ctx context.Context) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &redis.Server{
+		Handler: &testConcurrentHandler{Handler: redis.HandlerFunc(echoHandler)},
+		OutputBufferLimits: map[redis.ClientClass]redis.OutputBufferLimit{
+			redis.ClientNormal:  {Hard: 1024},
+			redis.ClientMonitor: {Hard: 100},
+		},
+		ReadTimeout: time.Second,
+	}
+	defer srv.Close()
+
+	go srv.Serve(l)
+
+	conn := dialSessionServer(t, l.Addr().String())
+	defer conn.Close()
+
+	// replies to pipelined commands are buffered until they were all served
+	value := strings.Repeat("x", 600)
+
+	if err := conn.WriteCommands(
+		redis.Command{Cmd: "ECHO", Args: redis.List(value)},
+		redis.Command{Cmd: "ECHO", Args: redis.List(value)},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := redis.String(conn.ReadArgs()); err == nil {
+		t.Error("the client was not disconnected after exceeding its output buffer limits")
+	}
+
+	monitor := dialSessionServer(t, l.Addr().String())
+	defer monitor.Close()
+
+	if err := redis.ParseArgs(sendCommand(t, monitor, "MONITOR"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	other := dialSessionServer(t, l.Addr().String())
+	defer other.Close()
+
+	if s, err := redis.String(sendCommand(t, other, "ECHO", value[:100])); err != nil || s != value[:100] {
+		t.Errorf("bad reply: %q (%v)", s, err)
+	}
+
+	// the monitor is disconnected without receiving the command
+	if b, err := ioutil.ReadAll(monitor); err != nil || len(b) != 0 {
+		t.Errorf("the monitor was not disconnected: %q (%v)", b, err)
+	}
+
+	var info string
+
+	if err := redis.ParseArgs(sendCommand(t, other, "INFO", "stats"), &info); err != nil || !strings.Contains(info, "\r\nclient_output_buffer_limit_disconnections:2\r\n") {
+		t.Errorf("disconnections were not counted: %q (%v)", info, err)
+	}
+}
+
+func testServerOutputBufferLimitsSequential(t *testing.T, ctx context.Context) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &redis.Server{
+		Handler: redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+			if r.Cmds[0].Cmd != "SUBSCRIBE" {
+				echoHandler(w, r)
+				return
+			}
+
+			var size int
+
+			r.Cmds[0].ParseArgs(&size)
+			r.Session.SetClass(redis.ClientPubSub)
+
+			conn, rw, err := w.(redis.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+
+			// the first message fits in the limits, the second doesn't
+			for _, n := range []int{100, size} {
+				fmt.Fprintf(rw, "$%d\r\n%s\r\n", n, strings.Repeat("x", n))
+
+				if err := rw.Flush(); err != nil {
+					return
+				}
+			}
+		}),
+		OutputBufferLimits: map[redis.ClientClass]redis.OutputBufferLimit{
+			redis.ClientNormal: {Hard: 1024},
+			redis.ClientPubSub: {Hard: 500},
+		},
+		ReadTimeout: time.Second,
+	}
+	defer srv.Close()
+
+	go srv.Serve(l)
+
+	conn := dialSessionServer(t, l.Addr().String())
+	defer conn.Close()
+
+	// replies within the limits are written, even when their total exceeds
+	// them as long as they're flushed in between
+	for i := 0; i != 3; i++ {
+		if s, err := redis.String(sendCommand(t, conn, "ECHO", strings.Repeat("x", 600))); err != nil || len(s) != 600 {
+			t.Errorf("bad reply: %d bytes (%v)", len(s), err)
+		}
+	}
+
+	if _, err := redis.String(sendCommand(t, conn, "ECHO", strings.Repeat("x", 2000))); err == nil {
+		t.Error("the client was not disconnected after exceeding its output buffer limits")
+	}
+
+	sub := dialSessionServer(t, l.Addr().String())
+	defer sub.Close()
+
+	if err := sub.WriteCommands(redis.Command{Cmd: "SUBSCRIBE", Args: redis.List(1000)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if s, err := redis.String(sub.ReadArgs()); err != nil || len(s) != 100 {
+		t.Errorf("bad message: %d bytes (%v)", len(s), err)
+	}
+
+	if _, err := redis.String(sub.ReadArgs()); err == nil {
+		t.Error("the PUB/SUB client was not disconnected after exceeding its output buffer limits")
+	}
+}
+
+func testServerRateLimit(t *testing.T, ctx context.Context) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &redis.Server{
+		Handler: redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+			if r.Cmds[0].Cmd == "AUTH" {
+				var user string
+
+				r.Cmds[0].ParseArgs(&user)
+				r.Session.SetUser(user)
+				w.Write("OK")
+				return
+			}
+
+			echoHandler(w, r)
+		}),
+		ConnRateLimit: redis.RateLimit{Rate: 10, Burst: 2},
+		UserRateLimit: redis.RateLimit{Rate: 1, Burst: 1},
+		ReadTimeout:   time.Second,
+	}
+	defer srv.Close()
+
+	go srv.Serve(l)
+
+	conn := dialSessionServer(t, l.Addr().String())
+	defer conn.Close()
+
+	for i := 0; i != 2; i++ {
+		if s, err := redis.String(sendCommand(t, conn, "ECHO", "hello")); err != nil || s != "hello" {
+			t.Errorf("bad reply: %q (%v)", s, err)
+		}
+	}
+
+	if _, err := redis.String(sendCommand(t, conn, "ECHO", "hello")); err == nil || err.Error() != redis.ErrRateLimited.Error() {
+		t.Errorf("bad error: %v", err)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if s, err := redis.String(sendCommand(t, conn, "ECHO", "world")); err != nil || s != "world" {
+		t.Errorf("bad reply after the bucket was refilled: %q (%v)", s, err)
+	}
+
+	// the bucket of a user is shared by all its connections
+	time.Sleep(150 * time.Millisecond)
+	sendCommand(t, conn, "AUTH", "alice").Close()
+
+	other := dialSessionServer(t, l.Addr().String())
+	defer other.Close()
+
+	sendCommand(t, other, "AUTH", "alice").Close()
+
+	if s, err := redis.String(sendCommand(t, conn, "ECHO", "hello")); err != nil || s != "hello" {
+		t.Errorf("bad reply: %q (%v)", s, err)
+	}
+
+	if _, err := redis.String(sendCommand(t, other, "ECHO", "hello")); err == nil || err.Error() != redis.ErrRateLimited.Error() {
+		t.Errorf("bad error: %v", err)
+	}
+
+	var info string
+
+	if err := redis.ParseArgs(sendCommand(t, other, "INFO", "stats"), &info); err == nil {
+		t.Errorf("INFO was served although the user exceeded its rate limit: %q", info)
+	}
+
+	// commands exceeding the limits wait for their turn with LimitWait
+	waiting := &redis.Server{
+		Handler:       redis.HandlerFunc(echoHandler),
+		ConnRateLimit: redis.RateLimit{Rate: 20, Burst: 1},
+		LimitWait:     time.Second,
+		ReadTimeout:   time.Second,
+	}
+	defer waiting.Close()
+
+	wl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go waiting.Serve(wl)
+
+	wconn := dialSessionServer(t, wl.Addr().String())
+	defer wconn.Close()
+
+	start := time.Now()
+
+	for i := 0; i != 3; i++ {
+		if s, err := redis.String(sendCommand(t, wconn, "ECHO", "hello")); err != nil || s != "hello" {
+			t.Errorf("bad reply: %q (%v)", s, err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("the commands were not delayed: %s", elapsed)
+	}
+}
+
+func testServerMaxInflight(t *testing.T, ctx context.Context) {
+	blocked := make(chan struct{})
+	unblock := make(chan struct{})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &redis.Server{
+		Handler: redis.HandlerFunc(func(w redis.ResponseWriter, r *redis.Request) {
+			if r.Cmds[0].Cmd == "BLOCK" {
+				close(blocked)
+				<-unblock
+			}
+
+			echoHandler(w, r)
+		}),
+		MaxInflight: 1,
+		ReadTimeout: time.Second,
+	}
+	defer srv.Close()
+
+	go srv.Serve(l)
+
+	conn := dialSessionServer(t, l.Addr().String())
+	defer conn.Close()
+
+	other := dialSessionServer(t, l.Addr().String())
+	defer other.Close()
+
+	if err := conn.WriteCommands(redis.Command{Cmd: "BLOCK", Args: redis.List("hello")}); err != nil {
+		t.Fatal(err)
+	}
+
+	<-blocked
+
+	if _, err := redis.String(sendCommand(t, other, "ECHO", "hello")); err == nil || err.Error() != redis.ErrMaxInflight.Error() {
+		t.Errorf("bad error: %v", err)
+	}
+
+	close(unblock)
+
+	if s, err := redis.String(conn.ReadArgs()); err != nil || s != "hello" {
+		t.Errorf("bad reply: %q (%v)", s, err)
+	}
+
+	if s, err := redis.String(sendCommand(t, other, "ECHO", "world")); err != nil || s != "world" {
+		t.Errorf("bad reply: %q (%v)", s, err)
+	}
+
+	var info string
+
+	if err := redis.ParseArgs(sendCommand(t, other, "INFO", "stats"), &info); err != nil || !strings.Contains(info, "\r\nrejected_commands:1\r\n") {
+		t.Errorf("rejected commands were not counted: %q (%v)", info, err)
+	}
+}
+
+type testInfoHandler struct {
+	redis.Handler
+}
+
+func (h *testInfoHandler) Info() []redis.InfoSection {
+	return []redis.InfoSection{
+		{Name: "Keyspace", Fields: []redis.InfoField{{Name: "db0", Value: "keys=1,expires=0"}}},
+	}
+}
+
+func newSessionServer(t *testing.T, handler redis.Handler) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &redis.Server{
+		Handler:      handler,
+		ReadTimeout:  time.Second,
+		WriteTimeout: time.Second,
+	}
+
+	go srv.Serve(l)
+
+	return l.Addr().String(), func() { srv.Close() }
+}
+
+func dialSessionServer(t *testing.T, addr string) *redis.Conn {
+	conn, err := redis.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	return conn
+}
+
+// sendCommand writes a command to conn and returns the arguments of the reply.
+func sendCommand(t *testing.T, conn *redis.Conn, cmd string, args ...interface{}) redis.Args {
+	if err := conn.WriteCommands(redis.Command{Cmd: cmd, Args: redis.List(args...)}); err != nil {
+		t.Error(err)
+	}
+
+	return conn.ReadArgs()
+}
+
+func echoHandler(w redis.ResponseWriter, r *redis.Request) {
+	if len(r.Cmds) != 1 { // transaction
+		w.WriteStream(len(r.Cmds))
+	}
+
+	for _, cmd := range r.Cmds {
+		var msg string
+
+		cmd.ParseArgs(&msg)
+		w.Write(msg)
+	}
+}
+
+type testConcurrentHandler struct {
+	redis.Handler
+}
+
+func (h *testConcurrentHandler) ServeConcurrently() bool { return true }
+
+type testCountingListener struct {
+	net.Listener
+	writes int32
+}
+
+func (l *testCountingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &testCountingConn{Conn: c, writes: &l.writes}, nil
+}
+
+type testCountingConn struct {
+	net.Conn
+	writes *int32
+}
+
+func (c *testCountingConn) Write(b []byte) (int, error) {
+	atomic.AddInt32(c.writes, 1)
+	return c.Conn.Write(b)
+}
+
+func BenchmarkServer_Pipeline(b *testing.B) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	srv := &redis.Server{Handler: redis.HandlerFunc(echoHandler)}
+	defer srv.Close()
+
+	go srv.Serve(l)
+
+	conn, err := redis.Dial("tcp", l.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	cmds := make([]redis.Command, 16)
+
+	b.ResetTimer()
+
+	for i := 0; i != b.N; i++ {
+		for j := range cmds {
+			cmds[j] = redis.Command{Cmd: "ECHO", Args: redis.List("hello")}
+		}
+
+		if err := conn.WriteCommands(cmds...); err != nil {
+			b.Fatal(err)
+		}
+
+		for range cmds {
+			if _, err := redis.String(conn.ReadArgs()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
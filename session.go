@@ -0,0 +1,749 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dolab/objconv/resp"
+)
+
+var (
+	// ErrUnblocked is the error returned by the context of blocking requests
+	// which were unblocked by a CLIENT UNBLOCK command with the ERROR reason.
+	ErrUnblocked = resp.NewError("UNBLOCKED client unblocked via CLIENT UNBLOCK")
+)
+
+// A Session represents the state of a client connection to a Server, it is
+// exposed to handlers by the Session field of server requests.
+//
+// Sessions are safe to use concurrently from multiple goroutines.
+type Session struct {
+	id        int64
+	addr      string
+	laddr     string
+	conn      *Conn
+	createdAt time.Time
+	context   context.Context
+	cancel    context.CancelFunc
+
+	mutex    sync.Mutex
+	name     string
+	user     string
+	db       int
+	lastCmd  string
+	lastTime time.Time
+	multi    bool
+	busy     bool
+	closing  bool
+	class    ClientClass
+	values   map[interface{}]interface{}
+	blocked  map[*blockContext]struct{}
+	watching bool
+
+	// rate is the token bucket limiting the commands of the connection, it is
+	// guarded by the mutex of the server's limiter.
+	rate tokenBucket
+
+	// limits and stats are set when the connection is accepted, output is the
+	// outputBuffer of the connection's class.
+	limits map[ClientClass]OutputBufferLimit
+	stats  *serverStats
+	output *outputBuffer
+}
+
+func newSession(ctx context.Context, id int64, c *Conn) *Session {
+	now := time.Now()
+
+	sess := &Session{
+		id:        id,
+		addr:      c.RemoteAddr().String(),
+		laddr:     c.LocalAddr().String(),
+		conn:      c,
+		createdAt: now,
+		lastTime:  now,
+	}
+	sess.context, sess.cancel = context.WithCancel(ctx)
+	return sess
+}
+
+// ID returns the unique identifier of the connection on the server, as
+// reported by the CLIENT ID command.
+func (sess *Session) ID() int64 {
+	return sess.id
+}
+
+// Addr returns the remote address of the client.
+func (sess *Session) Addr() string {
+	return sess.addr
+}
+
+// LocalAddr returns the address of the server that the client connected to.
+func (sess *Session) LocalAddr() string {
+	return sess.laddr
+}
+
+// CreatedAt returns the time at which the connection was accepted.
+func (sess *Session) CreatedAt() time.Time {
+	return sess.createdAt
+}
+
+// Name returns the name set by the client with CLIENT SETNAME.
+func (sess *Session) Name() string {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+	return sess.name
+}
+
+// SetName sets the name of the connection, as the CLIENT SETNAME command does.
+func (sess *Session) SetName(name string) {
+	sess.mutex.Lock()
+	sess.name = name
+	sess.mutex.Unlock()
+}
+
+// User returns the name of the user that the client authenticated as, or an
+// empty string if it didn't.
+func (sess *Session) User() string {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+	return sess.user
+}
+
+// SetUser sets the name of the user that the client authenticated as, handlers
+// serving AUTH commands call it so the commands of the connection are counted
+// against the UserRateLimit of the server.
+func (sess *Session) SetUser(user string) {
+	sess.mutex.Lock()
+	sess.user = user
+	sess.mutex.Unlock()
+}
+
+// Class returns the class of the connection, which determines its output
+// buffer limits.
+func (sess *Session) Class() ClientClass {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+	return sess.class
+}
+
+// SetClass sets the class of the connection, handlers serving SUBSCRIBE
+// commands call it with ClientPubSub before hijacking the connection so the
+// PUB/SUB output buffer limits of the server apply to it.
+func (sess *Session) SetClass(class ClientClass) {
+	sess.mutex.Lock()
+	sess.class = class
+	sess.mutex.Unlock()
+}
+
+// outputBuffer returns the outputBuffer accounting for the output of the
+// connection against the limits of its class, or nil if it has no limits.
+func (sess *Session) outputBuffer() *outputBuffer {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+
+	if sess.output == nil || sess.output.class != sess.class {
+		sess.output = newOutputBuffer(sess, sess.class)
+	}
+
+	return sess.output
+}
+
+// flushedOutput is called when the output of the connection was flushed, it
+// doesn't count against its limits anymore.
+func (sess *Session) flushedOutput() {
+	sess.mutex.Lock()
+	out := sess.output
+	sess.mutex.Unlock()
+
+	if out != nil {
+		out.reset()
+	}
+}
+
+// DB returns the index of the logical database selected on the connection.
+func (sess *Session) DB() int {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+	return sess.db
+}
+
+// LastCommand returns the name of the last command received on the connection,
+// and the time at which it was received.
+func (sess *Session) LastCommand() (string, time.Time) {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+	return sess.lastCmd, sess.lastTime
+}
+
+// Flags returns the flags of the connection in the format of CLIENT LIST, "b"
+// if a request is blocked, "x" if a transaction is being received, "O" for
+// MONITOR clients, "P" for PUB/SUB clients, "N" if there are no flags.
+func (sess *Session) Flags() string {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+
+	flags := ""
+
+	if len(sess.blocked) != 0 {
+		flags += "b"
+	}
+
+	if sess.multi {
+		flags += "x"
+	}
+
+	switch sess.class {
+	case ClientMonitor:
+		flags += "O"
+	case ClientPubSub:
+		flags += "P"
+	}
+
+	if len(flags) == 0 {
+		flags = "N"
+	}
+
+	return flags
+}
+
+// Value returns the value associated with key on the connection, or nil if
+// there were none.
+func (sess *Session) Value(key interface{}) interface{} {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+	return sess.values[key]
+}
+
+// SetValue associates value with key on the connection, handlers use it to
+// store state which lasts as long as the connection, like the identity of an
+// authenticated user. Passing a nil value removes the key.
+//
+// To avoid collisions, keys should be of types defined by the packages using
+// them, as with context values.
+func (sess *Session) SetValue(key interface{}, value interface{}) {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+
+	if value == nil {
+		delete(sess.values, key)
+		return
+	}
+
+	if sess.values == nil {
+		sess.values = make(map[interface{}]interface{})
+	}
+
+	sess.values[key] = value
+}
+
+// Close closes the connection, canceling the requests that are blocked on it.
+func (sess *Session) Close() error {
+	sess.cancel()
+	return sess.conn.Close()
+}
+
+// String returns the description of the connection in the format of CLIENT
+// LIST.
+func (sess *Session) String() string {
+	name, flags, db := sess.Name(), sess.Flags(), sess.DB()
+	cmd, last := sess.LastCommand()
+	now := time.Now()
+
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d cmd=%s",
+		sess.id, sess.addr, sess.laddr, name,
+		int64(now.Sub(sess.createdAt)/time.Second),
+		int64(now.Sub(last)/time.Second),
+		flags, db, strings.ToLower(cmd),
+	)
+}
+
+func (sess *Session) touch(cmd string) {
+	sess.mutex.Lock()
+	sess.lastCmd = cmd
+	sess.lastTime = time.Now()
+	sess.mutex.Unlock()
+}
+
+func (sess *Session) selectDB(db int) {
+	sess.mutex.Lock()
+	sess.db = db
+	sess.mutex.Unlock()
+}
+
+func (sess *Session) setMulti(multi bool) {
+	sess.mutex.Lock()
+	sess.multi = multi
+	sess.mutex.Unlock()
+}
+
+// setBusy marks the session as serving commands or as waiting for the next
+// ones, it returns false if the session was closed for being idle.
+func (sess *Session) setBusy(busy bool) bool {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+
+	if sess.closing {
+		return false
+	}
+
+	sess.busy = busy
+	return true
+}
+
+// closeIfIdle closes the connection if it is waiting for commands.
+func (sess *Session) closeIfIdle() {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+
+	if !sess.busy && !sess.closing {
+		sess.closing = true
+		sess.cancel()
+		sess.conn.Close()
+	}
+}
+
+// block detaches a request being served on the session from the server
+// timeouts, the returned context is canceled when the session's context is,
+// when the client disconnects, or when the session gets unblocked.
+func (sess *Session) block() *blockContext {
+	ctx, cancel := context.WithCancel(sess.context)
+	b := &blockContext{Context: ctx, cancel: cancel}
+
+	sess.mutex.Lock()
+	if sess.blocked == nil {
+		sess.blocked = make(map[*blockContext]struct{})
+	}
+	sess.blocked[b] = struct{}{}
+
+	c := sess.conn
+
+	// The replies to the commands pipelined before the blocking one must not
+	// wait for it to be unblocked, they are flushed while the write timeout
+	// still applies.
+	ferr := c.Flush()
+	c.conn.SetDeadline(time.Time{})
+
+	// Disconnections can only be detected if the client isn't pipelining more
+	// commands, otherwise reading from the connection would consume them.
+	watch := !sess.watching && c.buffered() == 0 && ferr == nil
+	sess.watching = sess.watching || watch
+	sess.mutex.Unlock()
+
+	if ferr != nil {
+		cancel()
+		return b
+	}
+	sess.flushedOutput()
+
+	if watch {
+		b.watcher = make(chan struct{})
+
+		go func() {
+			defer close(b.watcher)
+
+			if _, err := c.rbuffer.Peek(1); err != nil {
+				cancel()
+			}
+		}()
+	}
+
+	return b
+}
+
+// unblock cancels the contexts of the blocking requests being served on the
+// session, their Err method then returns err. The method returns false if no
+// requests were blocked.
+func (sess *Session) unblock(err error) bool {
+	ok := false
+
+	sess.mutex.Lock()
+	for b := range sess.blocked {
+		if b.unblock(err) {
+			ok = true
+		}
+	}
+	sess.mutex.Unlock()
+
+	return ok
+}
+
+// release must be called when a blocking request served on the session is
+// complete, it waits for the goroutine watching the connection to exit so the
+// server can resume reading from it.
+func (sess *Session) release(b *blockContext) {
+	sess.mutex.Lock()
+	delete(sess.blocked, b)
+	sess.mutex.Unlock()
+
+	b.cancel()
+
+	if b.watcher != nil {
+		sess.conn.conn.SetReadDeadline(time.Now())
+		<-b.watcher
+		sess.conn.conn.SetReadDeadline(time.Time{})
+
+		sess.mutex.Lock()
+		sess.watching = false
+		sess.mutex.Unlock()
+	}
+}
+
+// blockContext is the context of blocking requests, the error reported after
+// it was canceled depends on how the request was unblocked.
+type blockContext struct {
+	context.Context
+	cancel  context.CancelFunc
+	watcher chan struct{}
+
+	mutex sync.Mutex
+	err   error
+}
+
+func (ctx *blockContext) Err() error {
+	err := ctx.Context.Err()
+	if err == nil {
+		return nil
+	}
+
+	ctx.mutex.Lock()
+	if ctx.err != nil {
+		err = ctx.err
+	}
+	ctx.mutex.Unlock()
+
+	return err
+}
+
+func (ctx *blockContext) unblock(err error) bool {
+	ctx.mutex.Lock()
+	ok := ctx.err == nil && ctx.Context.Err() == nil
+	if ok {
+		ctx.err = err
+	}
+	ctx.mutex.Unlock()
+
+	if ok {
+		ctx.cancel()
+	}
+
+	return ok
+}
+
+// serveClient serves the built-in subcommand sub of CLIENT commands received on
+// sess, the method returns false if sub isn't one of them, in which case args
+// were not read.
+func (s *Server) serveClient(sess *Session, sub string, args Args) (interface{}, bool) {
+	switch sub = strings.ToUpper(sub); sub {
+	case "ID", "GETNAME", "INFO", "UNPAUSE":
+		if err := args.Close(); err != nil {
+			return errorf("ERR %s", err), true
+		}
+
+		switch sub {
+		case "ID":
+			return sess.ID(), true
+
+		case "GETNAME":
+			if name := sess.Name(); len(name) != 0 {
+				return []byte(name), true
+			}
+			return nil, true
+
+		case "INFO":
+			return []byte(sess.String() + "\n"), true
+
+		default:
+			s.unpause()
+			return "OK", true
+		}
+
+	case "SETNAME":
+		var name string
+
+		if err := ParseArgs(args, &name); err != nil {
+			return errorf("ERR %s", err), true
+		}
+
+		if strings.ContainsAny(name, " \r\n") {
+			return errorf("ERR Client names cannot contain spaces, newlines or special characters."), true
+		}
+
+		sess.SetName(name)
+		return "OK", true
+
+	case "LIST":
+		return s.clientList(args), true
+
+	case "KILL":
+		return s.clientKill(sess, args), true
+
+	case "PAUSE":
+		return s.clientPause(args), true
+
+	case "UNBLOCK":
+		return s.clientUnblock(args), true
+	}
+
+	return nil, false
+}
+
+// clientList serves CLIENT LIST commands, the ID filter is the only one which is
+// supported.
+func (s *Server) clientList(args Args) interface{} {
+	var (
+		opt string
+		ids map[int64]bool
+	)
+
+	if args.Next(&opt) {
+		if strings.ToUpper(opt) != "ID" {
+			args.Close()
+			return errorf("ERR CLIENT LIST only supports the ID filter")
+		}
+
+		var id int64
+
+		for ids = make(map[int64]bool); args.Next(&id); {
+			ids[id] = true
+		}
+	}
+
+	if err := args.Close(); err != nil {
+		return errorf("ERR %s", err)
+	}
+
+	var b bytes.Buffer
+
+	for _, sess := range s.sessions() {
+		if ids == nil || ids[sess.id] {
+			b.WriteString(sess.String())
+			b.WriteByte('\n')
+		}
+	}
+
+	return b.Bytes()
+}
+
+// clientKill serves CLIENT KILL commands, both the old form which closes the
+// connection of a client address, and the new one with ID, ADDR, LADDR and
+// SKIPME filters.
+func (s *Server) clientKill(self *Session, args Args) interface{} {
+	var (
+		list []string
+		arg  string
+	)
+
+	for args.Next(&arg) {
+		list = append(list, arg)
+	}
+
+	if err := args.Close(); err != nil {
+		return errorf("ERR %s", err)
+	}
+
+	switch {
+	case len(list) == 1:
+		for _, sess := range s.sessions() {
+			if sess.addr == list[0] {
+				sess.Close()
+				return "OK"
+			}
+		}
+		return errorf("ERR No such client")
+
+	case len(list) == 0 || len(list)%2 != 0:
+		return errorf("ERR syntax error")
+	}
+
+	var (
+		id         int64
+		addr       string
+		laddr      string
+		skipme     = true
+		filterByID = false
+	)
+
+	for i := 0; i < len(list); i += 2 {
+		value := list[i+1]
+
+		switch strings.ToUpper(list[i]) {
+		case "ID":
+			v, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return errorf("ERR client-id should be greater than 0")
+			}
+			id, filterByID = v, true
+
+		case "ADDR":
+			addr = value
+
+		case "LADDR":
+			laddr = value
+
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipme = true
+			case "no":
+				skipme = false
+			default:
+				return errorf("ERR syntax error")
+			}
+
+		default:
+			return errorf("ERR syntax error")
+		}
+	}
+
+	killed := 0
+
+	for _, sess := range s.sessions() {
+		switch {
+		case skipme && sess == self:
+		case filterByID && sess.id != id:
+		case len(addr) != 0 && sess.addr != addr:
+		case len(laddr) != 0 && sess.laddr != laddr:
+		default:
+			sess.Close()
+			killed++
+		}
+	}
+
+	return killed
+}
+
+// clientPause serves CLIENT PAUSE commands, only the ALL mode is supported.
+func (s *Server) clientPause(args Args) interface{} {
+	var (
+		timeout int64
+		mode    = "ALL"
+	)
+
+	if err := ParseArgs(args, &timeout, &mode); err != nil {
+		return errorf("ERR timeout is not an integer or out of range")
+	}
+
+	if timeout < 0 {
+		return errorf("ERR timeout is negative")
+	}
+
+	if strings.ToUpper(mode) != "ALL" {
+		return errorf("ERR CLIENT PAUSE only supports the ALL mode")
+	}
+
+	s.pause(time.Duration(timeout) * time.Millisecond)
+	return "OK"
+}
+
+// clientUnblock serves CLIENT UNBLOCK commands, replying 1 if a blocking
+// request was unblocked on the connection and 0 otherwise.
+func (s *Server) clientUnblock(args Args) interface{} {
+	var (
+		id     int64
+		reason = "TIMEOUT"
+	)
+
+	if err := ParseArgs(args, &id, &reason); err != nil {
+		return errorf("ERR %s", err)
+	}
+
+	var err error
+
+	switch strings.ToUpper(reason) {
+	case "TIMEOUT":
+		err = context.DeadlineExceeded
+	case "ERROR":
+		err = ErrUnblocked
+	default:
+		return errorf("ERR CLIENT UNBLOCK reason should be TIMEOUT or ERROR")
+	}
+
+	for _, sess := range s.sessions() {
+		if sess.id == id && sess.unblock(err) {
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// sessions returns the sessions of the connections served by s, sorted by id.
+func (s *Server) sessions() []*Session {
+	s.mutex.Lock()
+	list := make([]*Session, 0, len(s.connections))
+	for _, sess := range s.connections {
+		list = append(list, sess)
+	}
+	s.mutex.Unlock()
+
+	sort.Slice(list, func(i int, j int) bool {
+		return list[i].id < list[j].id
+	})
+
+	return list
+}
+
+// pause suspends serving commands, other than CLIENT commands, for the duration
+// d. Pausing the server while it's already paused extends the pause if it ends
+// later.
+func (s *Server) pause(d time.Duration) {
+	until := time.Now().Add(d)
+
+	s.mutex.Lock()
+
+	if until.After(s.pausedUntil) {
+		s.pausedUntil = until
+	}
+
+	if s.unpaused == nil {
+		s.unpaused = make(chan struct{})
+	}
+
+	s.mutex.Unlock()
+}
+
+func (s *Server) unpause() {
+	s.mutex.Lock()
+
+	s.pausedUntil = time.Time{}
+
+	if s.unpaused != nil {
+		close(s.unpaused)
+		s.unpaused = nil
+	}
+
+	s.mutex.Unlock()
+}
+
+// waitUnpaused blocks until the server isn't paused anymore, or ctx is canceled.
+// The method returns true if it had to wait.
+func (s *Server) waitUnpaused(ctx context.Context) bool {
+	for waited := false; ; waited = true {
+		s.mutex.Lock()
+		until, unpaused := s.pausedUntil, s.unpaused
+		s.mutex.Unlock()
+
+		d := time.Until(until)
+		if d <= 0 {
+			return waited
+		}
+
+		timer := time.NewTimer(d)
+
+		select {
+		case <-timer.C:
+		case <-unpaused:
+		case <-ctx.Done():
+			timer.Stop()
+			return true
+		}
+
+		timer.Stop()
+	}
+}
@@ -0,0 +1,196 @@
+package redis
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultSlowLogMaxLen is the number of entries retained by the slow log
+	// when Server.SlowLogMaxLen is zero.
+	defaultSlowLogMaxLen = 128
+
+	// Arguments of commands are truncated like redis does, to keep the memory
+	// used by the slow log bounded.
+	slowLogMaxArgc   = 32
+	slowLogMaxArgLen = 128
+)
+
+// A SlowLogEntry is a request recorded in the slow log of a Server because it
+// took longer than the configured threshold to serve.
+type SlowLogEntry struct {
+	// ID is a unique and increasing identifier of the entry.
+	ID int64 `json:"id"`
+
+	// Time at which the request was received.
+	Time time.Time `json:"time"`
+
+	// Duration is the time it took the handler to serve the request.
+	Duration time.Duration `json:"duration"`
+
+	// Args is the command of the request and its arguments, or EXEC for
+	// transactions. The list is truncated to 32 values of 128 bytes at most.
+	Args []string `json:"args"`
+
+	// Addr is the address of the client, and Name the name it set with CLIENT
+	// SETNAME.
+	Addr string `json:"addr"`
+	Name string `json:"name,omitempty"`
+
+	// Upstream is the address of the server which the request was forwarded
+	// to, for handlers like ReverseProxy.
+	Upstream string `json:"upstream,omitempty"`
+}
+
+// slowLog is a bounded list of entries, from the most recent to the oldest.
+type slowLog struct {
+	mutex   sync.Mutex
+	lastID  int64
+	entries []SlowLogEntry
+}
+
+func (log *slowLog) add(entry SlowLogEntry, maxLen int) {
+	log.mutex.Lock()
+
+	log.lastID++
+	entry.ID = log.lastID
+
+	if len(log.entries) < maxLen {
+		log.entries = append(log.entries, SlowLogEntry{})
+	} else if maxLen <= 0 {
+		log.mutex.Unlock()
+		return
+	}
+
+	copy(log.entries[1:], log.entries[:len(log.entries)-1])
+	log.entries[0] = entry
+
+	log.mutex.Unlock()
+}
+
+func (log *slowLog) get(n int) []SlowLogEntry {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	if n < 0 || n > len(log.entries) {
+		n = len(log.entries)
+	}
+
+	return append([]SlowLogEntry{}, log.entries[:n]...)
+}
+
+func (log *slowLog) len() int {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	return len(log.entries)
+}
+
+func (log *slowLog) reset() {
+	log.mutex.Lock()
+	log.entries = nil
+	log.mutex.Unlock()
+}
+
+// SlowLog returns the n most recent entries of the slow log of s, or all of
+// them if n is negative.
+func (s *Server) SlowLog(n int) []SlowLogEntry {
+	return s.slowlog.get(n)
+}
+
+// ServeSlowLog exports the slow log of s as a JSON array of entries, the number
+// of entries can be limited by the count query parameter.
+func (s *Server) ServeSlowLog(w http.ResponseWriter, r *http.Request) {
+	n := -1
+
+	if count := r.URL.Query().Get("count"); len(count) != 0 {
+		i, err := strconv.Atoi(count)
+		if err != nil {
+			http.Error(w, "invalid count: "+count, http.StatusBadRequest)
+			return
+		}
+		n = i
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.SlowLog(n))
+}
+
+// serveSlowLog serves SLOWLOG GET, LEN and RESET commands.
+func (s *Server) serveSlowLog(args Args) interface{} {
+	var (
+		sub   string
+		count = 10
+	)
+
+	if err := ParseArgs(args, &sub, &count); err != nil {
+		return errorf("ERR %s", err)
+	}
+
+	switch strings.ToUpper(sub) {
+	case "GET":
+		entries := s.slowlog.get(count)
+		reply := make([]interface{}, len(entries))
+
+		for i, e := range entries {
+			entry := []interface{}{
+				e.ID,
+				e.Time.Unix(),
+				int64(e.Duration / time.Microsecond),
+				e.Args,
+				[]byte(e.Addr),
+				[]byte(e.Name),
+			}
+
+			if len(e.Upstream) != 0 {
+				entry = append(entry, []byte(e.Upstream))
+			}
+
+			reply[i] = entry
+		}
+
+		return reply
+
+	case "LEN":
+		return s.slowlog.len()
+
+	case "RESET":
+		s.slowlog.reset()
+		return "OK"
+	}
+
+	return errorf("ERR unknown subcommand '%s'. Try SLOWLOG HELP.", sub)
+}
+
+// slowLogArgs loads the arguments of cmds in memory and returns the truncated
+// list recorded in the slow log if the request is slow.
+func slowLogArgs(cmds []Command) []string {
+	if len(cmds) != 1 {
+		return []string{"EXEC"}
+	}
+
+	cmd := &cmds[0]
+	cmd.loadByteArgs()
+
+	argv := []string{cmd.Cmd}
+
+	if args, ok := cmd.Args.(*byteArgs); ok {
+		for i, arg := range args.args {
+			if len(argv) == slowLogMaxArgc-1 && i != len(args.args)-1 {
+				argv = append(argv, "... ("+strconv.Itoa(len(args.args)-i)+" more arguments)")
+				break
+			}
+
+			if len(arg) > slowLogMaxArgLen {
+				argv = append(argv, string(arg[:slowLogMaxArgLen])+"... ("+strconv.Itoa(len(arg)-slowLogMaxArgLen)+" more bytes)")
+			} else {
+				argv = append(argv, string(arg))
+			}
+		}
+	}
+
+	return argv
+}
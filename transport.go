@@ -88,6 +88,16 @@ type Transport struct {
 	// to ping requests before discarding connections.
 	PingTimeout time.Duration
 
+	// ProxyProtocol is the version of the PROXY protocol headers (1 or 2) that
+	// the transport sends when it opens connections for server requests, so
+	// the upstream servers see the address of the client that requests are
+	// forwarded for. Zero disables the headers.
+	//
+	// Since the header applies to the whole connection, connections are then
+	// pooled per client, MaxIdleConns should be set to bound the number of
+	// idle connections.
+	ProxyProtocol int
+
 	once sync.Once
 	pool *connPool
 }
@@ -169,11 +179,26 @@ func (t *Transport) RoundTrip(req *Request) (*Response, error) {
 		ctx = context.Background()
 	}
 
-	conn := t.pool.getConn(req.Addr)
+	host := req.Addr
+	proxied := t.ProxyProtocol != 0 && req.Session != nil
+	if proxied {
+		host += " " + req.Session.addr
+	}
+	if req.readonly {
+		host += " readonly"
+	}
+
+	conn := t.pool.getConn(host)
 	if conn == nil {
 		network, address := splitNetworkAddress(req.Addr)
 
 		c, err := t.dialContext(ctx, network, address)
+		if err == nil && proxied {
+			if err = t.writeProxyHeader(c, req.Session); err != nil {
+				c.Close()
+			}
+		}
+
 		if err != nil {
 			if ctxErr := ctx.Err(); ctxErr != nil {
 				err = &net.OpError{
@@ -186,16 +211,24 @@ func (t *Transport) RoundTrip(req *Request) (*Response, error) {
 			return nil, err
 		}
 		conn = NewClientConn(c)
+
+		if req.readonly {
+			if err = readOnly(conn, t.pingTimeout()); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
 	}
 
 	var (
 		resch    = make(chan *Response, 1)
 		errch    = make(chan error, 1)
+		written  = make(chan struct{})
 		issuedAt = time.Now()
 	)
 
-	go t.writeRequest(conn, req, errch)
-	go t.readResponse(conn, req, resch)
+	go t.writeRequest(conn, req, errch, written)
+	go t.readResponse(conn, host, req, resch)
 
 	var (
 		res *Response
@@ -204,6 +237,9 @@ func (t *Transport) RoundTrip(req *Request) (*Response, error) {
 
 	select {
 	case res = <-resch:
+		// The request arguments are closed by the writer, the caller must not
+		// get the response before they're done being used.
+		<-written
 	case err = <-errch:
 	case <-ctx.Done():
 		err = ctx.Err()
@@ -226,35 +262,38 @@ func (t *Transport) RoundTrip(req *Request) (*Response, error) {
 	return res, err
 }
 
-func (t *Transport) writeRequest(conn *Conn, req *Request, errch chan<- error) {
+func (t *Transport) writeRequest(conn *Conn, req *Request, errch chan<- error, written chan<- struct{}) {
 	err := conn.WriteCommands(req.Cmds...)
 
 	req.Close()
+	close(written)
 
 	if err != nil {
 		errch <- err
 	}
 }
 
-func (t *Transport) readResponse(conn *Conn, req *Request, resch chan<- *Response) {
+// readResponse reads the response to req from conn, which is put back in the
+// pool of host once the response was consumed.
+func (t *Transport) readResponse(conn *Conn, host string, req *Request, resch chan<- *Response) {
 	var res *Response
 
 	if req.IsTransaction() {
-		res = t.readTransactionResponse(conn, req)
+		res = t.readTransactionResponse(conn, host, req)
 	} else {
-		res = t.readSimpleResponse(conn, req)
+		res = t.readSimpleResponse(conn, host, req)
 	}
 
 	resch <- res
 }
 
-func (t *Transport) readTransactionResponse(conn *Conn, req *Request) *Response {
+func (t *Transport) readTransactionResponse(conn *Conn, host string, req *Request) *Response {
 	args := conn.ReadTxArgs(len(req.Cmds) - 2)
 
 	return &Response{
 		TxArgs: &transportTxArgs{
 			connPoolPutter: connPoolPutter{
-				host: req.Addr,
+				host: host,
 				conn: conn,
 				pool: t.pool,
 			},
@@ -264,13 +303,13 @@ func (t *Transport) readTransactionResponse(conn *Conn, req *Request) *Response
 	}
 }
 
-func (t *Transport) readSimpleResponse(conn *Conn, req *Request) *Response {
+func (t *Transport) readSimpleResponse(conn *Conn, host string, req *Request) *Response {
 	args := conn.ReadArgs()
 
 	return &Response{
 		Args: &transportArgs{
 			connPoolPutter: connPoolPutter{
-				host: req.Addr,
+				host: host,
 				conn: conn,
 				pool: t.pool,
 			},
@@ -317,6 +356,15 @@ func (t *Transport) dialContext(ctx context.Context, network string, address str
 	return dialContext(ctx, network, address)
 }
 
+// writeProxyHeader writes to c the PROXY protocol header carrying the addresses
+// of the client of sess.
+func (t *Transport) writeProxyHeader(c net.Conn, sess *Session) error {
+	c.SetWriteDeadline(time.Now().Add(t.pingTimeout()))
+	defer c.SetWriteDeadline(time.Time{})
+
+	return WriteProxyHeader(c, t.ProxyProtocol, tcpAddr(sess.addr), tcpAddr(sess.laddr))
+}
+
 func (t *Transport) pingTimeout() time.Duration {
 	if pingTimeout := t.PingTimeout; pingTimeout != 0 {
 		return pingTimeout
@@ -385,3 +433,19 @@ func splitNetworkAddress(s string) (string, string) {
 
 	return "tcp", s
 }
+
+// readOnly puts conn in READONLY mode, so the replica of a redis cluster node
+// that it's connected to serves reads of keys of the slots of the node.
+func readOnly(conn *Conn, timeout time.Duration) (err error) {
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return
+	}
+	if err = conn.WriteCommands(Command{Cmd: "READONLY"}); err != nil {
+		return
+	}
+	if err = conn.ReadArgs().Close(); err != nil {
+		return
+	}
+	err = conn.SetDeadline(time.Time{})
+	return
+}